@@ -3,6 +3,8 @@ package ast
 import (
 	"bytes"
 	"fmt"
+	"math/big"
+	"strings"
 
 	"interpego/token"
 )
@@ -10,6 +12,8 @@ import (
 type Node interface {
 	TokenLiteral() string
 	String() string
+	// Pos returns where in the source the node starts
+	Pos() token.Position
 }
 
 type Statement interface {
@@ -22,6 +26,13 @@ type Expression interface {
 	expressionNode()
 }
 
+// Pattern is the part of an arm of a match expression that values are tested
+// against.
+type Pattern interface {
+	Node
+	patternNode()
+}
+
 type LetStatement struct {
 	Token token.Token // this is the LET token
 	Name  *Identifier
@@ -30,6 +41,7 @@ type LetStatement struct {
 
 func (ls *LetStatement) statementNode()       {}
 func (ls *LetStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LetStatement) Pos() token.Position  { return ls.Token.Position }
 func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(ls.TokenLiteral() + " ")
@@ -39,6 +51,7 @@ func (ls *LetStatement) String() string {
 	if ls.Value != nil {
 		out.WriteString(ls.Value.String())
 	}
+	out.WriteString(";")
 	return out.String()
 }
 
@@ -49,6 +62,7 @@ type ReturnStatement struct {
 
 func (rs *ReturnStatement) statementNode()       {}
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
+func (rs *ReturnStatement) Pos() token.Position  { return rs.Token.Position }
 func (rs *ReturnStatement) String() string {
 	var out bytes.Buffer
 	out.WriteString(rs.TokenLiteral() + " ")
@@ -59,6 +73,35 @@ func (rs *ReturnStatement) String() string {
 	return out.String()
 }
 
+// ThrowStatement is `throw value`, which raises value as an error that a try
+// expression can catch.
+type ThrowStatement struct {
+	Token token.Token // the THROW token
+	Value Expression
+}
+
+func (ts *ThrowStatement) statementNode()       {}
+func (ts *ThrowStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *ThrowStatement) Pos() token.Position  { return ts.Token.Position }
+func (ts *ThrowStatement) String() string {
+	return "throw " + ts.Value.String() + ";"
+}
+
+// DeferStatement is `defer call` in a function body. The function and its
+// arguments are evaluated when the statement runs, and the call is made when
+// the enclosing function returns.
+type DeferStatement struct {
+	Token token.Token // the DEFER token
+	Call  *CallExpression
+}
+
+func (ds *DeferStatement) statementNode()       {}
+func (ds *DeferStatement) TokenLiteral() string { return ds.Token.Literal }
+func (ds *DeferStatement) Pos() token.Position  { return ds.Token.Position }
+func (ds *DeferStatement) String() string {
+	return "defer " + ds.Call.String() + ";"
+}
+
 type ExpressionStatement struct {
 	Token      token.Token // this is the first token in the expression
 	Expression Expression
@@ -66,6 +109,7 @@ type ExpressionStatement struct {
 
 func (es *ExpressionStatement) statementNode()       {}
 func (es *ExpressionStatement) TokenLiteral() string { return es.Token.Literal }
+func (es *ExpressionStatement) Pos() token.Position  { return es.Token.Position }
 func (es *ExpressionStatement) String() string {
 	if es.Expression != nil {
 		return es.Expression.String()
@@ -82,6 +126,7 @@ type Identifier struct {
 // like let a = b; where b is an expression
 func (i *Identifier) expressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
+func (i *Identifier) Pos() token.Position  { return i.Token.Position }
 func (i *Identifier) String() string {
 	return i.Value
 }
@@ -89,10 +134,13 @@ func (i *Identifier) String() string {
 type IntegerLiteral struct {
 	Token token.Token
 	Value int64
+	// Big holds the value instead of Value when it doesn't fit in 64 bits
+	Big *big.Int
 }
 
 func (il *IntegerLiteral) expressionNode()      {}
 func (il *IntegerLiteral) TokenLiteral() string { return il.Token.Literal }
+func (il *IntegerLiteral) Pos() token.Position  { return il.Token.Position }
 func (il *IntegerLiteral) String() string       { return il.Token.Literal }
 
 type StringLiteral struct {
@@ -102,8 +150,187 @@ type StringLiteral struct {
 
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *StringLiteral) Pos() token.Position  { return sl.Token.Position }
 func (sl *StringLiteral) String() string       { return sl.Token.Literal }
 
+// ImportExpression is `import "path"`, which evaluates to a hash of the
+// module's exported top-level bindings.
+type ImportExpression struct {
+	Token token.Token // the IMPORT token
+	Path  string
+}
+
+func (ie *ImportExpression) expressionNode()      {}
+func (ie *ImportExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *ImportExpression) Pos() token.Position  { return ie.Token.Position }
+func (ie *ImportExpression) String() string       { return fmt.Sprintf("import %q", ie.Path) }
+
+// TryExpression is `try { } catch (e) { } finally { }`. Either the catch or
+// the finally block may be left out, but not both. Its value is the try
+// block's, or the catch block's if the try block raised an error.
+type TryExpression struct {
+	Token      token.Token // the TRY token
+	Block      *BlockStatement
+	CatchParam *Identifier
+	Catch      *BlockStatement
+	Finally    *BlockStatement
+}
+
+func (te *TryExpression) expressionNode()      {}
+func (te *TryExpression) TokenLiteral() string { return te.Token.Literal }
+func (te *TryExpression) Pos() token.Position  { return te.Token.Position }
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("try {" + te.Block.String() + "}")
+	if te.Catch != nil {
+		out.WriteString(" catch (" + te.CatchParam.String() + ") {" + te.Catch.String() + "}")
+	}
+	if te.Finally != nil {
+		out.WriteString(" finally {" + te.Finally.String() + "}")
+	}
+	return out.String()
+}
+
+// MatchExpression is `match (value) { pattern => result, ... }`. Its value is
+// the result of the first arm whose pattern matches the value and whose guard,
+// if it has one, holds.
+type MatchExpression struct {
+	Token token.Token // the MATCH token
+	Value Expression
+	Arms  []*MatchArm
+}
+
+func (me *MatchExpression) expressionNode()      {}
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MatchExpression) Pos() token.Position  { return me.Token.Position }
+func (me *MatchExpression) String() string {
+	arms := make([]string, len(me.Arms))
+	for i, arm := range me.Arms {
+		arms[i] = arm.String()
+	}
+	return "match (" + me.Value.String() + ") {" + strings.Join(arms, ", ") + "}"
+}
+
+// MatchArm is `pattern => result` or `pattern if guard => result`. Names the
+// pattern binds are bound like a let, so the guard and result can use them.
+type MatchArm struct {
+	Pattern Pattern
+	Guard   Expression // nil without a guard
+	Result  Expression
+}
+
+func (ma *MatchArm) String() string {
+	if ma.Guard == nil {
+		return ma.Pattern.String() + " => " + ma.Result.String()
+	}
+	return ma.Pattern.String() + " if " + ma.Guard.String() + " => " + ma.Result.String()
+}
+
+// WildcardPattern is `_`, which matches any value.
+type WildcardPattern struct {
+	Token token.Token // the IDENT token
+}
+
+func (wp *WildcardPattern) patternNode()         {}
+func (wp *WildcardPattern) TokenLiteral() string { return wp.Token.Literal }
+func (wp *WildcardPattern) Pos() token.Position  { return wp.Token.Position }
+func (wp *WildcardPattern) String() string       { return "_" }
+
+// BindingPattern matches any value, and binds it to Name.
+type BindingPattern struct {
+	Name *Identifier
+}
+
+func (bp *BindingPattern) patternNode()         {}
+func (bp *BindingPattern) TokenLiteral() string { return bp.Name.TokenLiteral() }
+func (bp *BindingPattern) Pos() token.Position  { return bp.Name.Pos() }
+func (bp *BindingPattern) String() string       { return bp.Name.String() }
+
+// LiteralPattern matches values equal to Value, which is an integer, string or
+// boolean literal.
+type LiteralPattern struct {
+	Value Expression
+}
+
+func (lp *LiteralPattern) patternNode()         {}
+func (lp *LiteralPattern) TokenLiteral() string { return lp.Value.TokenLiteral() }
+func (lp *LiteralPattern) Pos() token.Position  { return lp.Value.Pos() }
+func (lp *LiteralPattern) String() string       { return lp.Value.String() }
+
+// ArrayPattern is `[a, b]` or `[a, ...rest]`. It matches arrays whose elements
+// match Elements: exactly as many of them, unless there is a rest pattern,
+// which matches an array of the elements left over.
+type ArrayPattern struct {
+	Token    token.Token // the [ token
+	Elements []Pattern
+	HasRest  bool
+	// Rest is nil for a bare `...`, which ignores the rest of the array
+	Rest Pattern
+}
+
+func (ap *ArrayPattern) patternNode()         {}
+func (ap *ArrayPattern) TokenLiteral() string { return ap.Token.Literal }
+func (ap *ArrayPattern) Pos() token.Position  { return ap.Token.Position }
+func (ap *ArrayPattern) String() string {
+	elements := make([]string, 0, len(ap.Elements)+1)
+	for _, elem := range ap.Elements {
+		elements = append(elements, elem.String())
+	}
+	if ap.Rest != nil {
+		elements = append(elements, "..."+ap.Rest.String())
+	} else if ap.HasRest {
+		elements = append(elements, "...")
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// HashPattern is `{"key": pattern, ...}`. It matches hashes that have all of
+// Keys, which are literals, with values matching the corresponding Values.
+// Other keys are ignored.
+type HashPattern struct {
+	Token  token.Token // the { token
+	Keys   []Expression
+	Values []Pattern
+}
+
+func (hp *HashPattern) patternNode()         {}
+func (hp *HashPattern) TokenLiteral() string { return hp.Token.Literal }
+func (hp *HashPattern) Pos() token.Position  { return hp.Token.Position }
+func (hp *HashPattern) String() string {
+	pairs := make([]string, len(hp.Keys))
+	for i, key := range hp.Keys {
+		pairs[i] = key.String() + ": " + hp.Values[i].String()
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// InterpolatedString is a string literal with ${...} expressions in it. The
+// text between the expressions is kept in Strings, so there is always one
+// more string than there are values; strings at either end may be empty.
+type InterpolatedString struct {
+	Token   token.Token // the INTERP_START token
+	Strings []string
+	Values  []Expression
+}
+
+func (is *InterpolatedString) expressionNode()      {}
+func (is *InterpolatedString) TokenLiteral() string { return is.Token.Literal }
+func (is *InterpolatedString) Pos() token.Position  { return is.Token.Position }
+func (is *InterpolatedString) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(`"`)
+	for i, value := range is.Values {
+		out.WriteString(is.Strings[i])
+		out.WriteString("${")
+		out.WriteString(value.String())
+		out.WriteString("}")
+	}
+	out.WriteString(is.Strings[len(is.Strings)-1])
+	out.WriteString(`"`)
+	return out.String()
+}
+
 type BooleanLiteral struct {
 	Token token.Token
 	Value bool
@@ -111,6 +338,7 @@ type BooleanLiteral struct {
 
 func (bl *BooleanLiteral) expressionNode()      {}
 func (bl *BooleanLiteral) TokenLiteral() string { return bl.Token.Literal }
+func (bl *BooleanLiteral) Pos() token.Position  { return bl.Token.Position }
 func (bl *BooleanLiteral) String() string       { return bl.Token.Literal }
 
 type ArrayLiteral struct {
@@ -120,6 +348,7 @@ type ArrayLiteral struct {
 
 func (al *ArrayLiteral) expressionNode()      {}
 func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) Pos() token.Position  { return al.Token.Position }
 func (al *ArrayLiteral) String() string {
 	var out bytes.Buffer
 
@@ -142,6 +371,7 @@ type PrefixExpression struct {
 
 func (pe *PrefixExpression) expressionNode()      {}
 func (pe *PrefixExpression) TokenLiteral() string { return pe.Token.Literal }
+func (pe *PrefixExpression) Pos() token.Position  { return pe.Token.Position }
 func (pe *PrefixExpression) String() string {
 	var out bytes.Buffer
 
@@ -162,6 +392,7 @@ type InfixExpression struct {
 
 func (ie *InfixExpression) expressionNode()      {}
 func (ie *InfixExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *InfixExpression) Pos() token.Position  { return ie.Token.Position }
 func (ie *InfixExpression) String() string {
 	var out bytes.Buffer
 
@@ -186,6 +417,13 @@ func (p *Program) TokenLiteral() string {
 	}
 }
 
+func (p *Program) Pos() token.Position {
+	if len(p.Statements) > 0 {
+		return p.Statements[0].Pos()
+	}
+	return token.Position{}
+}
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 	for _, s := range p.Statements {
@@ -206,6 +444,10 @@ func (ie *IfExpression) TokenLiteral() string {
 	return ie.Token.Literal
 }
 
+func (ie *IfExpression) Pos() token.Position {
+	return ie.Token.Position
+}
+
 func (ie *IfExpression) String() string {
 	var out bytes.Buffer
 
@@ -232,6 +474,10 @@ func (bs *BlockStatement) TokenLiteral() string {
 	return bs.Token.Literal
 }
 
+func (bs *BlockStatement) Pos() token.Position {
+	return bs.Token.Position
+}
+
 func (bs *BlockStatement) String() string {
 	var out bytes.Buffer
 	for _, s := range bs.Statements {
@@ -253,6 +499,10 @@ func (fl *ForLoop) TokenLiteral() string {
 	return fl.Token.Literal
 }
 
+func (fl *ForLoop) Pos() token.Position {
+	return fl.Token.Position
+}
+
 func (fl *ForLoop) String() string {
 	var out bytes.Buffer
 
@@ -269,6 +519,7 @@ type FunctionLiteral struct {
 	Token        token.Token // the fn token
 	Parameters   []*Identifier
 	FunctionBody *BlockStatement
+	Name         string // set when the literal is bound with a let statement
 }
 
 func (fl *FunctionLiteral) expressionNode() {}
@@ -276,6 +527,10 @@ func (fl *FunctionLiteral) TokenLiteral() string {
 	return fl.Token.Literal
 }
 
+func (fl *FunctionLiteral) Pos() token.Position {
+	return fl.Token.Position
+}
+
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
 
@@ -300,6 +555,9 @@ type CallExpression struct {
 	// actually this can only be an Identifier which maps to an expression, or it can be a FunctionLiteral, but not any arbitrary expression
 	Function  Expression
 	Arguments []Expression
+	// Tail is set by the parser when the call is the last thing its enclosing
+	// function does, so its result is returned as is
+	Tail bool
 }
 
 func (ce *CallExpression) expressionNode() {}
@@ -307,6 +565,10 @@ func (ce *CallExpression) TokenLiteral() string {
 	return ce.Token.Literal
 }
 
+func (ce *CallExpression) Pos() token.Position {
+	return ce.Token.Position
+}
+
 func (ce *CallExpression) String() string {
 	var out bytes.Buffer
 
@@ -323,7 +585,7 @@ func (ce *CallExpression) String() string {
 }
 
 type IndexExpression struct {
-	Token token.Token // LBRACKET
+	Token token.Token // LBRACKET, or DOT for `left.name`
 	Left  Expression  // this is the ident of the array, hash, or literal
 	Index Expression
 }
@@ -333,11 +595,21 @@ func (aie *IndexExpression) TokenLiteral() string {
 	return aie.Token.Literal
 }
 
+func (aie *IndexExpression) Pos() token.Position {
+	return aie.Token.Position
+}
+
 func (aie *IndexExpression) String() string {
 	var out bytes.Buffer
 
 	out.WriteString("(")
 	out.WriteString(aie.Left.String())
+	if aie.Token.Type == token.DOT {
+		out.WriteString(".")
+		out.WriteString(aie.Index.TokenLiteral())
+		out.WriteString(")")
+		return out.String()
+	}
 	out.WriteString("[")
 	out.WriteString(aie.Index.String())
 	out.WriteString("])")
@@ -347,6 +619,9 @@ func (aie *IndexExpression) String() string {
 type HashLiteral struct {
 	Token token.Token // { token
 	Pairs map[Expression]Expression
+	// Keys lists the keys of Pairs in source order, which is the order they
+	// are evaluated in
+	Keys []Expression
 }
 
 func (h *HashLiteral) expressionNode() {}
@@ -354,14 +629,21 @@ func (h *HashLiteral) TokenLiteral() string {
 	return h.Token.Literal
 }
 
+func (h *HashLiteral) Pos() token.Position {
+	return h.Token.Position
+}
+
 func (h *HashLiteral) String() string {
 	var out bytes.Buffer
 
 	out.WriteString("{")
-	for k, v := range h.Pairs {
+	for i, k := range h.Keys {
+		if i > 0 {
+			out.WriteString(", ")
+		}
 		out.WriteString(k.String())
 		out.WriteString(": ")
-		out.WriteString(v.String())
+		out.WriteString(h.Pairs[k].String())
 	}
 	out.WriteString("}")
 	return out.String()
@@ -32,6 +32,30 @@ const (
 	OpCall
 	OpSetLocal
 	OpGetLocal
+	OpAddConstant
+	OpDup
+	OpConstantWide
+	OpSetLocalWide
+	OpGetLocalWide
+	OpCallWide
+	OpTailCall
+	OpArray
+	OpGetBuiltin
+	OpIndex
+	OpInterpolate
+	OpHash
+	OpImport
+	OpTry
+	OpEndTry
+	OpThrow
+	OpCatch
+	OpDefer
+	OpMatchValue
+	OpMatchArray
+	OpMatchArrayMin
+	OpArrayRest
+	OpMatchHash
+	OpNoMatch
 )
 
 type (
@@ -84,6 +108,8 @@ func ReadOperands(ins Instructions, def *Definition) ([]int, int) {
 
 	for _, w := range def.OperandWidths {
 		switch w {
+		case 4:
+			operands[offset] = int(binary.BigEndian.Uint32(ins[offset:]))
 		case 2:
 			operands[offset] = int(binary.BigEndian.Uint16(ins[offset:]))
 		case 1:
@@ -117,8 +143,8 @@ var definitions = map[Opcode]*Definition{
 	OpEqual:         {Name: "OpEqual", OperandWidths: []int{}},
 	OpNotEqual:      {Name: "OpNotEqual", OperandWidths: []int{}},
 	OpGreaterThan:   {Name: "OpGreaterThan", OperandWidths: []int{}},
-	OpJumpNotTruthy: {Name: "OpJumpNotTruthy", OperandWidths: []int{2}},
-	OpJump:          {Name: "OpJump", OperandWidths: []int{2}},
+	OpJumpNotTruthy: {Name: "OpJumpNotTruthy", OperandWidths: []int{4}},
+	OpJump:          {Name: "OpJump", OperandWidths: []int{4}},
 	OpSetGlobal:     {Name: "OpSetGlobal", OperandWidths: []int{2}},
 	OpGetGlobal:     {Name: "OpGetGlobal", OperandWidths: []int{2}},
 	OpReturnValue:   {Name: "OpReturnValue", OperandWidths: []int{}},
@@ -126,6 +152,50 @@ var definitions = map[Opcode]*Definition{
 	OpCall:          {Name: "OpCall", OperandWidths: []int{1}},
 	OpSetLocal:      {Name: "OpSetLocal", OperandWidths: []int{1}},
 	OpGetLocal:      {Name: "OpGetLocal", OperandWidths: []int{1}},
+	// superinstructions emitted by the compiler's peephole pass
+	OpAddConstant: {Name: "OpAddConstant", OperandWidths: []int{2}},
+	OpDup:         {Name: "OpDup", OperandWidths: []int{}},
+	// wide variants, emitted when an operand doesn't fit the regular encoding
+	OpConstantWide: {Name: "OpConstantWide", OperandWidths: []int{4}},
+	OpSetLocalWide: {Name: "OpSetLocalWide", OperandWidths: []int{2}},
+	OpGetLocalWide: {Name: "OpGetLocalWide", OperandWidths: []int{2}},
+	OpCallWide:     {Name: "OpCallWide", OperandWidths: []int{2}},
+	OpTailCall:     {Name: "OpTailCall", OperandWidths: []int{1}},
+	OpArray:        {Name: "OpArray", OperandWidths: []int{2}},
+	OpGetBuiltin:   {Name: "OpGetBuiltin", OperandWidths: []int{1}},
+	OpIndex:        {Name: "OpIndex", OperandWidths: []int{}},
+	// joins the Inspect form of the given number of values into a string
+	OpInterpolate: {Name: "OpInterpolate", OperandWidths: []int{2}},
+	// builds a hash from the given number of keys and values, alternating
+	OpHash: {Name: "OpHash", OperandWidths: []int{2}},
+	// runs the module whose init function is the given constant, the first
+	// time it is imported, and loads the hash of its exports
+	OpImport: {Name: "OpImport", OperandWidths: []int{4}},
+	// enters a try block whose handler starts at the given address; an error
+	// raised before the matching OpEndTry unwinds to the handler, with the
+	// error on top of the stack
+	OpTry:    {Name: "OpTry", OperandWidths: []int{4}},
+	OpEndTry: {Name: "OpEndTry", OperandWidths: []int{}},
+	// raises the value on top of the stack as an error
+	OpThrow: {Name: "OpThrow", OperandWidths: []int{}},
+	// replaces the error a handler starts with by the value a catch block sees
+	OpCatch: {Name: "OpCatch", OperandWidths: []int{}},
+	// takes the function below the given number of arguments off the stack,
+	// to be called with them when the current function returns
+	OpDefer: {Name: "OpDefer", OperandWidths: []int{1}},
+	// pattern matching: each test takes the subject off the stack and pushes
+	// whether it matches. OpMatchValue compares it with the literal above it;
+	// the array tests check for an array of exactly, or at least, the given
+	// number of elements; OpMatchHash checks for a hash that has each of the
+	// given number of keys above it
+	OpMatchValue:    {Name: "OpMatchValue", OperandWidths: []int{}},
+	OpMatchArray:    {Name: "OpMatchArray", OperandWidths: []int{2}},
+	OpMatchArrayMin: {Name: "OpMatchArrayMin", OperandWidths: []int{2}},
+	OpMatchHash:     {Name: "OpMatchHash", OperandWidths: []int{2}},
+	// replaces an array by a new one of its elements after the given index
+	OpArrayRest: {Name: "OpArrayRest", OperandWidths: []int{2}},
+	// raises the error for a subject no arm of a match expression matched
+	OpNoMatch: {Name: "OpNoMatch", OperandWidths: []int{}},
 }
 
 func Lookup(op byte) (*Definition, error) {
@@ -136,21 +206,24 @@ func Lookup(op byte) (*Definition, error) {
 	return def, nil
 }
 
-// Make creates a new instruction sequence from an opcode and a set of operands.
-// It is currently a stub and returns an empty byte slice.
-func Make(op Opcode, operands ...int) []byte {
+// Make creates a new instruction from an opcode and its operands. It returns
+// an error if the number of operands doesn't match the opcode's definition or
+// if an operand doesn't fit in its width.
+func Make(op Opcode, operands ...int) ([]byte, error) {
 	def, ok := definitions[op]
 	if !ok {
-		panic(fmt.Sprintf("unable create instruction for the following opcode: %d", op))
-		// return []byte{}
+		return nil, fmt.Errorf("opcode %d undefined", op)
 	}
 
 	if len(operands) != len(def.OperandWidths) {
-		return []byte{}
+		return nil, fmt.Errorf("%s expects %d operands. got=%d", def.Name, len(def.OperandWidths), len(operands))
 	}
 
 	instructionLength := 1
-	for _, operandWidth := range def.OperandWidths {
+	for i, operandWidth := range def.OperandWidths {
+		if operands[i] < 0 || uint64(operands[i]) > MaxOperand(operandWidth) {
+			return nil, fmt.Errorf("operand %d of %s does not fit in %d bytes", operands[i], def.Name, operandWidth)
+		}
 		instructionLength += operandWidth
 	}
 
@@ -161,6 +234,8 @@ func Make(op Opcode, operands ...int) []byte {
 	for i, o := range operands {
 		width := def.OperandWidths[i]
 		switch width {
+		case 4:
+			binary.BigEndian.PutUint32(instructions[offset:], uint32(o))
 		case 2:
 			// Converts the operand 'o' to a 2-byte sequence and stores it in 'instructions' starting at the 'offset' index.
 			binary.BigEndian.PutUint16(instructions[offset:], uint16(o))
@@ -170,13 +245,32 @@ func Make(op Opcode, operands ...int) []byte {
 		offset += width
 	}
 
-	return instructions
+	return instructions, nil
+}
+
+// MustMake is like Make but panics if the instruction can't be encoded. It is
+// meant for operands that are known to fit.
+func MustMake(op Opcode, operands ...int) []byte {
+	ins, err := Make(op, operands...)
+	if err != nil {
+		panic(err)
+	}
+	return ins
+}
+
+// MaxOperand returns the largest operand that fits in width bytes.
+func MaxOperand(width int) uint64 {
+	return 1<<(8*uint(width)) - 1
 }
 
 func ReadUint16(bytes []byte) uint16 {
 	return binary.BigEndian.Uint16(bytes)
 }
 
+func ReadUint32(bytes []byte) uint32 {
+	return binary.BigEndian.Uint32(bytes)
+}
+
 func ReadUint8(bytes []byte) uint8 {
 	return uint8(bytes[0])
 }
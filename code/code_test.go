@@ -11,9 +11,16 @@ func TestMake(t *testing.T) {
 		{OpConstant, []int{65534}, []byte{byte(OpConstant), 255, 254}},
 		{OpAdd, []int{}, []byte{byte(OpAdd)}},
 		{OpSetLocal, []int{1}, []byte{byte(OpSetLocal), 1}},
+		{OpConstantWide, []int{65536}, []byte{byte(OpConstantWide), 0, 1, 0, 0}},
+		{OpGetLocalWide, []int{256}, []byte{byte(OpGetLocalWide), 1, 0}},
+		{OpJump, []int{70000}, []byte{byte(OpJump), 0, 1, 17, 112}},
+		{OpImport, []int{65536}, []byte{byte(OpImport), 0, 1, 0, 0}},
 	}
 	for i, tt := range tests {
-		instruction := Make(tt.op, tt.operands...)
+		instruction, err := Make(tt.op, tt.operands...)
+		if err != nil {
+			t.Fatalf("test %d: unexpected error: %s", i, err)
+		}
 		if len(instruction) != len(tt.expected) {
 			t.Fatalf("test %d: instruction has wrong length. expected=%d, got=%d", i, len(tt.expected), len(instruction))
 		}
@@ -25,17 +32,43 @@ func TestMake(t *testing.T) {
 	}
 }
 
+func TestMakeErrors(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		expected string
+	}{
+		{OpConstant, []int{65536}, "operand 65536 of OpConstant does not fit in 2 bytes"},
+		{OpGetLocal, []int{256}, "operand 256 of OpGetLocal does not fit in 1 bytes"},
+		{OpCall, []int{-1}, "operand -1 of OpCall does not fit in 1 bytes"},
+		{OpConstant, []int{}, "OpConstant expects 1 operands. got=0"},
+		{OpAdd, []int{1}, "OpAdd expects 0 operands. got=1"},
+	}
+	for i, tt := range tests {
+		_, err := Make(tt.op, tt.operands...)
+		if err == nil {
+			t.Errorf("test %d: expected an error", i)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("test %d: wrong error. want=%q, got=%q", i, tt.expected, err)
+		}
+	}
+}
+
 func TestInstructionsString(t *testing.T) {
 	instructions := []Instructions{
-		Make(OpAdd),
-		Make(OpConstant, 2),
-		Make(OpConstant, 65535),
-		Make(OpPop),
+		MustMake(OpAdd),
+		MustMake(OpConstant, 2),
+		MustMake(OpConstant, 65535),
+		MustMake(OpConstantWide, 65536),
+		MustMake(OpPop),
 	}
 	expected := `0000 OpAdd
 0001 OpConstant 2
 0004 OpConstant 65535
-0007 OpPop
+0007 OpConstantWide 65536
+0012 OpPop
 `
 	concatted := Instructions{}
 	for _, ins := range instructions {
@@ -0,0 +1,53 @@
+package code
+
+import (
+	"sort"
+
+	"interpego/token"
+)
+
+// SourceMapEntry records that the instructions starting at Offset were
+// compiled from the source at Position.
+type SourceMapEntry struct {
+	Offset   int
+	Position token.Position
+}
+
+// SourceMap maps instruction offsets back to source positions. Entries are
+// sorted by offset and only added when the position changes, so an offset
+// belongs to the last entry at or before it.
+type SourceMap []SourceMapEntry
+
+// Add records that the instruction at offset was compiled from pos. Runs of
+// instructions from the same position share a single entry.
+func (sm SourceMap) Add(offset int, pos token.Position) SourceMap {
+	if !pos.IsValid() {
+		return sm
+	}
+	if n := len(sm); n > 0 {
+		if sm[n-1].Position == pos {
+			return sm
+		}
+		if sm[n-1].Offset == offset {
+			sm[n-1].Position = pos
+			return sm
+		}
+	}
+	return append(sm, SourceMapEntry{Offset: offset, Position: pos})
+}
+
+// Truncate drops every entry at or after offset. It is used when trailing
+// instructions are removed.
+func (sm SourceMap) Truncate(offset int) SourceMap {
+	i := sort.Search(len(sm), func(i int) bool { return sm[i].Offset >= offset })
+	return sm[:i]
+}
+
+// Lookup returns the source position of the instruction at offset.
+func (sm SourceMap) Lookup(offset int) (token.Position, bool) {
+	i := sort.Search(len(sm), func(i int) bool { return sm[i].Offset > offset })
+	if i == 0 {
+		return token.Position{}, false
+	}
+	return sm[i-1].Position, true
+}
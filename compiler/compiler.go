@@ -5,13 +5,27 @@ import (
 
 	"interpego/ast"
 	"interpego/code"
+	"interpego/module"
 	"interpego/object"
+	"interpego/token"
 )
 
 type CompilationScope struct {
 	instructions    code.Instructions
+	sourceMap       code.SourceMap
 	lastInstruction EmittedInstruction
 	prevInstruction EmittedInstruction
+	// try expressions the code being compiled is inside, innermost last,
+	// which a return has to leave on its way out
+	tries []tryBlock
+}
+
+// tryBlock is a try or catch block being compiled.
+type tryBlock struct {
+	// whether the block runs with a handler in place
+	handler bool
+	// the finally block of the try expression, if it has one
+	finally *ast.BlockStatement
 }
 
 type EmittedInstruction struct {
@@ -19,20 +33,57 @@ type EmittedInstruction struct {
 	Position int
 }
 
+// The largest symbol indexes the VM can address, limited by the operands of
+// OpSetGlobal and OpSetLocalWide.
+const (
+	MAX_GLOBAL_INDEX = 1<<16 - 1
+	MAX_LOCAL_INDEX  = 1<<16 - 1
+)
+
+var (
+	errTooManyGlobals = fmt.Errorf("too many globals: a program can define at most %d", MAX_GLOBAL_INDEX+1)
+	errTooManyLocals  = fmt.Errorf("too many locals: a function can define at most %d", MAX_LOCAL_INDEX+1)
+)
+
 type Compiler struct {
 	scopes      []CompilationScope
 	scopeIdx    int
 	constants   []object.Object
 	symbolTable *SymbolTable
+	// position of the node currently being compiled, recorded against every
+	// emitted instruction in the scope's source map
+	position token.Position
+	optimize bool
+	// file being compiled, which imports are resolved against
+	file   string
+	loader *module.Loader
+	// index in the constant pool of the init function of each module compiled
+	// so far, by file
+	modules map[string]int
+	// symbol of each name bound so far, by the node binding it
+	bindings map[binding]Symbol
+}
+
+// binding is a node that binds a name in a symbol table.
+type binding struct {
+	symbols *SymbolTable
+	node    ast.Node
 }
 
 func New() *Compiler {
-	mainScope := CompilationScope{
-		instructions:    code.Instructions{},
-		lastInstruction: EmittedInstruction{},
-		prevInstruction: EmittedInstruction{},
+	symbolTable := NewSymbolTable()
+	for i, def := range object.Builtins {
+		symbolTable.DefineBuiltin(i, def.Name)
 	}
-	return &Compiler{scopes: []CompilationScope{mainScope}, scopeIdx: 0, constants: []object.Object{}, symbolTable: NewSymbolTable()}
+	return NewWithSymbols(symbolTable)
+}
+
+// NewWithState returns a compiler for a program that runs after the bytecode
+// that defined symbols and constants, such as the prelude, and can use them.
+func NewWithState(symbols *SymbolTable, constants []object.Object) *Compiler {
+	c := NewWithSymbols(symbols)
+	c.constants = constants
+	return c
 }
 
 func NewWithSymbols(symbols *SymbolTable) *Compiler {
@@ -41,15 +92,67 @@ func NewWithSymbols(symbols *SymbolTable) *Compiler {
 		lastInstruction: EmittedInstruction{},
 		prevInstruction: EmittedInstruction{},
 	}
-	return &Compiler{scopes: []CompilationScope{mainScope}, scopeIdx: 0, constants: []object.Object{}, symbolTable: symbols}
+	return &Compiler{
+		scopes:      []CompilationScope{mainScope},
+		scopeIdx:    0,
+		constants:   []object.Object{},
+		symbolTable: symbols,
+		loader:      module.NewLoader(nil),
+		modules:     map[string]int{},
+		bindings:    map[binding]Symbol{},
+	}
+}
+
+// SetOptimize turns compile-time simplification of programs on or off. It is
+// off by default, so the emitted bytecode mirrors the source.
+func (c *Compiler) SetOptimize(optimize bool) {
+	c.optimize = optimize
+}
+
+// SetFile sets the file the program was read from, which paths in its imports
+// are relative to. Without one they are relative to the working directory.
+func (c *Compiler) SetFile(file string) {
+	c.file = file
+}
+
+// SetLoader sets the loader that finds imported modules, which determines the
+// directories searched for them.
+func (c *Compiler) SetLoader(loader *module.Loader) {
+	c.loader = loader
+}
+
+// Modules returns the index in the constant pool of the init function of each
+// module compiled so far, by file.
+func (c *Compiler) Modules() map[string]int {
+	return c.modules
+}
+
+// SetModules adds modules, as returned by Modules, to the ones the compiler
+// has compiled, so a compiler continuing a program, like the next line in the
+// REPL, doesn't compile them again.
+func (c *Compiler) SetModules(modules map[string]int) {
+	for file, index := range modules {
+		c.modules[file] = index
+	}
 }
 
 type Bytecode struct {
 	Instructions code.Instructions
 	Constants    []object.Object
+	SourceMap    code.SourceMap
 }
 
 func (c *Compiler) Compile(node ast.Node) error {
+	outer := c.position
+	if pos := node.Pos(); pos.IsValid() {
+		c.position = pos
+	}
+	err := c.compile(node)
+	c.position = outer
+	return err
+}
+
+func (c *Compiler) compile(node ast.Node) error {
 	switch node := node.(type) {
 	case *ast.ExpressionStatement:
 		err := c.Compile(node.Expression)
@@ -58,26 +161,32 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		c.emit(code.OpPop)
 	case *ast.LetStatement:
+		// a function bound to a global can call itself, since the global is
+		// always set by the time the function runs
+		_, isFunction := node.Value.(*ast.FunctionLiteral)
+		recursive := isFunction && c.symbolTable.outer == nil
+
+		var sym Symbol
+		if recursive {
+			sym = c.define(node, node.Name.Value)
+		}
 		err := c.Compile(node.Value)
 		if err != nil {
 			return err
 		}
-		sym := c.symbolTable.Define(node.Name.Value)
-		if sym.Scope == GLOBAL_SCOPE {
-			c.emit(code.OpSetGlobal, sym.Index)
-		} else {
-			c.emit(code.OpSetLocal, sym.Index)
+		if !recursive {
+			sym = c.define(node, node.Name.Value)
+		}
+		err = c.emitSetSymbol(sym)
+		if err != nil {
+			return err
 		}
 	case *ast.Identifier:
 		sym, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
 			return fmt.Errorf("unable to resolve identifier: ident=%s", node.Value)
 		}
-		if sym.Scope == GLOBAL_SCOPE {
-			c.emit(code.OpGetGlobal, sym.Index)
-		} else {
-			c.emit(code.OpGetLocal, sym.Index)
-		}
+		c.emitGetSymbol(sym)
 	case *ast.InfixExpression:
 		if node.Operator == "<" {
 			err := c.Compile(node.Right)
@@ -142,24 +251,28 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 		return nil
 	case *ast.IfExpression:
+		if condition, ok := node.Condition.(*ast.BooleanLiteral); ok && c.optimize {
+			return c.compileConstantIf(condition.Value, node)
+		}
+
 		err := c.Compile(node.Condition)
 		if err != nil {
 			return err
 		}
 
 		jumpNotTruthyIns := c.emit(code.OpJumpNotTruthy, 9999)
-		c.Compile(node.Consequence)
-		if c.lastInstructionIs(code.OpPop) {
-			c.removeLastPop()
+		err = c.compileBlockValue(node.Consequence)
+		if err != nil {
+			return err
 		}
 
 		jumpAlwaysIns := c.emit(code.OpJump, 9999)
 		c.changeOperand(jumpNotTruthyIns, len(c.currentInstructions()))
 
 		if node.Alternative != nil {
-			c.Compile(node.Alternative)
-			if c.lastInstructionIs(code.OpPop) {
-				c.removeLastPop()
+			err = c.compileBlockValue(node.Alternative)
+			if err != nil {
+				return err
 			}
 		} else {
 			c.emit(code.OpNull)
@@ -176,16 +289,92 @@ func (c *Compiler) Compile(node ast.Node) error {
 				return err
 			}
 		}
-		c.emit(code.OpCall, len(node.Arguments))
+		numArgs := len(node.Arguments)
+		if uint64(numArgs) > code.MaxOperand(2) {
+			return fmt.Errorf("too many arguments: a call can pass at most %d", code.MaxOperand(2))
+		}
+		switch {
+		case uint64(numArgs) > code.MaxOperand(1):
+			c.emit(code.OpCallWide, numArgs)
+		case node.Tail:
+			c.emit(code.OpTailCall, numArgs)
+		default:
+			c.emit(code.OpCall, numArgs)
+		}
+	case *ast.ArrayLiteral:
+		if uint64(len(node.Elements)) > code.MaxOperand(2) {
+			return fmt.Errorf("too many elements: an array literal can have at most %d", code.MaxOperand(2))
+		}
+		for _, elem := range node.Elements {
+			err := c.Compile(elem)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpArray, len(node.Elements))
+	case *ast.HashLiteral:
+		if uint64(len(node.Keys)*2) > code.MaxOperand(2) {
+			return fmt.Errorf("too many pairs: a hash literal can have at most %d", code.MaxOperand(2)/2)
+		}
+		// keys are compiled in source order, so they're evaluated in the same
+		// order as in the evaluator and the hash remembers that order
+		for _, key := range node.Keys {
+			err := c.Compile(key)
+			if err != nil {
+				return err
+			}
+			err = c.Compile(node.Pairs[key])
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpHash, len(node.Keys)*2)
+	case *ast.IndexExpression:
+		err := c.Compile(node.Left)
+		if err != nil {
+			return err
+		}
+		err = c.Compile(node.Index)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpIndex)
+	case *ast.ImportExpression:
+		index, err := c.compileModule(node.Path)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpImport, index)
 	case *ast.IntegerLiteral:
-		c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: node.Value}))
+		c.emitConstant(integerValue(node))
 	case *ast.StringLiteral:
-		c.emit(code.OpConstant, c.addConstant(&object.String{Value: node.Value}))
+		c.emitConstant(&object.String{Value: node.Value})
+	case *ast.InterpolatedString:
+		parts := 0
+		for i, str := range node.Strings {
+			if str != "" {
+				c.emitConstant(&object.String{Value: str})
+				parts++
+			}
+			if i < len(node.Values) {
+				if err := c.Compile(node.Values[i]); err != nil {
+					return err
+				}
+				parts++
+			}
+		}
+		if uint64(parts) > code.MaxOperand(2) {
+			return fmt.Errorf("too many parts: an interpolated string can have at most %d", code.MaxOperand(2))
+		}
+		c.emit(code.OpInterpolate, parts)
 	case *ast.FunctionLiteral:
 		c.enterScope()
 
 		for _, param := range node.Parameters {
-			c.symbolTable.Define(param.Value)
+			sym := c.symbolTable.Define(param.Value)
+			if uint64(sym.Index) > MAX_LOCAL_INDEX {
+				return errTooManyLocals
+			}
 		}
 
 		err := c.Compile(node.FunctionBody)
@@ -193,28 +382,60 @@ func (c *Compiler) Compile(node ast.Node) error {
 			return err
 		}
 		if c.lastInstructionIs(code.OpPop) {
-			newIns := code.Make(code.OpReturnValue)
+			newIns := code.MustMake(code.OpReturnValue)
 			c.replaceInstruction(c.scopes[c.scopeIdx].lastInstruction.Position, newIns)
 			c.scopes[c.scopeIdx].lastInstruction.Opcode = code.OpReturnValue
 		}
 		if !c.lastInstructionIs(code.OpReturnValue) {
 			c.emit(code.OpReturn)
 		}
+		if c.optimize {
+			c.peephole()
+		}
 		numLocals := c.symbolTable.numDefinitions
-		newIns := c.leaveScope()
-		c.emit(
-			code.OpConstant,
-			c.addConstant(&object.CompiledFunction{
-				Instructions: newIns,
-				NumLocals:    numLocals,
-			}),
-		)
+		newIns, sourceMap := c.leaveScope()
+		c.emitConstant(&object.CompiledFunction{
+			Instructions:  newIns,
+			SourceMap:     sourceMap,
+			NumLocals:     numLocals,
+			NumParameters: len(node.Parameters),
+			Name:          node.Name,
+		})
 	case *ast.ReturnStatement:
 		err := c.Compile(node.ReturnValue)
 		if err != nil {
 			return err
 		}
+		err = c.leaveTries()
+		if err != nil {
+			return err
+		}
 		c.emit(code.OpReturnValue)
+	case *ast.ThrowStatement:
+		err := c.Compile(node.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+	case *ast.TryExpression:
+		return c.compileTry(node)
+	case *ast.MatchExpression:
+		return c.compileMatch(node)
+	case *ast.DeferStatement:
+		err := c.Compile(node.Call.Function)
+		if err != nil {
+			return err
+		}
+		for _, arg := range node.Call.Arguments {
+			err := c.Compile(arg)
+			if err != nil {
+				return err
+			}
+		}
+		if uint64(len(node.Call.Arguments)) > code.MaxOperand(1) {
+			return fmt.Errorf("too many arguments: a deferred call can pass at most %d", code.MaxOperand(1))
+		}
+		c.emit(code.OpDefer, len(node.Call.Arguments))
 	case *ast.BooleanLiteral:
 		if node.Value {
 			c.emit(code.OpTrue)
@@ -222,6 +443,9 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(code.OpFalse)
 		}
 	case *ast.Program:
+		if c.optimize {
+			optimize(node)
+		}
 		stmts := node.Statements
 		for _, stmt := range stmts {
 			err := c.Compile(stmt)
@@ -229,6 +453,9 @@ func (c *Compiler) Compile(node ast.Node) error {
 				return err
 			}
 		}
+		if c.optimize {
+			c.peephole()
+		}
 	case *ast.BlockStatement:
 		stmts := node.Statements
 		for _, stmt := range stmts {
@@ -244,6 +471,406 @@ func (c *Compiler) Compile(node ast.Node) error {
 	return nil
 }
 
+// compileTry compiles a try expression, which is laid out as
+//
+//	    OpTry catch
+//	    <try block>
+//	    OpEndTry
+//	    OpJump done
+//	catch:
+//	    OpTry rethrow    (with a finally block)
+//	    OpCatch
+//	    <bind the error>
+//	    <catch block>
+//	    OpEndTry         (with a finally block)
+//	done:
+//	    <finally block>
+//	    OpJump end
+//	rethrow:
+//	    <finally block>
+//	    OpThrow
+//	end:
+//
+// Without a catch block, the try block's handler is the rethrow one. The
+// finally block is compiled twice, once for when no error escapes and once for
+// when one does, so neither path has to remember which it is on.
+func (c *Compiler) compileTry(node *ast.TryExpression) error {
+	tryPos := c.emit(code.OpTry, 9999)
+	err := c.compileTryBlock(node.Block, tryBlock{handler: true, finally: node.Finally})
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpEndTry)
+
+	var rethrowPos int
+	if node.Catch != nil {
+		donePos := c.emit(code.OpJump, 9999)
+		c.changeOperand(tryPos, len(c.currentInstructions()))
+		if node.Finally != nil {
+			rethrowPos = c.emit(code.OpTry, 9999)
+		}
+		c.emit(code.OpCatch)
+		err = c.emitSetSymbol(c.define(node.CatchParam, node.CatchParam.Value))
+		if err != nil {
+			return err
+		}
+		err = c.compileTryBlock(node.Catch, tryBlock{handler: node.Finally != nil, finally: node.Finally})
+		if err != nil {
+			return err
+		}
+		if node.Finally != nil {
+			c.emit(code.OpEndTry)
+		}
+		c.changeOperand(donePos, len(c.currentInstructions()))
+	} else {
+		rethrowPos = tryPos
+	}
+	if node.Finally == nil {
+		return nil
+	}
+
+	// the finally block's own value is discarded
+	err = c.Compile(node.Finally)
+	if err != nil {
+		return err
+	}
+	endPos := c.emit(code.OpJump, 9999)
+	c.changeOperand(rethrowPos, len(c.currentInstructions()))
+	err = c.Compile(node.Finally)
+	if err != nil {
+		return err
+	}
+	c.emit(code.OpThrow)
+	c.changeOperand(endPos, len(c.currentInstructions()))
+	return nil
+}
+
+// compileTryBlock compiles the try or catch block of a try expression, leaving
+// its value on the stack.
+func (c *Compiler) compileTryBlock(block *ast.BlockStatement, try tryBlock) error {
+	c.scopes[c.scopeIdx].tries = append(c.scopes[c.scopeIdx].tries, try)
+	err := c.compileBlockValue(block)
+	c.scopes[c.scopeIdx].tries = c.scopes[c.scopeIdx].tries[:len(c.scopes[c.scopeIdx].tries)-1]
+	return err
+}
+
+// leaveTries emits what a return has to do before leaving the try expressions
+// it is inside: drop their handlers and run their finally blocks, innermost
+// first.
+func (c *Compiler) leaveTries() error {
+	tries := c.scopes[c.scopeIdx].tries
+	defer func() { c.scopes[c.scopeIdx].tries = tries }()
+	for i := len(tries) - 1; i >= 0; i-- {
+		if tries[i].handler {
+			c.emit(code.OpEndTry)
+		}
+		if tries[i].finally != nil {
+			// a return in the finally block only leaves the tries outside
+			// it; capped, so tries it contains don't overwrite the rest
+			c.scopes[c.scopeIdx].tries = tries[:i:i]
+			err := c.Compile(tries[i].finally)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// MATCH_SUBJECT names the slots the values a match expression tests are kept
+// in. No program can refer to it.
+const MATCH_SUBJECT = "match.subject"
+
+// compileMatch compiles a match expression, which is laid out as
+//
+//	    <value>
+//	    <set subject>
+//	arm:
+//	    <tests of the arm's pattern, each followed by OpJumpNotTruthy next>
+//	    <guard>
+//	    OpJumpNotTruthy next    (with a guard)
+//	    <result>
+//	    OpJump end
+//	next:
+//	    <the other arms>
+//	    <get subject>
+//	    OpNoMatch
+//	end:
+//
+// An arm that can't fail, like `_ =>`, ends the match: the arms after it are
+// never tried, so they aren't compiled, and neither is OpNoMatch.
+func (c *Compiler) compileMatch(node *ast.MatchExpression) error {
+	err := c.Compile(node.Value)
+	if err != nil {
+		return err
+	}
+	subject := c.define(node, MATCH_SUBJECT)
+	err = c.emitSetSymbol(subject)
+	if err != nil {
+		return err
+	}
+	loadSubject := func() error {
+		c.emitGetSymbol(subject)
+		return nil
+	}
+
+	var endJumps []int
+	exhaustive := false
+	for _, arm := range node.Arms {
+		var nextJumps []int
+		err := c.compilePattern(arm.Pattern, loadSubject, &nextJumps)
+		if err != nil {
+			return err
+		}
+		if arm.Guard != nil {
+			err := c.Compile(arm.Guard)
+			if err != nil {
+				return err
+			}
+			nextJumps = append(nextJumps, c.emit(code.OpJumpNotTruthy, 9999))
+		}
+		err = c.Compile(arm.Result)
+		if err != nil {
+			return err
+		}
+		if len(nextJumps) == 0 {
+			exhaustive = true
+			break
+		}
+		endJumps = append(endJumps, c.emit(code.OpJump, 9999))
+		for _, pos := range nextJumps {
+			c.changeOperand(pos, len(c.currentInstructions()))
+		}
+	}
+	if !exhaustive {
+		c.emitGetSymbol(subject)
+		c.emit(code.OpNoMatch)
+	}
+	for _, pos := range endJumps {
+		c.changeOperand(pos, len(c.currentInstructions()))
+	}
+	return nil
+}
+
+// compilePattern compiles the tests of pattern against the value load leaves
+// on the stack, binding the names in it as it goes. Each test is followed by
+// a jump, added to next, to where the arm fails.
+func (c *Compiler) compilePattern(pattern ast.Pattern, load func() error, next *[]int) error {
+	switch pattern := pattern.(type) {
+	case *ast.WildcardPattern:
+		return nil
+	case *ast.BindingPattern:
+		err := load()
+		if err != nil {
+			return err
+		}
+		return c.emitSetSymbol(c.define(pattern, pattern.Name.Value))
+	case *ast.LiteralPattern:
+		err := load()
+		if err != nil {
+			return err
+		}
+		err = c.Compile(pattern.Value)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpMatchValue)
+	case *ast.ArrayPattern:
+		n := len(pattern.Elements)
+		if uint64(n) > code.MaxOperand(2) {
+			return fmt.Errorf("too many elements: an array pattern can have at most %d", code.MaxOperand(2))
+		}
+		err := load()
+		if err != nil {
+			return err
+		}
+		if pattern.HasRest {
+			c.emit(code.OpMatchArrayMin, n)
+		} else {
+			c.emit(code.OpMatchArray, n)
+		}
+		*next = append(*next, c.emit(code.OpJumpNotTruthy, 9999))
+		for i, elem := range pattern.Elements {
+			index := &object.Integer{Value: int64(i)}
+			err := c.compileSubpattern(elem, func() error {
+				err := load()
+				if err != nil {
+					return err
+				}
+				c.emitConstant(index)
+				c.emit(code.OpIndex)
+				return nil
+			}, next)
+			if err != nil {
+				return err
+			}
+		}
+		if pattern.Rest == nil {
+			return nil
+		}
+		return c.compileSubpattern(pattern.Rest, func() error {
+			err := load()
+			c.emit(code.OpArrayRest, n)
+			return err
+		}, next)
+	case *ast.HashPattern:
+		if uint64(len(pattern.Keys)) > code.MaxOperand(2) {
+			return fmt.Errorf("too many keys: a hash pattern can have at most %d", code.MaxOperand(2))
+		}
+		err := load()
+		if err != nil {
+			return err
+		}
+		for _, key := range pattern.Keys {
+			err := c.Compile(key)
+			if err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpMatchHash, len(pattern.Keys))
+		*next = append(*next, c.emit(code.OpJumpNotTruthy, 9999))
+		for i, key := range pattern.Keys {
+			key := key
+			err := c.compileSubpattern(pattern.Values[i], func() error {
+				err := load()
+				if err != nil {
+					return err
+				}
+				err = c.Compile(key)
+				c.emit(code.OpIndex)
+				return err
+			}, next)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported pattern type. got=%T (%+v)", pattern, pattern)
+	}
+	*next = append(*next, c.emit(code.OpJumpNotTruthy, 9999))
+	return nil
+}
+
+// compileSubpattern compiles pattern against a part of a subject, which load
+// leaves on the stack. Array and hash patterns read their subject more than
+// once, so the part is kept in a slot of its own for them.
+func (c *Compiler) compileSubpattern(pattern ast.Pattern, load func() error, next *[]int) error {
+	switch pattern.(type) {
+	case *ast.ArrayPattern, *ast.HashPattern:
+		err := load()
+		if err != nil {
+			return err
+		}
+		part := c.define(pattern, MATCH_SUBJECT)
+		err = c.emitSetSymbol(part)
+		if err != nil {
+			return err
+		}
+		load = func() error {
+			c.emitGetSymbol(part)
+			return nil
+		}
+	}
+	return c.compilePattern(pattern, load, next)
+}
+
+// compileModule compiles the module imported as path into a function that runs
+// its top-level statements and returns a hash of its exports, and returns the
+// function's index in the constant pool. Each module is only compiled once, and
+// the VM only runs it the first time it is imported.
+func (c *Compiler) compileModule(path string) (int, error) {
+	file, err := c.loader.Resolve(c.file, path)
+	if err != nil {
+		return 0, fmt.Errorf("import %q: %s", path, err)
+	}
+	if index, ok := c.modules[file]; ok {
+		return index, nil
+	}
+	if err := c.loader.Enter(file); err != nil {
+		return 0, fmt.Errorf("import %q: %s", path, err)
+	}
+	defer c.loader.Leave()
+	program, err := module.Parse(file)
+	if err != nil {
+		return 0, fmt.Errorf("import %q: %s", path, err)
+	}
+
+	importer, symbolTable := c.file, c.symbolTable
+	c.file = file
+	c.enterScope()
+	c.symbolTable = NewModuleSymbolTable(symbolTable)
+	err = c.Compile(program)
+	if err == nil {
+		err = c.emitExports(module.Exports(program))
+	}
+	instructions, sourceMap := c.leaveScope()
+	c.file, c.symbolTable = importer, symbolTable
+	if err != nil {
+		return 0, err
+	}
+
+	index := c.addConstant(&object.CompiledFunction{
+		Instructions: instructions,
+		SourceMap:    sourceMap,
+		Name:         fmt.Sprintf("<module %s>", path),
+	})
+	c.modules[file] = index
+	return index, nil
+}
+
+// emitExports makes the function compiled in the current scope return a hash
+// of the module globals called names.
+func (c *Compiler) emitExports(names []string) error {
+	if uint64(len(names)*2) > code.MaxOperand(2) {
+		return fmt.Errorf("too many exports: a module can export at most %d", code.MaxOperand(2)/2)
+	}
+	for _, name := range names {
+		c.emitConstant(&object.String{Value: name})
+		sym, _ := c.symbolTable.Resolve(name)
+		c.emitGetSymbol(sym)
+	}
+	c.emit(code.OpHash, len(names)*2)
+	c.emit(code.OpReturnValue)
+	return nil
+}
+
+// compileConstantIf compiles only the branch of an if expression that its
+// constant condition selects.
+func (c *Compiler) compileConstantIf(condition bool, node *ast.IfExpression) error {
+	branch := node.Consequence
+	if !condition {
+		branch = node.Alternative
+	}
+	if branch == nil {
+		c.emit(code.OpNull)
+		return nil
+	}
+
+	return c.compileBlockValue(branch)
+}
+
+// compileBlockValue compiles block, leaving its value on the stack: that of
+// its last statement if that is an expression, or null otherwise.
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	start := len(c.currentInstructions())
+	err := c.Compile(block)
+	if err != nil {
+		return err
+	}
+	switch {
+	case len(c.currentInstructions()) == start:
+		c.emit(code.OpNull)
+	case c.lastInstructionIs(code.OpPop):
+		c.removeLastPop()
+	case c.lastInstructionIs(code.OpReturnValue), c.lastInstructionIs(code.OpThrow):
+		// the block never finishes, so it needs no value
+	default:
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
 func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
 	return c.scopes[c.scopeIdx].lastInstruction.Opcode == op
 }
@@ -253,15 +880,18 @@ func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
 // in block
 func (c *Compiler) maybeRemoveLastPop() bool {
 	if c.lastInstructionIs(code.OpPop) {
-		c.scopes[c.scopeIdx].instructions = c.currentInstructions()[:c.scopes[c.scopeIdx].lastInstruction.Position]
-		c.scopes[c.scopeIdx].lastInstruction = c.scopes[c.scopeIdx].prevInstruction
+		c.removeLastPop()
 		return true
 	}
 	return false
 }
 
 func (c *Compiler) Bytecode() *Bytecode {
-	return &Bytecode{Instructions: c.currentInstructions(), Constants: c.constants}
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		Constants:    c.constants,
+		SourceMap:    c.scopes[c.scopeIdx].sourceMap,
+	}
 }
 
 func (c *Compiler) addConstant(obj object.Object) int {
@@ -269,12 +899,74 @@ func (c *Compiler) addConstant(obj object.Object) int {
 	return len(c.constants) - 1
 }
 
+// emitConstant adds obj to the constant pool and emits the instruction that
+// loads it, using the wide variant once the pool outgrows OpConstant's operand.
+func (c *Compiler) emitConstant(obj object.Object) {
+	index := c.addConstant(obj)
+	if uint64(index) > code.MaxOperand(2) {
+		c.emit(code.OpConstantWide, index)
+	} else {
+		c.emit(code.OpConstant, index)
+	}
+}
+
+// define defines name, bound by node. A node compiled again, like the
+// statements of a finally block, which is compiled once for each way out of
+// its try expression, binds the same symbol as the first time, so the code
+// after it reads the value whichever copy ran.
+func (c *Compiler) define(node ast.Node, name string) Symbol {
+	key := binding{c.symbolTable, node}
+	if sym, ok := c.bindings[key]; ok {
+		c.symbolTable.store[name] = sym
+		return sym
+	}
+	sym := c.symbolTable.Define(name)
+	c.bindings[key] = sym
+	return sym
+}
+
+func (c *Compiler) emitSetSymbol(sym Symbol) error {
+	if sym.Scope == GLOBAL_SCOPE {
+		if uint64(sym.Index) > MAX_GLOBAL_INDEX {
+			return errTooManyGlobals
+		}
+		c.emit(code.OpSetGlobal, sym.Index)
+		return nil
+	}
+
+	switch {
+	case uint64(sym.Index) > MAX_LOCAL_INDEX:
+		return errTooManyLocals
+	case uint64(sym.Index) > code.MaxOperand(1):
+		c.emit(code.OpSetLocalWide, sym.Index)
+	default:
+		c.emit(code.OpSetLocal, sym.Index)
+	}
+	return nil
+}
+
+// emitGetSymbol emits the instruction that loads sym. Symbols are only resolved
+// after emitSetSymbol accepted their definition, so their index always fits.
+func (c *Compiler) emitGetSymbol(sym Symbol) {
+	switch {
+	case sym.Scope == GLOBAL_SCOPE:
+		c.emit(code.OpGetGlobal, sym.Index)
+	case sym.Scope == BUILTIN_SCOPE:
+		c.emit(code.OpGetBuiltin, sym.Index)
+	case uint64(sym.Index) > code.MaxOperand(1):
+		c.emit(code.OpGetLocalWide, sym.Index)
+	default:
+		c.emit(code.OpGetLocal, sym.Index)
+	}
+}
+
 // emit creates an instruction using the provided opcode and operands.
 // It then adds this instruction to the compiler's instruction slice.
 // Returns the starting position of the newly added instruction.
 func (c *Compiler) emit(op code.Opcode, operands ...int) int {
-	newInstruction := code.Make(op, operands...)
+	newInstruction := code.MustMake(op, operands...)
 	pos := c.addInstruction(newInstruction)
+	c.scopes[c.scopeIdx].sourceMap = c.scopes[c.scopeIdx].sourceMap.Add(pos, c.position)
 
 	c.scopes[c.scopeIdx].prevInstruction = c.scopes[c.scopeIdx].lastInstruction
 	c.scopes[c.scopeIdx].lastInstruction = EmittedInstruction{op, pos}
@@ -305,7 +997,7 @@ func (c *Compiler) replaceInstruction(opPos int, newIns []byte) {
 // operand is the new operand value to be used.
 func (c *Compiler) changeOperand(opPos int, newOperand int) {
 	op := code.Opcode(c.currentInstructions()[opPos])
-	newIns := code.Make(op, newOperand)
+	newIns := code.MustMake(op, newOperand)
 	c.replaceInstruction(opPos, newIns)
 }
 
@@ -320,18 +1012,21 @@ func (c *Compiler) enterScope() {
 	c.scopeIdx++
 }
 
-func (c *Compiler) leaveScope() code.Instructions {
+func (c *Compiler) leaveScope() (code.Instructions, code.SourceMap) {
 	if c.scopeIdx == 0 {
 		panic("attempting to leave global scope")
 	}
 	ins := c.currentInstructions()
+	sourceMap := c.scopes[c.scopeIdx].sourceMap
 	c.scopes = c.scopes[:c.scopeIdx]
 	c.scopeIdx--
 	c.symbolTable = c.symbolTable.outer
-	return ins
+	return ins, sourceMap
 }
 
 func (c *Compiler) removeLastPop() {
-	c.scopes[c.scopeIdx].instructions = c.currentInstructions()[:c.scopes[c.scopeIdx].lastInstruction.Position]
-	c.scopes[c.scopeIdx].lastInstruction = c.scopes[c.scopeIdx].prevInstruction
+	scope := &c.scopes[c.scopeIdx]
+	scope.instructions = scope.instructions[:scope.lastInstruction.Position]
+	scope.sourceMap = scope.sourceMap.Truncate(scope.lastInstruction.Position)
+	scope.lastInstruction = scope.prevInstruction
 }
@@ -2,6 +2,7 @@ package compiler
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"interpego/ast"
@@ -29,74 +30,74 @@ func TestBooleanExpressions(t *testing.T) {
 			input:             "true",
 			expectedConstants: []interface{}{},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpTrue),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpTrue),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input:             "false",
 			expectedConstants: []interface{}{},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpFalse),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpFalse),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input:             "true < false",
 			expectedConstants: []interface{}{},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpFalse),
-				code.Make(code.OpTrue),
-				code.Make(code.OpGreaterThan),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpFalse),
+				code.MustMake(code.OpTrue),
+				code.MustMake(code.OpGreaterThan),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input: "1 > 2", expectedConstants: []interface{}{1, 2}, expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpGreaterThan),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpGreaterThan),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input: "1 < 2", expectedConstants: []interface{}{2, 1}, expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpGreaterThan),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpGreaterThan),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input: "1 == 2", expectedConstants: []interface{}{1, 2}, expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpEqual),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpEqual),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input: "1 != 2", expectedConstants: []interface{}{1, 2}, expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpNotEqual),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpNotEqual),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input: "true == false", expectedConstants: []interface{}{}, expectedInstructions: []code.Instructions{
-				code.Make(code.OpTrue),
-				code.Make(code.OpFalse),
-				code.Make(code.OpEqual),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpTrue),
+				code.MustMake(code.OpFalse),
+				code.MustMake(code.OpEqual),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input: "true != false", expectedConstants: []interface{}{}, expectedInstructions: []code.Instructions{
-				code.Make(code.OpTrue),
-				code.Make(code.OpFalse),
-				code.Make(code.OpNotEqual),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpTrue),
+				code.MustMake(code.OpFalse),
+				code.MustMake(code.OpNotEqual),
+				code.MustMake(code.OpPop),
 			},
 		},
 	}
@@ -109,42 +110,42 @@ func TestIntegerArithmetic(t *testing.T) {
 			input:             `1 + 2;`,
 			expectedConstants: []interface{}{1, 2},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpAdd),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpAdd),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input: "1; 2", expectedConstants: []interface{}{1, 2}, expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpPop),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpPop),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input: "2 / 1", expectedConstants: []interface{}{2, 1}, expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpDiv),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpDiv),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input: "1 * 2", expectedConstants: []interface{}{1, 2}, expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpMul),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpMul),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input: "1 - 2", expectedConstants: []interface{}{1, 2}, expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpSub),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpSub),
+				code.MustMake(code.OpPop),
 			},
 		},
 	}
@@ -204,6 +205,14 @@ func testConstants(expected []interface{}, actual []object.Object) error {
 			if err != nil {
 				return fmt.Errorf("constant %d - testIntegerObject failed: %s", i, err)
 			}
+		case string:
+			str, ok := actual[i].(*object.String)
+			if !ok {
+				return fmt.Errorf("constant %d - not a String. got=%T (%+v)", i, actual[i], actual[i])
+			}
+			if str.Value != constant {
+				return fmt.Errorf("constant %d - wrong value. want=%q, got=%q", i, constant, str.Value)
+			}
 		case []code.Instructions:
 			return fmt.Errorf(
 				"constant %d - testInstructions failed: providing []code.Instructions is no longer supported. "+
@@ -250,18 +259,18 @@ func TestPrefixExpressions(t *testing.T) {
 			input:             "!true",
 			expectedConstants: []interface{}{},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpTrue),
-				code.Make(code.OpBang),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpTrue),
+				code.MustMake(code.OpBang),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input:             "-5",
 			expectedConstants: []interface{}{5},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpMinus),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpMinus),
+				code.MustMake(code.OpPop),
 			},
 		},
 	}
@@ -274,28 +283,28 @@ func TestIfExpressions(t *testing.T) {
 			input:             "if (true) { 1; }; 3333;",
 			expectedConstants: []interface{}{1, 3333},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpTrue),              // 0000
-				code.Make(code.OpJumpNotTruthy, 10), // 0001
-				code.Make(code.OpConstant, 0),       // 0004
-				code.Make(code.OpJump, 11),          // 0007
-				code.Make(code.OpNull),              // 0010
-				code.Make(code.OpPop),               // 0011
-				code.Make(code.OpConstant, 1),       // 0014
-				code.Make(code.OpPop),               // 0017
+				code.MustMake(code.OpTrue),              // 0000
+				code.MustMake(code.OpJumpNotTruthy, 14), // 0001
+				code.MustMake(code.OpConstant, 0),       // 0006
+				code.MustMake(code.OpJump, 15),          // 0009
+				code.MustMake(code.OpNull),              // 0014
+				code.MustMake(code.OpPop),               // 0015
+				code.MustMake(code.OpConstant, 1),       // 0016
+				code.MustMake(code.OpPop),               // 0019
 			},
 		},
 		{
 			input:             "if (true) { 1; } else { 2; }; 3333;",
 			expectedConstants: []interface{}{1, 2, 3333},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpTrue),              // 0000
-				code.Make(code.OpJumpNotTruthy, 10), // 0001
-				code.Make(code.OpConstant, 0),       // 0004
-				code.Make(code.OpJump, 13),          // 0007
-				code.Make(code.OpConstant, 1),       // 0010
-				code.Make(code.OpPop),               // 0013
-				code.Make(code.OpConstant, 2),       // 0014
-				code.Make(code.OpPop),               // 0017
+				code.MustMake(code.OpTrue),              // 0000
+				code.MustMake(code.OpJumpNotTruthy, 14), // 0001
+				code.MustMake(code.OpConstant, 0),       // 0006
+				code.MustMake(code.OpJump, 17),          // 0009
+				code.MustMake(code.OpConstant, 1),       // 0014
+				code.MustMake(code.OpPop),               // 0017
+				code.MustMake(code.OpConstant, 2),       // 0018
+				code.MustMake(code.OpPop),               // 0021
 			},
 		},
 	}
@@ -308,32 +317,32 @@ func TestLetStatements(t *testing.T) {
 			input:             "let x = 1;",
 			expectedConstants: []interface{}{1},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpSetGlobal, 0), // this means take what is on the stack, and assign it to symbol 0
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpSetGlobal, 0), // this means take what is on the stack, and assign it to symbol 0
 			},
 		},
 		{
 			input:             "let x = 1; x;",
 			expectedConstants: []interface{}{1},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpSetGlobal, 0), // this means take what is on the stack, and assign it to symbol 0
-				code.Make(code.OpGetGlobal, 0),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpSetGlobal, 0), // this means take what is on the stack, and assign it to symbol 0
+				code.MustMake(code.OpGetGlobal, 0),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input:             "let x = 1; let y = x + x; y",
 			expectedConstants: []interface{}{1},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpSetGlobal, 0), // this means take what is on the stack, and assign it to symbol 0
-				code.Make(code.OpGetGlobal, 0),
-				code.Make(code.OpGetGlobal, 0),
-				code.Make(code.OpAdd),
-				code.Make(code.OpSetGlobal, 1),
-				code.Make(code.OpGetGlobal, 1),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpSetGlobal, 0), // this means take what is on the stack, and assign it to symbol 0
+				code.MustMake(code.OpGetGlobal, 0),
+				code.MustMake(code.OpGetGlobal, 0),
+				code.MustMake(code.OpAdd),
+				code.MustMake(code.OpSetGlobal, 1),
+				code.MustMake(code.OpGetGlobal, 1),
+				code.MustMake(code.OpPop),
 			},
 		},
 	}
@@ -349,25 +358,25 @@ func TestFunctions(t *testing.T) {
 				1,
 				expectedCompiledFunction{
 					instructions: []code.Instructions{
-						code.Make(code.OpConstant, 0),
-						code.Make(code.OpSetLocal, 0),
-						code.Make(code.OpGetLocal, 0),
-						code.Make(code.OpConstant, 1),
-						code.Make(code.OpAdd),
-						code.Make(code.OpSetLocal, 1),
-						code.Make(code.OpGetLocal, 1),
-						code.Make(code.OpGetLocal, 1),
-						code.Make(code.OpMul),
-						code.Make(code.OpReturnValue),
+						code.MustMake(code.OpConstant, 0),
+						code.MustMake(code.OpSetLocal, 0),
+						code.MustMake(code.OpGetLocal, 0),
+						code.MustMake(code.OpConstant, 1),
+						code.MustMake(code.OpAdd),
+						code.MustMake(code.OpSetLocal, 1),
+						code.MustMake(code.OpGetLocal, 1),
+						code.MustMake(code.OpGetLocal, 1),
+						code.MustMake(code.OpMul),
+						code.MustMake(code.OpReturnValue),
 					},
 					numLocals:     2,
 					numParameters: 0,
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpCall, 0),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpCall, 0),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
@@ -377,18 +386,18 @@ func TestFunctions(t *testing.T) {
 				10,
 				expectedCompiledFunction{
 					instructions: []code.Instructions{
-						code.Make(code.OpConstant, 0),
-						code.Make(code.OpConstant, 1),
-						code.Make(code.OpAdd),
-						code.Make(code.OpReturnValue),
+						code.MustMake(code.OpConstant, 0),
+						code.MustMake(code.OpConstant, 1),
+						code.MustMake(code.OpAdd),
+						code.MustMake(code.OpReturnValue),
 					},
 					numLocals:     0,
 					numParameters: 0,
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
@@ -397,33 +406,33 @@ func TestFunctions(t *testing.T) {
 				10,
 				expectedCompiledFunction{
 					instructions: []code.Instructions{
-						code.Make(code.OpConstant, 0),
-						code.Make(code.OpConstant, 1),
-						code.Make(code.OpAdd),
-						code.Make(code.OpReturnValue),
+						code.MustMake(code.OpConstant, 0),
+						code.MustMake(code.OpConstant, 1),
+						code.MustMake(code.OpAdd),
+						code.MustMake(code.OpReturnValue),
 					},
 					numLocals:     0,
 					numParameters: 0,
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input: `fn() { }`, expectedConstants: []interface{}{
 				expectedCompiledFunction{
 					instructions: []code.Instructions{
-						code.Make(code.OpReturn),
+						code.MustMake(code.OpReturn),
 					},
 					numLocals:     0,
 					numParameters: 0,
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
@@ -432,33 +441,33 @@ func TestFunctions(t *testing.T) {
 				2,
 				expectedCompiledFunction{
 					instructions: []code.Instructions{
-						code.Make(code.OpConstant, 0),
-						code.Make(code.OpPop),
-						code.Make(code.OpConstant, 1),
-						code.Make(code.OpReturnValue),
+						code.MustMake(code.OpConstant, 0),
+						code.MustMake(code.OpPop),
+						code.MustMake(code.OpConstant, 1),
+						code.MustMake(code.OpReturnValue),
 					},
 					numLocals:     0,
 					numParameters: 0,
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
 			input: `let a = fn() { }`, expectedConstants: []interface{}{
 				expectedCompiledFunction{
 					instructions: []code.Instructions{
-						code.Make(code.OpReturn),
+						code.MustMake(code.OpReturn),
 					},
 					numLocals:     0,
 					numParameters: 0,
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpSetGlobal, 0),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpSetGlobal, 0),
 			},
 		},
 	}
@@ -473,17 +482,17 @@ func TestFunctionCalls(t *testing.T) {
 				5,
 				expectedCompiledFunction{
 					instructions: []code.Instructions{
-						code.Make(code.OpConstant, 0),
-						code.Make(code.OpReturnValue),
+						code.MustMake(code.OpConstant, 0),
+						code.MustMake(code.OpReturnValue),
 					},
 					numLocals:     0,
 					numParameters: 0,
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpCall, 0),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpCall, 0),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
@@ -492,19 +501,19 @@ func TestFunctionCalls(t *testing.T) {
 				5,
 				expectedCompiledFunction{
 					instructions: []code.Instructions{
-						code.Make(code.OpConstant, 0),
-						code.Make(code.OpReturnValue),
+						code.MustMake(code.OpConstant, 0),
+						code.MustMake(code.OpReturnValue),
 					},
 					numLocals:     0,
 					numParameters: 0,
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpSetGlobal, 0),
-				code.Make(code.OpGetGlobal, 0),
-				code.Make(code.OpCall, 0),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpGetGlobal, 0),
+				code.MustMake(code.OpCall, 0),
+				code.MustMake(code.OpPop),
 			},
 		},
 	}
@@ -518,18 +527,18 @@ func TestLetStatementScopes(t *testing.T) {
 				1,
 				expectedCompiledFunction{
 					instructions: []code.Instructions{
-						code.Make(code.OpConstant, 0),
-						code.Make(code.OpSetLocal, 0),
-						code.Make(code.OpGetLocal, 0),
-						code.Make(code.OpReturnValue),
+						code.MustMake(code.OpConstant, 0),
+						code.MustMake(code.OpSetLocal, 0),
+						code.MustMake(code.OpGetLocal, 0),
+						code.MustMake(code.OpReturnValue),
 					},
 					numLocals:     1,
 					numParameters: 0,
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpSetGlobal, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpSetGlobal, 0),
 			},
 		},
 		{
@@ -541,18 +550,18 @@ fn() { num }
 				55,
 				expectedCompiledFunction{
 					instructions: []code.Instructions{
-						code.Make(code.OpGetGlobal, 0),
-						code.Make(code.OpReturnValue),
+						code.MustMake(code.OpGetGlobal, 0),
+						code.MustMake(code.OpReturnValue),
 					},
 					numLocals:     0,
 					numParameters: 0,
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpSetGlobal, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
@@ -565,18 +574,18 @@ num }
 				55,
 				expectedCompiledFunction{
 					instructions: []code.Instructions{
-						code.Make(code.OpConstant, 0),
-						code.Make(code.OpSetLocal, 0),
-						code.Make(code.OpGetLocal, 0),
-						code.Make(code.OpReturnValue),
+						code.MustMake(code.OpConstant, 0),
+						code.MustMake(code.OpSetLocal, 0),
+						code.MustMake(code.OpGetLocal, 0),
+						code.MustMake(code.OpReturnValue),
 					},
 					numLocals:     1,
 					numParameters: 0,
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
@@ -589,10 +598,10 @@ firstFoobar() + secondFoobar();
 				50,
 				expectedCompiledFunction{
 					instructions: []code.Instructions{
-						code.Make(code.OpConstant, 0),
-						code.Make(code.OpSetLocal, 0),
-						code.Make(code.OpGetLocal, 0),
-						code.Make(code.OpReturnValue),
+						code.MustMake(code.OpConstant, 0),
+						code.MustMake(code.OpSetLocal, 0),
+						code.MustMake(code.OpGetLocal, 0),
+						code.MustMake(code.OpReturnValue),
 					},
 					numLocals:     1,
 					numParameters: 0,
@@ -600,26 +609,26 @@ firstFoobar() + secondFoobar();
 				100,
 				expectedCompiledFunction{
 					instructions: []code.Instructions{
-						code.Make(code.OpConstant, 2),
-						code.Make(code.OpSetLocal, 0),
-						code.Make(code.OpGetLocal, 0),
-						code.Make(code.OpReturnValue),
+						code.MustMake(code.OpConstant, 2),
+						code.MustMake(code.OpSetLocal, 0),
+						code.MustMake(code.OpGetLocal, 0),
+						code.MustMake(code.OpReturnValue),
 					},
 					numLocals:     1,
 					numParameters: 0,
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpSetGlobal, 0),
-				code.Make(code.OpConstant, 3),
-				code.Make(code.OpSetGlobal, 1),
-				code.Make(code.OpGetGlobal, 0),
-				code.Make(code.OpCall, 0),
-				code.Make(code.OpGetGlobal, 1),
-				code.Make(code.OpCall, 0),
-				code.Make(code.OpAdd),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpConstant, 3),
+				code.MustMake(code.OpSetGlobal, 1),
+				code.MustMake(code.OpGetGlobal, 0),
+				code.MustMake(code.OpCall, 0),
+				code.MustMake(code.OpGetGlobal, 1),
+				code.MustMake(code.OpCall, 0),
+				code.MustMake(code.OpAdd),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
@@ -634,22 +643,22 @@ a+b }
 				77,
 				expectedCompiledFunction{
 					instructions: []code.Instructions{
-						code.Make(code.OpConstant, 0),
-						code.Make(code.OpSetLocal, 0),
-						code.Make(code.OpConstant, 1),
-						code.Make(code.OpSetLocal, 1),
-						code.Make(code.OpGetLocal, 0),
-						code.Make(code.OpGetLocal, 1),
-						code.Make(code.OpAdd),
-						code.Make(code.OpReturnValue),
+						code.MustMake(code.OpConstant, 0),
+						code.MustMake(code.OpSetLocal, 0),
+						code.MustMake(code.OpConstant, 1),
+						code.MustMake(code.OpSetLocal, 1),
+						code.MustMake(code.OpGetLocal, 0),
+						code.MustMake(code.OpGetLocal, 1),
+						code.MustMake(code.OpAdd),
+						code.MustMake(code.OpReturnValue),
 					},
 					numLocals:     2,
 					numParameters: 0,
 				},
 			},
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpPop),
 			},
 		},
 	}
@@ -666,10 +675,10 @@ func TestFunctionParameters(t *testing.T) {
 				// get x, get y, add, return
 				expectedCompiledFunction{
 					instructions: []code.Instructions{
-						code.Make(code.OpGetLocal, 0),
-						code.Make(code.OpGetLocal, 1),
-						code.Make(code.OpAdd),
-						code.Make(code.OpReturnValue),
+						code.MustMake(code.OpGetLocal, 0),
+						code.MustMake(code.OpGetLocal, 1),
+						code.MustMake(code.OpAdd),
+						code.MustMake(code.OpReturnValue),
 					},
 					numLocals: 2,
 				},
@@ -677,8 +686,8 @@ func TestFunctionParameters(t *testing.T) {
 
 			// we load the function onto the stack then pop it since we do nothing with it
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
@@ -689,14 +698,14 @@ func TestFunctionParameters(t *testing.T) {
 				1,
 				expectedCompiledFunction{
 					instructions: []code.Instructions{
-						code.Make(code.OpConstant, 0),
-						code.Make(code.OpSetLocal, 2),
-						code.Make(code.OpGetLocal, 0),
-						code.Make(code.OpGetLocal, 1),
-						code.Make(code.OpAdd),
-						code.Make(code.OpGetLocal, 2),
-						code.Make(code.OpAdd),
-						code.Make(code.OpReturnValue),
+						code.MustMake(code.OpConstant, 0),
+						code.MustMake(code.OpSetLocal, 2),
+						code.MustMake(code.OpGetLocal, 0),
+						code.MustMake(code.OpGetLocal, 1),
+						code.MustMake(code.OpAdd),
+						code.MustMake(code.OpGetLocal, 2),
+						code.MustMake(code.OpAdd),
+						code.MustMake(code.OpReturnValue),
 					},
 					numLocals: 3,
 				},
@@ -704,8 +713,8 @@ func TestFunctionParameters(t *testing.T) {
 
 			// we load the function onto the stack then pop it since we do nothing with it
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpPop),
 			},
 		},
 		{
@@ -717,10 +726,10 @@ func TestFunctionParameters(t *testing.T) {
 				// get x, get y, add, return
 				expectedCompiledFunction{
 					instructions: []code.Instructions{
-						code.Make(code.OpGetLocal, 0),
-						code.Make(code.OpGetLocal, 1),
-						code.Make(code.OpAdd),
-						code.Make(code.OpReturnValue),
+						code.MustMake(code.OpGetLocal, 0),
+						code.MustMake(code.OpGetLocal, 1),
+						code.MustMake(code.OpAdd),
+						code.MustMake(code.OpReturnValue),
 					},
 					numLocals: 2,
 				},
@@ -729,13 +738,745 @@ func TestFunctionParameters(t *testing.T) {
 			},
 
 			expectedInstructions: []code.Instructions{
-				code.Make(code.OpConstant, 0),
-				code.Make(code.OpConstant, 1),
-				code.Make(code.OpConstant, 2),
-				code.Make(code.OpCall, 2),
-				code.Make(code.OpPop),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpCall, 2),
+				code.MustMake(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestIndexExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "[1, 2][1]",
+			expectedConstants: []interface{}{1, 2, 1},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpArray, 2),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpIndex),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             `"abc"[0]`,
+			expectedConstants: []interface{}{"abc", 0},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpIndex),
+				code.MustMake(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestHashLiterals(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "{}",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpHash, 0),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			// keys and values alternate, in source order
+			input:             `{"b": 1, "a": 2 + 3}`,
+			expectedConstants: []interface{}{"b", 1, "a", 2, 3},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpConstant, 3),
+				code.MustMake(code.OpConstant, 4),
+				code.MustMake(code.OpAdd),
+				code.MustMake(code.OpHash, 4),
+				code.MustMake(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "try { 1 } catch (e) { e }",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpTry, 14),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpEndTry),
+				code.MustMake(code.OpJump, 21),
+				code.MustMake(code.OpCatch),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpGetGlobal, 0),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			// the finally block is laid out once for each way out of the try
+			// block, and the second one rethrows the error
+			input:             "try { 1 } finally { 2 }",
+			expectedConstants: []interface{}{1, 2, 2},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpTry, 18),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpEndTry),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpPop),
+				code.MustMake(code.OpJump, 23),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpPop),
+				code.MustMake(code.OpThrow),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			// a return leaves the try block and runs the finally block first
+			input: "fn() { try { return 1 } finally { 2 } }",
+			expectedConstants: []interface{}{
+				1, 2, 2, 2,
+				expectedCompiledFunction{instructions: []code.Instructions{
+					code.MustMake(code.OpTry, 24),
+					code.MustMake(code.OpConstant, 0),
+					code.MustMake(code.OpEndTry),
+					code.MustMake(code.OpConstant, 1),
+					code.MustMake(code.OpPop),
+					code.MustMake(code.OpReturnValue),
+					code.MustMake(code.OpEndTry),
+					code.MustMake(code.OpConstant, 2),
+					code.MustMake(code.OpPop),
+					code.MustMake(code.OpJump, 29),
+					code.MustMake(code.OpConstant, 3),
+					code.MustMake(code.OpPop),
+					code.MustMake(code.OpThrow),
+					code.MustMake(code.OpReturnValue),
+				}},
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 4),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             `throw "oops"`,
+			expectedConstants: []interface{}{"oops"},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpThrow),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestDeferStatements(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input: "let f = fn(x) { x }; fn() { defer f(1); 2 }",
+			expectedConstants: []interface{}{
+				expectedCompiledFunction{instructions: []code.Instructions{
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpReturnValue),
+				}, numLocals: 1, numParameters: 1},
+				1,
+				2,
+				expectedCompiledFunction{instructions: []code.Instructions{
+					code.MustMake(code.OpGetGlobal, 0),
+					code.MustMake(code.OpConstant, 1),
+					code.MustMake(code.OpDefer, 1),
+					code.MustMake(code.OpConstant, 2),
+					code.MustMake(code.OpReturnValue),
+				}},
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpConstant, 3),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			// the call in tail position isn't a tail call, since the deferred
+			// call is made after it returns
+			input: "let f = fn(x) { defer f(x); f(x) }",
+			expectedConstants: []interface{}{
+				expectedCompiledFunction{instructions: []code.Instructions{
+					code.MustMake(code.OpGetGlobal, 0),
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpDefer, 1),
+					code.MustMake(code.OpGetGlobal, 0),
+					code.MustMake(code.OpGetLocal, 0),
+					code.MustMake(code.OpCall, 1),
+					code.MustMake(code.OpReturnValue),
+				}, numLocals: 1, numParameters: 1},
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpSetGlobal, 0),
+			},
+		},
+		{
+			// a body ending in a statement returns null
+			input: "fn() { defer len(); }",
+			expectedConstants: []interface{}{
+				expectedCompiledFunction{instructions: []code.Instructions{
+					code.MustMake(code.OpGetBuiltin, 0),
+					code.MustMake(code.OpDefer, 0),
+					code.MustMake(code.OpReturn),
+				}},
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpPop),
 			},
 		},
 	}
 	runCompilerTests(t, tests)
 }
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "match (5) { 1 => 10, n if n > 2 => n }",
+			expectedConstants: []interface{}{5, 1, 10, 2},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpGetGlobal, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpMatchValue),
+				code.MustMake(code.OpJumpNotTruthy, 26),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpJump, 56),
+				code.MustMake(code.OpGetGlobal, 0),
+				code.MustMake(code.OpSetGlobal, 1),
+				code.MustMake(code.OpGetGlobal, 1),
+				code.MustMake(code.OpConstant, 3),
+				code.MustMake(code.OpGreaterThan),
+				code.MustMake(code.OpJumpNotTruthy, 52),
+				code.MustMake(code.OpGetGlobal, 1),
+				code.MustMake(code.OpJump, 56),
+				code.MustMake(code.OpGetGlobal, 0),
+				code.MustMake(code.OpNoMatch),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			// nothing after an arm that can't fail is compiled
+			input:             "match ([1]) { [x, ...] => x, _ => 0, 2 => 3 }",
+			expectedConstants: []interface{}{1, 0, 0},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpArray, 1),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpGetGlobal, 0),
+				code.MustMake(code.OpMatchArrayMin, 1),
+				code.MustMake(code.OpJumpNotTruthy, 38),
+				code.MustMake(code.OpGetGlobal, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpIndex),
+				code.MustMake(code.OpSetGlobal, 1),
+				code.MustMake(code.OpGetGlobal, 1),
+				code.MustMake(code.OpJump, 41),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			// the array inside the hash is kept in a slot of its own
+			input:             `match ({}) { {"a": [y]} => y }`,
+			expectedConstants: []interface{}{"a", "a", 0},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpHash, 0),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpGetGlobal, 0),
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpMatchHash, 1),
+				code.MustMake(code.OpJumpNotTruthy, 59),
+				code.MustMake(code.OpGetGlobal, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpIndex),
+				code.MustMake(code.OpSetGlobal, 1),
+				code.MustMake(code.OpGetGlobal, 1),
+				code.MustMake(code.OpMatchArray, 1),
+				code.MustMake(code.OpJumpNotTruthy, 59),
+				code.MustMake(code.OpGetGlobal, 1),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpIndex),
+				code.MustMake(code.OpSetGlobal, 2),
+				code.MustMake(code.OpGetGlobal, 2),
+				code.MustMake(code.OpJump, 63),
+				code.MustMake(code.OpGetGlobal, 0),
+				code.MustMake(code.OpNoMatch),
+				code.MustMake(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestInterpolatedStrings(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             `"a${1}b${2 + 3}"`,
+			expectedConstants: []interface{}{"a", 1, "b", 2, 3},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpConstant, 3),
+				code.MustMake(code.OpConstant, 4),
+				code.MustMake(code.OpAdd),
+				code.MustMake(code.OpInterpolate, 4),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			// empty text between the values isn't pushed
+			input:             `"${true}${false}"`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpTrue),
+				code.MustMake(code.OpFalse),
+				code.MustMake(code.OpInterpolate, 2),
+				code.MustMake(code.OpPop),
+			},
+		},
+	}
+	runCompilerTests(t, tests)
+}
+
+func TestSourceMaps(t *testing.T) {
+	input := `let x = 1;
+x + 2;
+let f = fn() {
+  x * 3
+};`
+	program := parse(input)
+	compiler := New()
+	err := compiler.Compile(program)
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	bytecode := compiler.Bytecode()
+
+	tests := []struct {
+		sourceMap code.SourceMap
+		offset    int
+		expected  string
+	}{
+		// OpConstant 0 for the literal 1
+		{bytecode.SourceMap, 0, "1:9"},
+		// OpSetGlobal 0 belongs to the let statement
+		{bytecode.SourceMap, 3, "1:1"},
+		// OpAdd belongs to the infix operator
+		{bytecode.SourceMap, 12, "2:3"},
+		// OpPop belongs to the expression statement
+		{bytecode.SourceMap, 13, "2:1"},
+		// OpMul inside the function body
+		{bytecode.Constants[3].(*object.CompiledFunction).SourceMap, 6, "4:5"},
+	}
+	for i, tt := range tests {
+		pos, ok := tt.sourceMap.Lookup(tt.offset)
+		if !ok {
+			t.Errorf("test[%d]: no position for offset %d", i, tt.offset)
+			continue
+		}
+		if pos.String() != tt.expected {
+			t.Errorf("test[%d]: wrong position for offset %d. want=%s, got=%s", i, tt.offset, tt.expected, pos)
+		}
+	}
+
+	fn := bytecode.Constants[3].(*object.CompiledFunction)
+	if fn.Name != "f" {
+		t.Errorf("function has wrong name. want=%q, got=%q", "f", fn.Name)
+	}
+}
+
+func runOptimizedCompilerTests(t *testing.T, tests []compilerTestCase) {
+	t.Helper()
+
+	for i, tt := range tests {
+		program := parse(tt.input)
+		compiler := New()
+		compiler.SetOptimize(true)
+		err := compiler.Compile(program)
+		if err != nil {
+			t.Fatalf("test[%d]: compiler error: %s", i, err)
+		}
+
+		bytecode := compiler.Bytecode()
+		err = testInstructions(tt.expectedInstructions, bytecode.Instructions)
+		if err != nil {
+			t.Errorf("test[%d]: testInstructions failed: %s", i, err)
+		}
+		err = testConstants(tt.expectedConstants, bytecode.Constants)
+		if err != nil {
+			t.Errorf("test[%d]: testConstants failed: %s", i, err)
+		}
+	}
+}
+
+func TestConstantFolding(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "1 + 2 * 3",
+			expectedConstants: []interface{}{7},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             "-(10 / 2) < 1",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpTrue),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             `"foo" + "bar" == "foobar"`,
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpTrue),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             "!(true == false)",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpTrue),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			// division by zero must still fail at runtime
+			input:             "1 / (2 - 2)",
+			expectedConstants: []interface{}{1, 0},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpDiv),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			// so must type errors
+			input:             "1 + true",
+			expectedConstants: []interface{}{1},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpTrue),
+				code.MustMake(code.OpAdd),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             `let n = 2; "${n} + ${n} is ${n + n}: ${true}"`,
+			expectedConstants: []interface{}{2, "2 + 2 is 4: true"},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpPop),
+			},
+		},
+	}
+	runOptimizedCompilerTests(t, tests)
+}
+
+func TestConstantIfElimination(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "if (true) { 10 } else { 20 }; 3333;",
+			expectedConstants: []interface{}{10, 3333},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpPop),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             "if (1 > 2) { 10 } else { 20 }",
+			expectedConstants: []interface{}{20},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             "if (false) { 10 }",
+			expectedConstants: []interface{}{},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpNull),
+				code.MustMake(code.OpPop),
+			},
+		},
+	}
+	runOptimizedCompilerTests(t, tests)
+}
+
+func TestConstantPropagation(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			input:             "let x = 2; let y = x * 3; y + 1",
+			expectedConstants: []interface{}{2, 6, 7},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpSetGlobal, 1),
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			// x is reassigned, so it can't be propagated
+			input:             "let x = 1; let x = 2; x",
+			expectedConstants: []interface{}{1, 2},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpSetGlobal, 1),
+				code.MustMake(code.OpGetGlobal, 1),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			// the parameter shadows the global inside the function
+			input: "let x = 1; fn(x) { x + x }",
+			expectedConstants: []interface{}{
+				1,
+				expectedCompiledFunction{
+					instructions: []code.Instructions{
+						code.MustMake(code.OpGetLocal, 0),
+						code.MustMake(code.OpDup),
+						code.MustMake(code.OpAdd),
+						code.MustMake(code.OpReturnValue),
+					},
+					numLocals: 1,
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input: "let x = 1; fn() { let y = 2; x + y }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				3,
+				expectedCompiledFunction{
+					instructions: []code.Instructions{
+						code.MustMake(code.OpConstant, 1),
+						code.MustMake(code.OpSetLocal, 0),
+						code.MustMake(code.OpConstant, 2),
+						code.MustMake(code.OpReturnValue),
+					},
+					numLocals: 1,
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpSetGlobal, 0),
+				code.MustMake(code.OpConstant, 3),
+				code.MustMake(code.OpPop),
+			},
+		},
+	}
+	runOptimizedCompilerTests(t, tests)
+}
+
+func TestPeephole(t *testing.T) {
+	tests := []compilerTestCase{
+		{
+			// the inner if's jump goes straight to the end of the outer one
+			input: "fn(a, b) { if (a) { if (b) { 1 } else { 2 } } else { 3 } }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				3,
+				expectedCompiledFunction{
+					instructions: []code.Instructions{
+						code.MustMake(code.OpGetLocal, 0),
+						code.MustMake(code.OpJumpNotTruthy, 30),
+						code.MustMake(code.OpGetLocal, 1),
+						code.MustMake(code.OpJumpNotTruthy, 22),
+						code.MustMake(code.OpConstant, 0),
+						code.MustMake(code.OpJump, 33),
+						code.MustMake(code.OpConstant, 1),
+						code.MustMake(code.OpJump, 33),
+						code.MustMake(code.OpConstant, 2),
+						code.MustMake(code.OpReturnValue),
+					},
+					numLocals: 2,
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 3),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input:             "if (false) { 10 }; 5",
+			expectedConstants: []interface{}{5},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			// the null is a jump target, so it has to stay
+			input: "fn(a) { if (a) { 1 }; 2 }",
+			expectedConstants: []interface{}{
+				1,
+				2,
+				expectedCompiledFunction{
+					instructions: []code.Instructions{
+						code.MustMake(code.OpGetLocal, 0),
+						code.MustMake(code.OpJumpNotTruthy, 15),
+						code.MustMake(code.OpConstant, 0),
+						code.MustMake(code.OpJump, 16),
+						code.MustMake(code.OpNull),
+						code.MustMake(code.OpPop),
+						code.MustMake(code.OpConstant, 1),
+						code.MustMake(code.OpReturnValue),
+					},
+					numLocals: 1,
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 2),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input: "fn(a) { a + 1 }",
+			expectedConstants: []interface{}{
+				1,
+				expectedCompiledFunction{
+					instructions: []code.Instructions{
+						code.MustMake(code.OpGetLocal, 0),
+						code.MustMake(code.OpAddConstant, 0),
+						code.MustMake(code.OpReturnValue),
+					},
+					numLocals: 1,
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 1),
+				code.MustMake(code.OpPop),
+			},
+		},
+		{
+			input: "fn(x) { x * x }",
+			expectedConstants: []interface{}{
+				expectedCompiledFunction{
+					instructions: []code.Instructions{
+						code.MustMake(code.OpGetLocal, 0),
+						code.MustMake(code.OpDup),
+						code.MustMake(code.OpMul),
+						code.MustMake(code.OpReturnValue),
+					},
+					numLocals: 1,
+				},
+			},
+			expectedInstructions: []code.Instructions{
+				code.MustMake(code.OpConstant, 0),
+				code.MustMake(code.OpPop),
+			},
+		},
+	}
+	runOptimizedCompilerTests(t, tests)
+}
+
+// letterName spells out i with letters, since identifiers can't contain digits.
+func letterName(i int) string {
+	return strings.Map(func(r rune) rune { return 'a' + r - '0' }, fmt.Sprint(i))
+}
+
+func TestWideOperands(t *testing.T) {
+	var input strings.Builder
+	input.WriteString("fn() { ")
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&input, "let x%s = 1; ", letterName(i))
+	}
+	input.WriteString("x" + letterName(299) + " }")
+
+	compiler := New()
+	err := compiler.Compile(parse(input.String()))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	fn, ok := compiler.Bytecode().Constants[300].(*object.CompiledFunction)
+	if !ok {
+		t.Fatalf("constant 300 is not a function. got=%T", compiler.Bytecode().Constants[300])
+	}
+	for _, want := range []string{"OpSetLocal 255\n", "OpSetLocalWide 256\n", "OpGetLocalWide 299\n"} {
+		if !strings.Contains(fn.Instructions.String(), want) {
+			t.Errorf("expected %q in\n%s", want, fn.Instructions)
+		}
+	}
+}
+
+func TestCompilerLimits(t *testing.T) {
+	repeat := func(format string, n int, sep string) string {
+		parts := make([]string, n)
+		for i := range parts {
+			parts[i] = fmt.Sprintf(format, letterName(i))
+		}
+		return strings.Join(parts, sep)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			repeat("let g%s = true;", 65537, " "),
+			"too many globals: a program can define at most 65536",
+		},
+		{
+			"fn() { " + repeat("let l%s = true;", 65537, " ") + " }",
+			"too many locals: a function can define at most 65536",
+		},
+		{
+			"fn(" + repeat("p%s", 65537, ", ") + ") { 1 }",
+			"too many locals: a function can define at most 65536",
+		},
+		{
+			"let f = fn() { 1 }; f(" + strings.Repeat("true, ", 65535) + "true)",
+			"too many arguments: a call can pass at most 65535",
+		},
+	}
+
+	for i, tt := range tests {
+		compiler := New()
+		err := compiler.Compile(parse(tt.input))
+		if err == nil {
+			t.Errorf("test[%d]: expected a compiler error", i)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("test[%d]: wrong error. want=%q, got=%q", i, tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package compiler
+
+import (
+	"strconv"
+	"strings"
+
+	"interpego/ast"
+	"interpego/object"
+	"interpego/token"
+)
+
+// constScope tracks the bindings of a single Monkey scope (the program or a
+// function body) while the optimiser walks it.
+type constScope struct {
+	outer *constScope
+	// number of let statements and parameters binding each name in this scope
+	bindings map[string]int
+	// literal values of names bound exactly once, by a let statement that has
+	// already been walked
+	consts map[string]ast.Expression
+}
+
+func newConstScope(outer *constScope, params []*ast.Identifier, body []ast.Statement) *constScope {
+	scope := &constScope{outer: outer, bindings: make(map[string]int), consts: make(map[string]ast.Expression)}
+	for _, param := range params {
+		scope.bindings[param.Value]++
+	}
+	for _, stmt := range body {
+		scope.countBindings(stmt)
+	}
+	return scope
+}
+
+// countBindings counts the let statements and patterns in node that bind
+// names in this scope. Blocks don't introduce a scope in Monkey, but function
+// literals do. Any expression can contain a block, so the whole of node is
+// walked.
+func (s *constScope) countBindings(node ast.Node) {
+	switch node := node.(type) {
+	case *ast.LetStatement:
+		s.bindings[node.Name.Value]++
+		s.countBindings(node.Value)
+	case *ast.ReturnStatement:
+		s.countBindings(node.ReturnValue)
+	case *ast.ExpressionStatement:
+		s.countBindings(node.Expression)
+	case *ast.IfExpression:
+		s.countBindings(node.Condition)
+		s.countBindings(node.Consequence)
+		if node.Alternative != nil {
+			s.countBindings(node.Alternative)
+		}
+	case *ast.ForLoop:
+		s.countBindings(node.InitStatement)
+		s.countBindings(node.Condition)
+		s.countBindings(node.PostStatement)
+		s.countBindings(node.ForBody)
+	case *ast.PrefixExpression:
+		s.countBindings(node.Right)
+	case *ast.InfixExpression:
+		s.countBindings(node.Left)
+		s.countBindings(node.Right)
+	case *ast.CallExpression:
+		s.countBindings(node.Function)
+		for _, arg := range node.Arguments {
+			s.countBindings(arg)
+		}
+	case *ast.ArrayLiteral:
+		for _, elem := range node.Elements {
+			s.countBindings(elem)
+		}
+	case *ast.IndexExpression:
+		s.countBindings(node.Left)
+		s.countBindings(node.Index)
+	case *ast.HashLiteral:
+		for _, key := range node.Keys {
+			s.countBindings(key)
+			s.countBindings(node.Pairs[key])
+		}
+	case *ast.InterpolatedString:
+		for _, value := range node.Values {
+			s.countBindings(value)
+		}
+	case *ast.ThrowStatement:
+		s.countBindings(node.Value)
+	case *ast.TryExpression:
+		s.countBindings(node.Block)
+		if node.Catch != nil {
+			s.bindings[node.CatchParam.Value]++
+			s.countBindings(node.Catch)
+		}
+		if node.Finally != nil {
+			s.countBindings(node.Finally)
+		}
+	case *ast.MatchExpression:
+		s.countBindings(node.Value)
+		for _, arm := range node.Arms {
+			s.countBindings(arm.Pattern)
+			if arm.Guard != nil {
+				s.countBindings(arm.Guard)
+			}
+			s.countBindings(arm.Result)
+		}
+	case *ast.BindingPattern:
+		s.bindings[node.Name.Value]++
+	case *ast.ArrayPattern:
+		for _, elem := range node.Elements {
+			s.countBindings(elem)
+		}
+		if node.Rest != nil {
+			s.countBindings(node.Rest)
+		}
+	case *ast.HashPattern:
+		for _, value := range node.Values {
+			s.countBindings(value)
+		}
+	case *ast.BlockStatement:
+		for _, stmt := range node.Statements {
+			s.countBindings(stmt)
+		}
+	}
+}
+
+func (s *constScope) resolve(name string) (ast.Expression, bool) {
+	for scope := s; scope != nil; scope = scope.outer {
+		if scope.bindings[name] > 0 {
+			value, ok := scope.consts[name]
+			return value, ok
+		}
+	}
+	return nil, false
+}
+
+// optimize folds constant expressions in program and propagates let bindings
+// of constants that are never reassigned. It rewrites the tree in place.
+// Expressions whose evaluation could fail at runtime, like a division by zero
+// or an operator applied to the wrong types, are left alone so the error is
+// still raised when the program runs.
+func optimize(program *ast.Program) {
+	scope := newConstScope(nil, nil, program.Statements)
+	for _, stmt := range program.Statements {
+		optimizeStatement(scope, stmt, true)
+	}
+}
+
+// optimizeStatement rewrites stmt. topLevel is set for statements directly in
+// a program or function body, whose let statements always run.
+func optimizeStatement(scope *constScope, stmt ast.Statement, topLevel bool) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		stmt.Value = optimizeExpression(scope, stmt.Value)
+		name := stmt.Name.Value
+		if topLevel && scope.bindings[name] == 1 && isConstant(stmt.Value) {
+			scope.consts[name] = stmt.Value
+		}
+	case *ast.ReturnStatement:
+		stmt.ReturnValue = optimizeExpression(scope, stmt.ReturnValue)
+	case *ast.ThrowStatement:
+		stmt.Value = optimizeExpression(scope, stmt.Value)
+	case *ast.DeferStatement:
+		optimizeExpression(scope, stmt.Call)
+	case *ast.ExpressionStatement:
+		stmt.Expression = optimizeExpression(scope, stmt.Expression)
+	}
+}
+
+func optimizeBlock(scope *constScope, block *ast.BlockStatement) {
+	for _, stmt := range block.Statements {
+		optimizeStatement(scope, stmt, false)
+	}
+}
+
+func optimizeExpression(scope *constScope, exp ast.Expression) ast.Expression {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		if value, ok := scope.resolve(exp.Value); ok {
+			return copyConstant(value, exp.Token.Position)
+		}
+	case *ast.PrefixExpression:
+		exp.Right = optimizeExpression(scope, exp.Right)
+		if folded := foldPrefix(exp); folded != nil {
+			return folded
+		}
+	case *ast.InfixExpression:
+		exp.Left = optimizeExpression(scope, exp.Left)
+		exp.Right = optimizeExpression(scope, exp.Right)
+		if folded := foldInfix(exp); folded != nil {
+			return folded
+		}
+	case *ast.IfExpression:
+		exp.Condition = optimizeExpression(scope, exp.Condition)
+		optimizeBlock(scope, exp.Consequence)
+		if exp.Alternative != nil {
+			optimizeBlock(scope, exp.Alternative)
+		}
+	case *ast.ForLoop:
+		optimizeStatement(scope, exp.InitStatement, false)
+		exp.Condition = optimizeExpression(scope, exp.Condition)
+		optimizeStatement(scope, exp.PostStatement, false)
+		optimizeBlock(scope, exp.ForBody)
+	case *ast.TryExpression:
+		optimizeBlock(scope, exp.Block)
+		if exp.Catch != nil {
+			optimizeBlock(scope, exp.Catch)
+		}
+		if exp.Finally != nil {
+			optimizeBlock(scope, exp.Finally)
+		}
+	case *ast.MatchExpression:
+		exp.Value = optimizeExpression(scope, exp.Value)
+		for _, arm := range exp.Arms {
+			if arm.Guard != nil {
+				arm.Guard = optimizeExpression(scope, arm.Guard)
+			}
+			arm.Result = optimizeExpression(scope, arm.Result)
+		}
+	case *ast.FunctionLiteral:
+		fnScope := newConstScope(scope, exp.Parameters, exp.FunctionBody.Statements)
+		for _, stmt := range exp.FunctionBody.Statements {
+			optimizeStatement(fnScope, stmt, true)
+		}
+	case *ast.CallExpression:
+		exp.Function = optimizeExpression(scope, exp.Function)
+		for i, arg := range exp.Arguments {
+			exp.Arguments[i] = optimizeExpression(scope, arg)
+		}
+	case *ast.ArrayLiteral:
+		for i, elem := range exp.Elements {
+			exp.Elements[i] = optimizeExpression(scope, elem)
+		}
+	case *ast.IndexExpression:
+		exp.Left = optimizeExpression(scope, exp.Left)
+		exp.Index = optimizeExpression(scope, exp.Index)
+	case *ast.InterpolatedString:
+		for i, value := range exp.Values {
+			exp.Values[i] = optimizeExpression(scope, value)
+		}
+		if folded := foldInterpolation(exp); folded != nil {
+			return folded
+		}
+	case *ast.HashLiteral:
+		pairs := make(map[ast.Expression]ast.Expression, len(exp.Pairs))
+		for i, key := range exp.Keys {
+			value := exp.Pairs[key]
+			exp.Keys[i] = optimizeExpression(scope, key)
+			pairs[exp.Keys[i]] = optimizeExpression(scope, value)
+		}
+		exp.Pairs = pairs
+	}
+	return exp
+}
+
+func isConstant(exp ast.Expression) bool {
+	switch exp.(type) {
+	case *ast.IntegerLiteral, *ast.StringLiteral, *ast.BooleanLiteral:
+		return true
+	default:
+		return false
+	}
+}
+
+// copyConstant returns a new literal with the same value as constant, placed
+// at pos so errors still point at the original use.
+func copyConstant(constant ast.Expression, pos token.Position) ast.Expression {
+	switch constant := constant.(type) {
+	case *ast.IntegerLiteral:
+		return newIntegerLiteral(integerValue(constant), pos)
+	case *ast.StringLiteral:
+		return newStringLiteral(constant.Value, pos)
+	case *ast.BooleanLiteral:
+		return newBooleanLiteral(constant.Value, pos)
+	default:
+		return constant
+	}
+}
+
+func foldPrefix(exp *ast.PrefixExpression) ast.Expression {
+	switch right := exp.Right.(type) {
+	case *ast.IntegerLiteral:
+		if exp.Operator == "-" {
+			return newIntegerLiteral(object.NegateInteger(integerValue(right)), exp.Token.Position)
+		}
+	case *ast.BooleanLiteral:
+		if exp.Operator == "!" {
+			return newBooleanLiteral(!right.Value, exp.Token.Position)
+		}
+	}
+	return nil
+}
+
+func foldInfix(exp *ast.InfixExpression) ast.Expression {
+	pos := exp.Token.Position
+	switch left := exp.Left.(type) {
+	case *ast.IntegerLiteral:
+		right, ok := exp.Right.(*ast.IntegerLiteral)
+		if !ok {
+			return nil
+		}
+		switch exp.Operator {
+		case "+", "-", "*", "/":
+			// division by zero is left for the program to raise
+			value, err := object.IntegerArithmetic(exp.Operator, integerValue(left), integerValue(right))
+			if err != nil {
+				return nil
+			}
+			return newIntegerLiteral(value, pos)
+		case "<":
+			return newBooleanLiteral(object.CompareIntegers(integerValue(left), integerValue(right)) < 0, pos)
+		case ">":
+			return newBooleanLiteral(object.CompareIntegers(integerValue(left), integerValue(right)) > 0, pos)
+		case "==":
+			return newBooleanLiteral(object.CompareIntegers(integerValue(left), integerValue(right)) == 0, pos)
+		case "!=":
+			return newBooleanLiteral(object.CompareIntegers(integerValue(left), integerValue(right)) != 0, pos)
+		}
+	case *ast.StringLiteral:
+		right, ok := exp.Right.(*ast.StringLiteral)
+		if !ok {
+			return nil
+		}
+		switch exp.Operator {
+		case "+":
+			return newStringLiteral(left.Value+right.Value, pos)
+		case "<":
+			return newBooleanLiteral(left.Value < right.Value, pos)
+		case ">":
+			return newBooleanLiteral(left.Value > right.Value, pos)
+		case "==":
+			return newBooleanLiteral(left.Value == right.Value, pos)
+		case "!=":
+			return newBooleanLiteral(left.Value != right.Value, pos)
+		}
+	case *ast.BooleanLiteral:
+		right, ok := exp.Right.(*ast.BooleanLiteral)
+		if !ok {
+			return nil
+		}
+		switch exp.Operator {
+		case "==":
+			return newBooleanLiteral(left.Value == right.Value, pos)
+		case "!=":
+			return newBooleanLiteral(left.Value != right.Value, pos)
+		}
+	}
+	return nil
+}
+
+// foldInterpolation joins an interpolated string whose values are all
+// constants into a single string literal.
+func foldInterpolation(exp *ast.InterpolatedString) ast.Expression {
+	var out strings.Builder
+	for i, value := range exp.Values {
+		out.WriteString(exp.Strings[i])
+		switch value := value.(type) {
+		case *ast.IntegerLiteral:
+			out.WriteString(integerValue(value).Inspect())
+		case *ast.StringLiteral:
+			out.WriteString(value.Value)
+		case *ast.BooleanLiteral:
+			out.WriteString(strconv.FormatBool(value.Value))
+		default:
+			return nil
+		}
+	}
+	out.WriteString(exp.Strings[len(exp.Strings)-1])
+	return newStringLiteral(out.String(), exp.Token.Position)
+}
+
+// integerValue returns the value of an integer literal as an Integer or, if it
+// doesn't fit in 64 bits, a BigInt.
+func integerValue(lit *ast.IntegerLiteral) object.Object {
+	if lit.Big != nil {
+		return &object.BigInt{Value: lit.Big}
+	}
+	return &object.Integer{Value: lit.Value}
+}
+
+// newIntegerLiteral returns a literal for value, which is an Integer or a
+// BigInt.
+func newIntegerLiteral(value object.Object, pos token.Position) *ast.IntegerLiteral {
+	lit := &ast.IntegerLiteral{Token: token.Token{Type: token.INT, Literal: value.Inspect(), Position: pos}}
+	switch value := value.(type) {
+	case *object.Integer:
+		lit.Value = value.Value
+	case *object.BigInt:
+		lit.Big = value.Value
+	}
+	return lit
+}
+
+func newStringLiteral(value string, pos token.Position) *ast.StringLiteral {
+	return &ast.StringLiteral{Token: token.Token{Type: token.STRING, Literal: value, Position: pos}, Value: value}
+}
+
+func newBooleanLiteral(value bool, pos token.Position) *ast.BooleanLiteral {
+	tok := token.Token{Type: token.FALSE, Literal: "false", Position: pos}
+	if value {
+		tok = token.Token{Type: token.TRUE, Literal: "true", Position: pos}
+	}
+	return &ast.BooleanLiteral{Token: tok, Value: value}
+}
@@ -0,0 +1,161 @@
+package compiler
+
+import (
+	"interpego/code"
+	"interpego/token"
+)
+
+// decodedInstruction is a single instruction taken out of a scope's bytecode so
+// the peephole pass can rewrite it without worrying about byte offsets.
+type decodedInstruction struct {
+	op       code.Opcode
+	operands []int
+	offset   int // offset in the instructions before the pass ran
+	position token.Position
+	removed  bool
+}
+
+// isJump reports whether op's operand is an address. OpTry counts, since the
+// address of its handler is laid out like a jump's target.
+func isJump(op code.Opcode) bool {
+	return op == code.OpJump || op == code.OpJumpNotTruthy || op == code.OpTry
+}
+
+// peephole rewrites the current scope's instructions:
+//   - jumps that land on an unconditional jump go straight to its target
+//   - an OpNull that is immediately popped is dropped along with the OpPop
+//   - OpConstant followed by OpAdd becomes OpAddConstant
+//   - OpGetLocal x followed by OpGetLocal x becomes OpGetLocal x; OpDup
+//
+// Instructions that are jump targets are never merged into the instruction
+// before them, and the scope's jumps and source map are rewritten to match the
+// new layout.
+func (c *Compiler) peephole() {
+	scope := &c.scopes[c.scopeIdx]
+	instructions := decodeInstructions(scope.instructions, scope.sourceMap)
+	if len(instructions) == 0 {
+		return
+	}
+
+	byOffset := make(map[int]int, len(instructions))
+	for i, ins := range instructions {
+		byOffset[ins.offset] = i
+	}
+
+	threadJumps(instructions, byOffset)
+
+	targets := make(map[int]bool)
+	for _, ins := range instructions {
+		if isJump(ins.op) {
+			targets[ins.operands[0]] = true
+		}
+	}
+
+	for i := 0; i+1 < len(instructions); i++ {
+		cur, next := &instructions[i], &instructions[i+1]
+		if cur.removed || targets[next.offset] {
+			continue
+		}
+		switch {
+		case cur.op == code.OpNull && next.op == code.OpPop:
+			// the final pop of the main program leaves the program's result
+			// for LastPoppedStackElement, so it has to stay
+			if c.scopeIdx == 0 && i+2 == len(instructions) {
+				continue
+			}
+			cur.removed = true
+			next.removed = true
+		case cur.op == code.OpConstant && next.op == code.OpAdd:
+			cur.op = code.OpAddConstant
+			// errors from the addition should point at the operator
+			cur.position = next.position
+			next.removed = true
+		case cur.op == code.OpGetLocal && next.op == code.OpGetLocal && cur.operands[0] == next.operands[0]:
+			next.op = code.OpDup
+			next.operands = nil
+		}
+	}
+
+	scope.instructions, scope.sourceMap = encodeInstructions(instructions, len(scope.instructions))
+
+	kept := decodeInstructions(scope.instructions, nil)
+	scope.lastInstruction, scope.prevInstruction = EmittedInstruction{}, EmittedInstruction{}
+	if n := len(kept); n > 0 {
+		scope.lastInstruction = EmittedInstruction{Opcode: kept[n-1].op, Position: kept[n-1].offset}
+		if n > 1 {
+			scope.prevInstruction = EmittedInstruction{Opcode: kept[n-2].op, Position: kept[n-2].offset}
+		}
+	}
+}
+
+func decodeInstructions(ins code.Instructions, sourceMap code.SourceMap) []decodedInstruction {
+	var decoded []decodedInstruction
+	for i := 0; i < len(ins); {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			// not something we understand, so leave the scope alone
+			return nil
+		}
+		operands, read := code.ReadOperands(ins[i+1:], def)
+		pos, _ := sourceMap.Lookup(i)
+		decoded = append(decoded, decodedInstruction{
+			op:       code.Opcode(ins[i]),
+			operands: operands,
+			offset:   i,
+			position: pos,
+		})
+		i += 1 + read
+	}
+	return decoded
+}
+
+// threadJumps retargets jumps whose target is an unconditional jump. Jump
+// operands still refer to the original offsets afterwards.
+func threadJumps(instructions []decodedInstruction, byOffset map[int]int) {
+	for i := range instructions {
+		if !isJump(instructions[i].op) {
+			continue
+		}
+		target := instructions[i].operands[0]
+		// bounded, so that a jump cycle can't loop forever
+		for hops := 0; hops < len(instructions); hops++ {
+			j, ok := byOffset[target]
+			if !ok || instructions[j].op != code.OpJump {
+				break
+			}
+			target = instructions[j].operands[0]
+		}
+		instructions[i].operands[0] = target
+	}
+}
+
+// encodeInstructions lays out the instructions that weren't removed, pointing
+// jumps at the new offsets. A jump to a removed instruction lands on the next
+// instruction that was kept.
+func encodeInstructions(instructions []decodedInstruction, oldLength int) (code.Instructions, code.SourceMap) {
+	newOffsets := make(map[int]int, len(instructions)+1)
+	end := 0
+	for i := range instructions {
+		newOffsets[instructions[i].offset] = end
+		if !instructions[i].removed {
+			end += len(code.MustMake(instructions[i].op, instructions[i].operands...))
+		}
+	}
+	// jumps may target the end of the instructions
+	newOffsets[oldLength] = end
+
+	var out code.Instructions
+	var sourceMap code.SourceMap
+	for _, ins := range instructions {
+		if ins.removed {
+			continue
+		}
+		operands := ins.operands
+		if isJump(ins.op) {
+			operands = []int{newOffsets[operands[0]]}
+		}
+		sourceMap = sourceMap.Add(len(out), ins.position)
+		out = append(out, code.MustMake(ins.op, operands...)...)
+	}
+	return out, sourceMap
+}
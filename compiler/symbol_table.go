@@ -1,10 +1,13 @@
 package compiler
 
+import "interpego/object"
+
 type SymbolScope string
 
 const (
-	GLOBAL_SCOPE SymbolScope = "GLOBAL_SCOPE"
-	LOCAL_SCOPE              = "LOCAL_SCOPE"
+	GLOBAL_SCOPE  SymbolScope = "GLOBAL_SCOPE"
+	LOCAL_SCOPE               = "LOCAL_SCOPE"
+	BUILTIN_SCOPE             = "BUILTIN_SCOPE"
 )
 
 type Symbol struct {
@@ -17,6 +20,12 @@ type SymbolTable struct {
 	outer          *SymbolTable
 	store          map[string]Symbol
 	numDefinitions int
+	// the program's table, for the table of an imported module, whose globals
+	// are numbered after the program's
+	program *SymbolTable
+	// number of globals, defined before the program's own, that the modules
+	// it imports can see too
+	numShared int
 }
 
 func NewSymbolTable() *SymbolTable {
@@ -27,6 +36,36 @@ func NewNestedSymbolTable(outer *SymbolTable) *SymbolTable {
 	return &SymbolTable{numDefinitions: 0, store: make(map[string]Symbol), outer: outer}
 }
 
+// NewModuleSymbolTable returns the table for the globals of a module imported
+// by the program whose symbols are in program, which may be the table of a
+// function the import is in. Both share the VM's globals, so the module's
+// globals take their indexes from the program's count, but they are only
+// visible inside the module.
+func NewModuleSymbolTable(program *SymbolTable) *SymbolTable {
+	for program.outer != nil {
+		program = program.outer
+	}
+	if program.program != nil {
+		program = program.program
+	}
+	table := &SymbolTable{store: make(map[string]Symbol), program: program}
+	for i, def := range object.Builtins {
+		table.DefineBuiltin(i, def.Name)
+	}
+	for name, sym := range program.store {
+		if sym.Scope == GLOBAL_SCOPE && sym.Index < program.numShared {
+			table.store[name] = sym
+		}
+	}
+	return table
+}
+
+// ShareGlobals makes the globals defined so far, such as the prelude's,
+// visible to the modules the program imports as well as to the program.
+func (st *SymbolTable) ShareGlobals() {
+	st.numShared = st.numDefinitions
+}
+
 func (st *SymbolTable) Define(name string) Symbol {
 	var scope SymbolScope
 	if st.outer == nil {
@@ -34,12 +73,24 @@ func (st *SymbolTable) Define(name string) Symbol {
 	} else {
 		scope = LOCAL_SCOPE
 	}
-	newSymbol := Symbol{name, st.numDefinitions, scope}
-	st.numDefinitions += 1
+	counter := st
+	if st.program != nil {
+		counter = st.program
+	}
+	newSymbol := Symbol{name, counter.numDefinitions, scope}
+	counter.numDefinitions += 1
 	st.store[name] = newSymbol
 	return newSymbol
 }
 
+// DefineBuiltin makes the builtin at index in object.Builtins available as
+// name. Builtins don't take up a slot among the table's definitions.
+func (st *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Index: index, Scope: BUILTIN_SCOPE}
+	st.store[name] = symbol
+	return symbol
+}
+
 func (st *SymbolTable) Resolve(name string) (Symbol, bool) {
 	sym, ok := st.store[name]
 	if !ok && st.outer != nil {
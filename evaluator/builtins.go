@@ -1,159 +1,114 @@
 package evaluator
 
 import (
-	"fmt"
+	"math/rand"
 
+	"interpego/module"
 	"interpego/object"
+	"interpego/token"
 )
 
-type Builtins map[string]*object.Builtin
+// Builtins are the builtin functions available to a program, along with the
+// streams they use for input and output and the modules the program imports.
+type Builtins struct {
+	byName map[string]object.Object
+	io     *object.IO
+	rand   *rand.Rand
+	clock  object.Clock
+	loader *module.Loader
+	// hash of the exports of each module imported so far, by file
+	modules map[string]object.Object
+}
 
+// NewBuiltins returns the builtins shared with the VM, using the process's
+// standard streams.
 func NewBuiltins() Builtins {
-	return Builtins{
-		"first": &object.Builtin{
-			Fn: func(args ...object.Object) object.Object {
-				if len(args) != 1 {
-					return newError("wrong number of arguments. got=%d, want=1", len(args))
-				}
-				if args[0].Type() != object.ARRAY_TYPE {
-					return newError("argument to `first` not supported, got=%s, expected=%s", args[0].Type(), object.ARRAY_TYPE)
-				}
+	builtins := Builtins{
+		byName:  map[string]object.Object{},
+		io:      object.DefaultIO(),
+		rand:    object.NewRand(),
+		clock:   object.SystemClock{},
+		loader:  module.NewLoader(nil),
+		modules: map[string]object.Object{},
+	}
+	for _, def := range object.Builtins {
+		builtins.byName[def.Name] = def.Value
+	}
+	return builtins
+}
 
-				arr := args[0].(*object.Array).Elements
-				if len(arr) == 0 {
-					return newError("array index out of bounds: size=%d, index=%d", len(arr), 0)
-				}
-				return arr[0]
-			},
-		},
-		"last": &object.Builtin{
-			Fn: func(args ...object.Object) object.Object {
-				if len(args) != 1 {
-					return newError("wrong number of arguments. got=%d, want=1", len(args))
-				}
-				if args[0].Type() != object.ARRAY_TYPE {
-					return newError("argument to `first` not supported, got=%s, expected=%s", args[0].Type(), object.ARRAY_TYPE)
-				}
+// SetIO sets the streams builtins like `print` and `readLine` use.
+func (b *Builtins) SetIO(io *object.IO) {
+	b.io = io
+}
+
+// SetRand sets the source of random numbers for builtins like `math.random`,
+// so that a host can make runs reproducible.
+func (b *Builtins) SetRand(rand *rand.Rand) {
+	b.rand = rand
+}
 
-				arr := args[0].(*object.Array).Elements
-				if len(arr) == 0 {
-					return newError("array index out of bounds: size=%d, index=%d", len(arr), 0)
-				}
-				return arr[len(arr)-1]
-			},
-		},
-		"rest": &object.Builtin{
-			Fn: func(args ...object.Object) object.Object {
-				if args[0].Type() != object.ARRAY_TYPE {
-					return newError("argument to `first` not supported, got=%s, expected=%s", args[0].Type(), object.ARRAY_TYPE)
-				}
+// SetClock sets the clock builtins like `time.now` read, so that a host can
+// make runs deterministic.
+func (b *Builtins) SetClock(clock object.Clock) {
+	b.clock = clock
+}
 
-				arr := args[0].(*object.Array).Elements
+// SetLoader sets the loader that finds imported modules, which determines the
+// directories searched for them.
+func (b *Builtins) SetLoader(loader *module.Loader) {
+	b.loader = loader
+}
 
-				if len(arr) > 0 {
-					newArr := make([]object.Object, len(arr)-1, len(arr)-1)
-					copy(newArr, arr[1:])
-					return &object.Array{Elements: newArr}
-				}
+// Define makes value available to programs as name, alongside the builtin
+// functions. Hosts use it to add functions of their own, such as the prelude's.
+func (b *Builtins) Define(name string, value object.Object) {
+	b.byName[name] = value
+}
 
-				return &object.Null{}
-			},
-		},
-		"push": &object.Builtin{
-			Fn: func(args ...object.Object) object.Object {
-				if len(args) != 2 {
-					return newError("wrong number of arguments. got=%d, want=2",
-						len(args))
-				}
+// runtime is the object.Runtime the evaluator gives builtins. callSite is
+// where the builtin was called, which is where the backtrace of an error in a
+// function it calls back into continues.
+type runtime struct {
+	builtins Builtins
+	callSite token.Position
+}
 
-				if args[0].Type() != object.ARRAY_TYPE {
-					return newError("argument to `push` must be ARRAY, got %s", args[0].Type())
-				}
+func (rt *runtime) Call(fn object.Object, args ...object.Object) object.Object {
+	switch fn := fn.(type) {
+	case *object.Function:
+		result, fn, args := applyFunction(rt.builtins, fn, args)
+		if err, ok := result.(*object.Error); ok {
+			pushStackFrame(err, fn, args, rt.callSite)
+		}
+		return result
+	case *object.Builtin:
+		return callBuiltin(rt.builtins, fn, args, rt.callSite)
+	default:
+		return newError("not a function: %s", fn.Type())
+	}
+}
 
-				arr := args[0].(*object.Array).Elements
-				length := len(arr)
-				newArr := make([]object.Object, length+1, length+1)
-				copy(newArr, arr)
-				newArr[length] = args[1]
-				return &object.Array{Elements: newArr}
-			},
-		},
-		"print": &object.Builtin{
-			Fn: func(args ...object.Object) object.Object {
-				if len(args) != 1 {
-					return newError("wrong number of arguments. got=%d, want=1", len(args))
-				}
-				fmt.Printf("%s\n", args[0].Inspect())
-				return &object.Null{}
-			},
-		},
-		"len": &object.Builtin{
-			Fn: func(args ...object.Object) object.Object {
-				if len(args) != 1 {
-					return newError("wrong number of arguments. got=%d, want=1", len(args))
-				}
-				switch arg := args[0].(type) {
-				case *object.String:
-					return &object.Integer{Value: int64(len(arg.Value))}
-				case *object.Array:
-					return &object.Integer{Value: int64(len(arg.Elements))}
-				default:
-					return newError("argument to `len` not supported, got %s", args[0].Type())
-				}
-			},
-		},
-		"map": {
-			Fn: func(args ...object.Object) object.Object {
-				if len(args) != 2 {
-					return newError("wrong number of arguments. got=%d, want=1", len(args))
-				}
-				fn, ok := args[0].(*object.Function)
-				if !ok {
-					return newError("expected first argument to `map` to be an object.Function. got=%T", args[0])
-				}
-				arr, ok := args[1].(*object.Array)
-				if !ok {
-					return newError("expected second argument to `map` to be an object.Array. got=%T", args[1])
-				}
-				newArr := make([]object.Object, len(arr.Elements))
-				for i, elem := range arr.Elements {
-					newElem := evalCallExpression(NewBuiltins(), fn, []object.Object{elem})
-					if isError(newElem) {
-						return newElem
-					}
+func (rt *runtime) IO() *object.IO {
+	return rt.builtins.io
+}
 
-					newArr[i] = newElem
-				}
+func (rt *runtime) Rand() *rand.Rand {
+	return rt.builtins.rand
+}
 
-				return &object.Array{Elements: newArr}
-			},
-		},
-		"reduce": {
-			Fn: func(args ...object.Object) object.Object {
-				if len(args) != 3 {
-					return newError("wrong number of arguments. got=%d, want=1", len(args))
-				}
-				fn, ok := args[0].(*object.Function)
-				if !ok {
-					return newError("expected first argument to `map` to be an object.Function. got=%T", args[0])
-				}
-				arr, ok := args[1].(*object.Array)
-				if !ok {
-					return newError("expected second argument to `map` to be an object.Array. got=%T", args[1])
-				}
-				if len(fn.Params) != 2 {
-					return newError("function provided to `reduce` must accept two params. got=%d", len(fn.Params))
-				}
+func (rt *runtime) Clock() object.Clock {
+	return rt.builtins.clock
+}
 
-				acc := args[2]
-				for _, elem := range arr.Elements {
-					acc = evalCallExpression(NewBuiltins(), fn, []object.Object{elem, acc})
-					if isError(acc) {
-						return acc
-					}
-				}
-				return acc
-			},
-		},
+func callBuiltin(builtins Builtins, fn *object.Builtin, args []object.Object, callSite token.Position) object.Object {
+	if result := fn.Fn(&runtime{builtins: builtins, callSite: callSite}, args...); result != nil {
+		// errors from functions the builtin called back into already have
+		// their position, and keep their kind
+		if err, ok := result.(*object.Error); ok && !err.Position.IsValid() {
+			err.Kind = object.BUILTIN_ERROR
+		}
+		return result
 	}
+	return NULL
 }
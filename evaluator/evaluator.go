@@ -4,16 +4,27 @@ import (
 	"fmt"
 
 	"interpego/ast"
+	"interpego/module"
 	"interpego/object"
+	"interpego/token"
 )
 
 var (
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
-	NULL  = &object.Null{}
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
+	NULL  = object.NULL
 )
 
 func Eval(builtins Builtins, node ast.Node, env *object.Environment) object.Object {
+	result := eval(builtins, node, env)
+	// the innermost node an error comes out of is where it was raised
+	if err, ok := result.(*object.Error); ok && !err.Position.IsValid() {
+		err.Position = node.Pos()
+	}
+	return result
+}
+
+func eval(builtins Builtins, node ast.Node, env *object.Environment) object.Object {
 	switch node := node.(type) {
 	case *ast.Program:
 		return evalProgram(builtins, env, node)
@@ -36,6 +47,18 @@ func Eval(builtins Builtins, node ast.Node, env *object.Environment) object.Obje
 			return obj
 		}
 		return &object.ReturnValue{Value: obj}
+	case *ast.ThrowStatement:
+		value := Eval(builtins, node.Value, env)
+		if isError(value) {
+			return value
+		}
+		return object.NewThrownError(value)
+	case *ast.TryExpression:
+		return evalTryExpression(builtins, env, node)
+	case *ast.DeferStatement:
+		return evalDeferStatement(builtins, env, node)
+	case *ast.MatchExpression:
+		return evalMatchExpression(builtins, env, node)
 	case *ast.PrefixExpression:
 		right := Eval(builtins, node.Right, env)
 		if isError(right) {
@@ -63,6 +86,7 @@ func Eval(builtins Builtins, node ast.Node, env *object.Environment) object.Obje
 			Env:    env,
 			Params: node.Parameters,
 			Body:   node.FunctionBody,
+			Name:   node.Name,
 		}
 	case *ast.CallExpression:
 		evaluatedArgs := evaluateCallArguments(builtins, env, node.Arguments)
@@ -76,16 +100,35 @@ func Eval(builtins Builtins, node ast.Node, env *object.Environment) object.Obje
 		}
 		switch fn := result.(type) {
 		case *object.Function:
-			return evalCallExpression(builtins, fn, evaluatedArgs)
+			if node.Tail {
+				// leave the call to the trampoline in applyFunction, so the
+				// Go stack doesn't grow with each call
+				return &tailCall{fn: fn, args: evaluatedArgs}
+			}
+			result, fn, args := applyFunction(builtins, fn, evaluatedArgs)
+			if err, ok := result.(*object.Error); ok {
+				pushStackFrame(err, fn, args, node.Pos())
+			}
+			return result
 		case *object.Builtin:
-			return fn.Fn(evaluatedArgs...)
+			return callBuiltin(builtins, fn, evaluatedArgs, node.Pos())
 		default:
 			return newError("not a function: %s", fn.Type())
 		}
 	case *ast.IntegerLiteral:
+		if node.Big != nil {
+			return &object.BigInt{Value: node.Big}
+		}
 		return &object.Integer{Value: node.Value}
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
+	case *ast.InterpolatedString:
+		values := evaluateCallArguments(builtins, env, node.Values)
+		if len(values) == 1 && isError(values[0]) {
+			return values[0]
+		}
+
+		return object.Interpolate(node.Strings, values)
 	case *ast.BooleanLiteral:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.ArrayLiteral:
@@ -96,9 +139,8 @@ func Eval(builtins Builtins, node ast.Node, env *object.Environment) object.Obje
 
 		return &object.Array{Elements: elements}
 	case *ast.HashLiteral:
-		pairs := node.Pairs
-		evaluatedPairs := make(map[object.HashKey]object.HashPair)
-		for keyNode := range pairs {
+		hash := object.NewHash()
+		for _, keyNode := range node.Keys {
 			evaluatedKey := Eval(builtins, keyNode, env)
 			if isError(evaluatedKey) {
 				return evaluatedKey
@@ -107,16 +149,13 @@ func Eval(builtins Builtins, node ast.Node, env *object.Environment) object.Obje
 			if !ok {
 				return newError("key type is not hashable: %s", evaluatedKey.Type())
 			}
-			evaluatedValue := Eval(builtins, pairs[keyNode], env)
-			if isError(evaluatedKey) {
-				return evaluatedKey
-			}
+			evaluatedValue := Eval(builtins, node.Pairs[keyNode], env)
 			if isError(evaluatedValue) {
 				return evaluatedValue
 			}
-			evaluatedPairs[hashable.HashKey()] = object.HashPair{Key: evaluatedKey, Value: evaluatedValue}
+			hash.Set(hashable, evaluatedValue)
 		}
-		return &object.Hash{Pairs: evaluatedPairs}
+		return hash
 	case *ast.IndexExpression:
 		idx := Eval(builtins, node.Index, env)
 		if isError(idx) {
@@ -129,11 +168,13 @@ func Eval(builtins Builtins, node ast.Node, env *object.Environment) object.Obje
 		}
 
 		return evalIndexExpression(arr, idx)
+	case *ast.ImportExpression:
+		return evalImportExpression(builtins, env, node.Path)
 	case *ast.Identifier:
 		if val, ok := env.Get(node.Value); ok {
 			return val
 		}
-		if builtin, ok := NewBuiltins()[node.Value]; ok {
+		if builtin, ok := builtins.byName[node.Value]; ok {
 			return builtin
 		}
 
@@ -142,10 +183,159 @@ func Eval(builtins Builtins, node ast.Node, env *object.Environment) object.Obje
 	return newError("default branch of eval. could not handle: %T", node)
 }
 
+// evalTryExpression runs the try block, then the catch block if the try block
+// raised an error, and then the finally block whatever happened. An error or
+// return in the finally block takes the place of the other blocks' result.
+func evalTryExpression(builtins Builtins, env *object.Environment, node *ast.TryExpression) object.Object {
+	result := Eval(builtins, node.Block, env)
+	if err, ok := result.(*object.Error); ok && node.Catch != nil {
+		// blocks don't introduce a scope, so the error is bound like a let
+		env.Set(node.CatchParam.Value, err.Caught())
+		result = Eval(builtins, node.Catch, env)
+	}
+	if node.Finally != nil {
+		final := Eval(builtins, node.Finally, env)
+		if final != nil && (final.Type() == object.ERROR_TYPE || final.Type() == object.RETURN_TYPE) {
+			return final
+		}
+	}
+	if result == nil {
+		return NULL
+	}
+	return result
+}
+
+// evalDeferStatement evaluates the function and arguments of a deferred call
+// and records the call in env, which is the environment of the function call
+// the statement is in, since blocks don't introduce one.
+func evalDeferStatement(builtins Builtins, env *object.Environment, node *ast.DeferStatement) object.Object {
+	args := evaluateCallArguments(builtins, env, node.Call.Arguments)
+	if len(args) == 1 && isError(args[0]) {
+		return args[0]
+	}
+	fn := Eval(builtins, node.Call.Function, env)
+	if isError(fn) {
+		return fn
+	}
+
+	rt := &runtime{builtins: builtins, callSite: node.Pos()}
+	env.Defer(func() object.Object {
+		result := rt.Call(fn, args...)
+		if err, ok := result.(*object.Error); ok && !err.Position.IsValid() {
+			err.Position = node.Pos()
+		}
+		return result
+	})
+	return NULL
+}
+
+// evalMatchExpression returns the result of the first arm whose pattern
+// matches the value and whose guard, if it has one, is truthy. Like a let, a
+// pattern binds names in env, since blocks don't introduce a scope.
+func evalMatchExpression(builtins Builtins, env *object.Environment, node *ast.MatchExpression) object.Object {
+	value := Eval(builtins, node.Value, env)
+	if isError(value) {
+		return value
+	}
+	for _, arm := range node.Arms {
+		if !matchPattern(builtins, env, arm.Pattern, value) {
+			continue
+		}
+		if arm.Guard != nil {
+			guard := Eval(builtins, arm.Guard, env)
+			if isError(guard) {
+				return guard
+			}
+			if !isTruthy(guard) {
+				continue
+			}
+		}
+		return Eval(builtins, arm.Result, env)
+	}
+	return newError("no match for value: %s", value.Inspect())
+}
+
+// matchPattern reports whether value matches pattern, binding the names in it
+// as it goes.
+func matchPattern(builtins Builtins, env *object.Environment, pattern ast.Pattern, value object.Object) bool {
+	switch pattern := pattern.(type) {
+	case *ast.WildcardPattern:
+		return true
+	case *ast.BindingPattern:
+		env.Set(pattern.Name.Value, value)
+		return true
+	case *ast.LiteralPattern:
+		return object.Equal(Eval(builtins, pattern.Value, env), value)
+	case *ast.ArrayPattern:
+		array, ok := value.(*object.Array)
+		n := len(pattern.Elements)
+		if !ok || len(array.Elements) < n || !pattern.HasRest && len(array.Elements) != n {
+			return false
+		}
+		for i, elem := range pattern.Elements {
+			if !matchPattern(builtins, env, elem, array.Elements[i]) {
+				return false
+			}
+		}
+		if pattern.Rest == nil {
+			return true
+		}
+		// as with the `rest` builtin, sharing the elements lets a function
+		// recurse over an array without copying it each time
+		rest := array.Elements[n:len(array.Elements):len(array.Elements)]
+		return matchPattern(builtins, env, pattern.Rest, &object.Array{Elements: rest})
+	case *ast.HashPattern:
+		hash, ok := value.(*object.Hash)
+		if !ok {
+			return false
+		}
+		for i, key := range pattern.Keys {
+			elem, ok := hash.Get(Eval(builtins, key, env).(object.Hashable))
+			if !ok || !matchPattern(builtins, env, pattern.Values[i], elem) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// evalImportExpression runs the module imported as path in an environment of
+// its own, the first time it is imported, and returns a hash of its exports.
+func evalImportExpression(builtins Builtins, env *object.Environment, path string) object.Object {
+	file, err := builtins.loader.Resolve(env.File(), path)
+	if err != nil {
+		return newError("import %q: %s", path, err)
+	}
+	if exports, ok := builtins.modules[file]; ok {
+		return exports
+	}
+	if err := builtins.loader.Enter(file); err != nil {
+		return newError("import %q: %s", path, err)
+	}
+	defer builtins.loader.Leave()
+	program, err := module.Parse(file)
+	if err != nil {
+		return newError("import %q: %s", path, err)
+	}
+
+	moduleEnv := object.NewFileEnvironment(file)
+	if result := Eval(builtins, program, moduleEnv); isError(result) {
+		return result
+	}
+	exports := object.NewHash()
+	for _, name := range module.Exports(program) {
+		value, _ := moduleEnv.Get(name)
+		exports.Set(&object.String{Value: name}, value)
+	}
+	builtins.modules[file] = exports
+	return exports
+}
+
 func evalInfixExpression(left object.Object, operator string, right object.Object) object.Object {
 	switch {
-	case left.Type() == object.INTEGER_TYPE && right.Type() == object.INTEGER_TYPE:
-		return evalIntegerInfixExpression(left.(*object.Integer), operator, right.(*object.Integer))
+	case object.IsNumber(left) && object.IsNumber(right):
+		return evalNumberInfixExpression(left, operator, right)
 	case left.Type() == object.STRING_TYPE && right.Type() == object.STRING_TYPE:
 		return evalStringInfixExpression(left.(*object.String), operator, right.(*object.String))
 	case left.Type() == object.ARRAY_TYPE && right.Type() == object.ARRAY_TYPE:
@@ -161,24 +351,24 @@ func evalInfixExpression(left object.Object, operator string, right object.Objec
 	}
 }
 
-func evalIntegerInfixExpression(left *object.Integer, operator string, right *object.Integer) object.Object {
+// evalNumberInfixExpression applies operator to two numbers, any of which may
+// be a BigInt or a Float.
+func evalNumberInfixExpression(left object.Object, operator string, right object.Object) object.Object {
 	switch operator {
-	case "+":
-		return &object.Integer{Value: left.Value + right.Value}
-	case "-":
-		return &object.Integer{Value: left.Value - right.Value}
-	case "*":
-		return &object.Integer{Value: left.Value * right.Value}
-	case "/":
-		return &object.Integer{Value: left.Value / right.Value}
+	case "+", "-", "*", "/":
+		result, err := object.Arithmetic(operator, left, right)
+		if err != nil {
+			return newError("%s", err)
+		}
+		return result
 	case "<":
-		return nativeBoolToBooleanObject(left.Value < right.Value)
+		return nativeBoolToBooleanObject(object.CompareNumbers(left, right) < 0)
 	case ">":
-		return nativeBoolToBooleanObject(left.Value > right.Value)
+		return nativeBoolToBooleanObject(object.CompareNumbers(left, right) > 0)
 	case "==":
-		return nativeBoolToBooleanObject(left.Value == right.Value)
+		return nativeBoolToBooleanObject(object.CompareNumbers(left, right) == 0)
 	case "!=":
-		return nativeBoolToBooleanObject(left.Value != right.Value)
+		return nativeBoolToBooleanObject(object.CompareNumbers(left, right) != 0)
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
@@ -263,10 +453,10 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 }
 
 func evalMinusOperatorExpression(exp object.Object) object.Object {
-	if exp.Type() != object.INTEGER_TYPE {
+	if !object.IsNumber(exp) {
 		return newError("unknown operator: -%s", exp.Type())
 	}
-	return &object.Integer{Value: -exp.(*object.Integer).Value}
+	return object.Negate(exp)
 }
 
 func evalBangOperatorExpression(right object.Object) object.Object {
@@ -296,12 +486,39 @@ func evalProgram(builtins Builtins, env *object.Environment, program *ast.Progra
 		case *object.ReturnValue:
 			return result.Value
 		case *object.Error:
-			return result
+			return finishBacktrace(result)
 		}
 	}
 	return result
 }
 
+// pushStackFrame records a call to fn made at callSite that err unwound out
+// of. While an error is unwinding, the last frame of its backtrace is a
+// placeholder for the caller, holding only the position the caller had reached
+// (the call site); it is filled in when the error unwinds out of the caller.
+func pushStackFrame(err *object.Error, fn *object.Function, args []object.Object, callSite token.Position) {
+	frame := object.NewStackFrame(fn.Name, args, err.Position)
+	if n := len(err.Backtrace); n > 0 {
+		frame.Position = err.Backtrace[n-1].Position
+		err.Backtrace[n-1] = frame
+	} else {
+		err.Backtrace = append(err.Backtrace, frame)
+	}
+	err.Backtrace = append(err.Backtrace, object.StackFrame{Position: callSite})
+}
+
+// finishBacktrace fills in the top-level frame once an error reaches the
+// program.
+func finishBacktrace(err *object.Error) *object.Error {
+	n := len(err.Backtrace)
+	if n == 0 {
+		err.Backtrace = append(err.Backtrace, object.StackFrame{Function: "main", Position: err.Position})
+	} else if err.Backtrace[n-1].Function == "" {
+		err.Backtrace[n-1].Function = "main"
+	}
+	return err
+}
+
 func evalBlockStatement(builtins Builtins, env *object.Environment, bs *ast.BlockStatement) object.Object {
 	var result object.Object
 	for _, stmt := range bs.Statements {
@@ -317,12 +534,18 @@ func evalBlockStatement(builtins Builtins, env *object.Environment, bs *ast.Bloc
 }
 
 func evalIfElseExpression(builtins Builtins, env *object.Environment, condition object.Object, consequence *ast.BlockStatement, alternative *ast.BlockStatement) object.Object {
+	var result object.Object
 	if isTruthy(condition) {
-		return Eval(builtins, consequence, env)
-	} else if alternative == nil {
+		result = Eval(builtins, consequence, env)
+	} else if alternative != nil {
+		result = Eval(builtins, alternative, env)
+	}
+	if result == nil {
+		// the branch taken was missing, empty or ended with a statement
+		// that has no value
 		return NULL
 	}
-	return Eval(builtins, alternative, env)
+	return result
 }
 
 func isTruthy(condition object.Object) bool {
@@ -362,16 +585,61 @@ func evaluateCallArguments(builtins Builtins, env *object.Environment, args []as
 }
 
 func evalCallExpression(builtins Builtins, function *object.Function, args []object.Object) object.Object {
-	if len(function.Params) != len(args) {
-		return newError(
-			"incorrect number of arguments passed to function: expected=%d, got=%d",
-			len(function.Params),
-			len(args),
-		)
+	result, _, _ := applyFunction(builtins, function, args)
+	return result
+}
+
+// tailCall is returned in place of the result of a call in tail position. It
+// never escapes applyFunction, which makes the call once the caller's body has
+// finished evaluating.
+type tailCall struct {
+	fn   *object.Function
+	args []object.Object
+}
+
+func (tc *tailCall) Type() object.ObjectType { return "TAIL_CALL" }
+func (tc *tailCall) Inspect() string         { return "tail call" }
+
+// applyFunction calls function with args, then keeps making the tail calls it
+// returns. It returns the final result along with the last function called
+// and its arguments, since that's the call an error happened in.
+func applyFunction(builtins Builtins, function *object.Function, args []object.Object) (object.Object, *object.Function, []object.Object) {
+	for {
+		if len(function.Params) != len(args) {
+			return newError(
+				"incorrect number of arguments passed to function: expected=%d, got=%d",
+				len(function.Params),
+				len(args),
+			), function, args
+		}
+		extended := extendFunctionEnvironment(function, args)
+		applied := unwrapReturnValue(Eval(builtins, function.Body, extended))
+		applied = runDeferred(extended, applied)
+
+		next, ok := applied.(*tailCall)
+		if !ok {
+			return applied, function, args
+		}
+		function, args = next.fn, next.args
+	}
+}
+
+// runDeferred makes the calls deferred in env, most recent first, once the
+// function call it is the environment of has produced result, and returns the
+// call's result. Like a finally block, a deferred call that fails replaces it
+// with its error; the calls after it are still made.
+func runDeferred(env *object.Environment, result object.Object) object.Object {
+	deferred := env.Deferred()
+	for i := len(deferred) - 1; i >= 0; i-- {
+		if err, ok := deferred[i]().(*object.Error); ok {
+			result = err
+		}
+	}
+	if result == nil {
+		// the body ended with a statement that has no value
+		return NULL
 	}
-	extended := extendFunctionEnvironment(function, args)
-	applied := Eval(builtins, function.Body, extended)
-	return unwrapReturnValue(applied)
+	return result
 }
 
 func unwrapReturnValue(obj object.Object) object.Object {
@@ -399,6 +667,15 @@ func evalIndexExpression(indexable object.Object, idxObj object.Object) object.O
 			return newError("array index out of bounds: size=%d, index=%d", len(arr), idx)
 		}
 		return arr[idx]
+	case indexable.Type() == object.STRING_TYPE && idxObj.Type() == object.INTEGER_TYPE:
+		str := indexable.(*object.String)
+		idx := idxObj.(*object.Integer).Value
+
+		char, ok := str.CharAt(idx)
+		if !ok {
+			return newError("string index out of bounds: size=%d, index=%d", str.Len(), idx)
+		}
+		return char
 	case indexable.Type() == object.HASH_TYPE:
 		hash := indexable.(*object.Hash).Pairs
 		hashKey, ok := idxObj.(object.Hashable)
@@ -1,9 +1,16 @@
 package evaluator
 
 import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"interpego/lexer"
+	"interpego/module"
 	"interpego/object"
 	"interpego/parser"
 )
@@ -103,6 +110,20 @@ func testEval(input string) object.Object {
 	return Eval(NewBuiltins(), program, env)
 }
 
+// testEvalError evaluates input, which must fail with the expected message,
+// and returns the error.
+func testEvalError(t *testing.T, input string, expected string) *object.Error {
+	t.Helper()
+	errObj, ok := testEval(input).(*object.Error)
+	if !ok {
+		t.Fatalf("object is not Error for %q", input)
+	}
+	if errObj.Message != expected {
+		t.Errorf("wrong error message for %q. expected=%q, got=%q", input, expected, errObj.Message)
+	}
+	return errObj
+}
+
 func testBooleanObject(t *testing.T, actual object.Object, expected bool) bool {
 	boolean, ok := actual.(*object.Boolean)
 	if !ok {
@@ -173,6 +194,7 @@ func TestIfElseExpressions(t *testing.T) {
 		{"if (1 > 2) { 10 }", nil},
 		{"if (1 > 2) { 10 } else { 20 }", 20},
 		{"if (1 < 2) { 10 } else { 20 }", 10},
+		{"if (true) { }", nil},
 		{`
 		if (10 > 1) {
 			if (10 > 1) {
@@ -214,6 +236,9 @@ func TestErrorHandling(t *testing.T) {
 		{"if (10 > 1) { true + false; }", "unknown operator: BOOLEAN + BOOLEAN"},
 		{"!1", "unknown operator: !INTEGER"},
 		{`"Hello" - "World"`, "unknown operator: STRING - STRING"},
+		{"1 / 0", "division by zero"},
+		{"let f = fn(x) { 10 / x }; f(0)", "division by zero"},
+		{"99999999999999999999 / 0", "division by zero"},
 		{
 			"foobar",
 			"unknown identifier: foobar",
@@ -233,6 +258,22 @@ func TestErrorHandling(t *testing.T) {
 	}
 }
 
+func TestEmptyBlockValues(t *testing.T) {
+	// a block with no value is null wherever its value is used
+	input := `println(if (true) { }, if (false) { 1 });
+json.stringify([if (true) { }, if (false) { 1 }])`
+	var stdout bytes.Buffer
+	builtins := NewBuiltins()
+	builtins.SetIO(object.NewIO(&stdout, &stdout, strings.NewReader("")))
+	evaluated := Eval(builtins, parser.New(lexer.New(input)).ParseProgram(), object.NewEnvironment())
+	testStringObject(t, evaluated, "[null,null]")
+	if stdout.String() != "null null\n" {
+		t.Errorf("wrong stdout. want=%q, got=%q", "null null\n", stdout.String())
+	}
+
+	testEvalError(t, `len(if (true) { })`, "argument to `len` not supported, got NULL")
+}
+
 func TestLetStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -312,6 +353,45 @@ func TestClosures(t *testing.T) {
 	testIntegerObject(t, testEval(input), 4)
 }
 
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{
+			// 2^17 elements, summed one call per element
+			`let double = fn(xs, n) { if (n == 0) { xs } else { double(xs + xs, n - 1) } };
+let sum = fn(xs, acc) {
+  if (len(xs) == 0) { return acc; }
+  sum(rest(xs), acc + first(xs))
+};
+sum(double([1], 17), 0)`,
+			131072,
+		},
+		{
+			`let isEven = fn(n) { if (n == 0) { true } else { isOdd(n - 1) } };
+let isOdd = fn(n) { if (n == 0) { false } else { isEven(n - 1) } };
+if (isEven(200000)) { 1 } else { 0 }`,
+			1,
+		},
+		{
+			// the rest of the array is shared rather than copied, and the
+			// call in the arm's result is a tail call
+			`let sum = fn(xs, acc) { match (xs) { [] => acc, [h, ...t] => sum(t, acc + h) } };
+sum(range(100000), 0)`,
+			4999950000,
+		},
+		{
+			// not a tail call, but its argument is evaluated as usual
+			"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(100)",
+			100,
+		},
+	}
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
 func TestBuiltinFunctions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -320,6 +400,7 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
+		{`len("日本語")`, 3},
 		{`len(1)`, "argument to `len` not supported, got INTEGER"},
 		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
 		{`len([1,2,3])`, 3},
@@ -345,6 +426,341 @@ func TestBuiltinFunctions(t *testing.T) {
 	}
 }
 
+func TestStringBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`join(split("a,b,,c", ","), "|")`, "a|b||c"},
+		{`len(split("héllo", ""))`, 5},
+		{`trim("  hi there \n")`, "hi there"},
+		{`contains("monkey", "key")`, true},
+		{`contains([1, "two", [3]], [3])`, true},
+		{`startsWith("monkey", "mon")`, true},
+		{`endsWith("monkey", "mon")`, false},
+		{`replace("a-b-c", "-", "+")`, "a+b+c"},
+		{`upper("héllo")`, "HÉLLO"},
+		{`lower("MONKEY")`, "monkey"},
+		{`indexOf("日本語です", "語")`, 2},
+		{`indexOf([true, false], false)`, 1},
+		{`substr("héllo", 1, 3)`, "éll"},
+		{`repeat("ab", 3)`, "ababab"},
+		{`chars("añb")[1]`, "ñ"},
+		{`format("%s has %d items: %v 100%%", "list", 3, [1, 2])`, "list has 3 items: [1, 2] 100%"},
+		{`sprintf("%q", "x")`, `"x"`},
+		{`if (contains("abc", "b")) { "yes" } else { "no" }`, "yes"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		switch expected := tt.expected.(type) {
+		case int:
+			testIntegerObject(t, evaluated, int64(expected))
+		case string:
+			testStringObject(t, evaluated, expected)
+		case bool:
+			testBooleanObject(t, evaluated, expected)
+		}
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{`split("a")`, "wrong number of arguments. got=1, want=2"},
+		{`upper(1)`, "argument 1 to `upper` must be STRING, got INTEGER"},
+		{`indexOf(true, 1)`, "argument 1 to `indexOf` must be STRING or ARRAY, got BOOLEAN"},
+		{`substr("abc", 1, 2, 3)`, "wrong number of arguments. got=4, want=2 or 3"},
+		{`format()`, "wrong number of arguments. got=0, want at least 1"},
+	}
+	for _, tt := range errorTests {
+		testEvalError(t, tt.input, tt.expected)
+	}
+}
+
+func TestCollectionBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`map(fn(x) { x * x }, [1, 2, 3])`, "[1, 4, 9]"},
+		{`map(len, ["a", "bc"])`, "[1, 2]"},
+		{`reduce(fn(x, acc) { acc + x }, [1, 2, 3], 10)`, "16"},
+		{`filter(fn(x) { x > 1 }, [1, 2, 3])`, "[2, 3]"},
+		{`find(fn(x) { x > 1 }, [1, 2, 3])`, "2"},
+		{`find(fn(x) { x > 5 }, [1, 2, 3])`, "null"},
+		{`any(fn(x) { x > 2 }, [1, 2, 3])`, "true"},
+		{`all(fn(x) { x > 2 }, [1, 2, 3])`, "false"},
+		{`compose(fn(x) { x + 1 }, fn(x) { x * 10 })(3)`, "31"},
+		{`compose(len)("abc")`, "3"},
+		{`map(compose(fn(x) { x * 2 }, len, trim), [" a ", "bc"])`, "[2, 4]"},
+		{`let add = fn(a, b) { a + b }; compose(fn(x) { -x }, add)(1, 2)`, "-3"},
+		{`compose(compose(fn(x) { x + 1 }, fn(x) { x + 1 }), fn(x) { x * 2 })(5)`, "12"},
+		{`all(fn(x) { x > 2 }, [])`, "true"},
+		{`sort([3, 1, 2])`, "[1, 2, 3]"},
+		{`sort(["b", "c", "a"])`, "[a, b, c]"},
+		{`sort([99999999999999999999, -1])`, "[-1, 99999999999999999999]"},
+		{`sort([[2, "x"], [1, "y"], [2, "z"]], fn(a, b) { a[0] < b[0] })`, "[[1, y], [2, x], [2, z]]"},
+		{`let xs = [2, 1]; sort(xs); xs`, "[2, 1]"},
+		{`reverse([1, 2, 3])`, "[3, 2, 1]"},
+		{`reverse("héllo")`, "olléh"},
+		{`slice([1, 2, 3, 4], 1, 3)`, "[2, 3]"},
+		{`slice([1, 2, 3], 1)`, "[2, 3]"},
+		{`range(4)`, "[0, 1, 2, 3]"},
+		{`range(2, 5)`, "[2, 3, 4]"},
+		{`range(10, 0, -4)`, "[10, 6, 2]"},
+		{`range(5, 2)`, "[]"},
+		{`zip([1, 2, 3], ["a", "b"])`, "[[1, a], [2, b]]"},
+		{`flatten([[1, [2]], 3, []])`, "[1, [2], 3]"},
+		{`keys({"b": 1, "a": 2, 3: 3})`, "[b, a, 3]"},
+		{`values({"b": 1, "a": 2})`, "[1, 2]"},
+		{`has({"a": 1}, "a")`, "true"},
+		{`has({"a": 1}, "b")`, "false"},
+		{`delete({"a": 1, "b": 2, "c": 3}, "b")`, "({a: 1, c: 3})"},
+		{`merge({"a": 1, "b": 2}, {"c": 3, "a": 4})`, "({a: 4, b: 2, c: 3})"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated == nil || evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%+v", tt.input, tt.expected, evaluated)
+		}
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{`map([1], fn(x) { x })`, "argument 1 to `map` must be FUNCTION, got ARRAY"},
+		{`reduce(fn(x, acc) { x }, [1])`, "wrong number of arguments. got=2, want=3"},
+		{`filter(fn(x) { x }, [1])`, "function passed to `filter` must return BOOLEAN, got INTEGER"},
+		{`sort([1, "a"])`, "`sort` without a comparator needs all INTEGER or all STRING elements, got INTEGER and STRING"},
+		{`slice([1, 2], 1, 3)`, "slice out of bounds: size=2, start=1, end=3"},
+		{`range(1, 2, 0)`, "argument 3 to `range` must not be zero"},
+		{`range(1000000000)`, "`range` result too long: arrays can have at most 67108864 elements"},
+		{`has({}, [1])`, "unusable as hash key: ARRAY"},
+		{`map(fn(x) { x + true }, [1])`, "type mismatch: INTEGER + BOOLEAN"},
+		{`compose()`, "wrong number of arguments. got=0, want at least 1"},
+		{`compose(len, 1)`, "argument 2 to `compose` must be FUNCTION, got INTEGER"},
+		{`compose(fn(x) { x + true }, len)("ab")`, "type mismatch: INTEGER + BOOLEAN"},
+	}
+	for _, tt := range errorTests {
+		testEvalError(t, tt.input, tt.expected)
+	}
+}
+
+func TestIOBuiltins(t *testing.T) {
+	input := `let name = readLine();
+println("hello", name, [1, 2]);
+eprint("warning:", 42);
+print(readLine());
+let last = readLine();
+println(readLine());
+println();
+last`
+
+	var stdout, stderr bytes.Buffer
+	builtins := NewBuiltins()
+	builtins.SetIO(object.NewIO(&stdout, &stderr, strings.NewReader("monkey\r\nsecond\nlast")))
+	evaluated := Eval(builtins, parser.New(lexer.New(input)).ParseProgram(), object.NewEnvironment())
+	testStringObject(t, evaluated, "last")
+
+	// readLine returns null at the end of the input
+	expectedStdout := "hello monkey [1, 2]\nsecond\nnull\n\n"
+	if stdout.String() != expectedStdout {
+		t.Errorf("wrong stdout. want=%q, got=%q", expectedStdout, stdout.String())
+	}
+	if stderr.String() != "warning: 42\n" {
+		t.Errorf("wrong stderr. want=%q, got=%q", "warning: 42\n", stderr.String())
+	}
+
+	testEvalError(t, `readLine(1)`, "wrong number of arguments. got=1, want=0")
+}
+
+func TestJSONBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`json.parse("[1, 2.5, \"x\", true, null]")`, "[1, 2.5, x, true, null]"},
+		{`let doc = json.parse("{\"b\": {\"c\": [1, 2]}, \"a\": 1}"); doc.b.c[1] + doc.a`, "3"},
+		{`keys(json.parse("{\"b\": 1, \"a\": 2, \"b\": 3}"))`, "[b, a]"},
+		{`json.parse("0.5") * 3`, "1.5"},
+		{`json.parse("1.5") > 1`, "true"},
+		{`-json.parse("2.0") == -2`, "true"},
+		{`json.stringify({"b": [1, "two"], "a": {}, "c": json.parse("null")})`, `{"b":[1,"two"],"a":{},"c":null}`},
+		{`json.stringify({"a": [1]}, 2)`, "{\n  \"a\": [\n    1\n  ]\n}"},
+		{`json.stringify([true], "\t")`, "[\n\ttrue\n]"},
+		{`json.stringify({1: 99999999999999999999})`, `{"1":99999999999999999999}`},
+		{`let s = "{\"k\":[1,2.5,{\"n\":null}]}"; json.stringify(json.parse(s)) == s`, "true"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated == nil || evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%+v", tt.input, tt.expected, evaluated)
+		}
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{`json.parse("{")`, "`json.parse` failed: unexpected end of JSON input"},
+		{`json.parse("[1,]")`, "`json.parse` failed: invalid character ',' looking for beginning of value"},
+		{`json.parse("1 2")`, "`json.parse` failed: unexpected data after top-level value"},
+		{`json.parse(1)`, "argument 1 to `json.parse` must be STRING, got INTEGER"},
+		{`json.stringify({"f": [len]})`, "`json.stringify` failed: BUILTIN at $[\"f\"][0] can't be converted to JSON"},
+		{`json.stringify({true: 1})`, "`json.stringify` failed: hash key true at $ must be STRING or INTEGER, got BOOLEAN"},
+		{`json.stringify(1, true)`, "argument 2 to `json.stringify` must be INTEGER or STRING, got BOOLEAN"},
+		{`json.parse("1.5") / 0`, "division by zero"},
+	}
+	for _, tt := range errorTests {
+		testEvalError(t, tt.input, tt.expected)
+	}
+}
+
+func TestMathBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`math.abs(-3)`, "3"},
+		{`math.abs(json.parse("-2.5"))`, "2.5"},
+		{`math.abs(-9223372036854775807 - 1)`, "9223372036854775808"},
+		{`math.min(3, 1, 2)`, "1"},
+		{`math.max(3, json.parse("3.5"))`, "3.5"},
+		{`math.clamp(15, 0, 10)`, "10"},
+		{`math.clamp(-1, 0, 10)`, "0"},
+		{`math.pow(2, 100)`, "1267650600228229401496703205376"},
+		{`math.pow(2, -1)`, "0.5"},
+		{`math.pow(json.parse("1.5"), 2)`, "2.25"},
+		{`math.sqrt(16)`, "4.0"},
+		{`math.floor(json.parse("-2.5"))`, "-3"},
+		{`math.ceil(json.parse("2.1"))`, "3"},
+		{`math.floor(7)`, "7"},
+		{`math.floor(math.pi * 100)`, "314"},
+		{`math.random() < 1`, "true"},
+		{`all(fn(x) { x > 1 == false }, map(fn(x) { math.randomInt(0, 2) }, range(20)))`, "true"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated == nil || evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%+v", tt.input, tt.expected, evaluated)
+		}
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{`math.abs("1")`, "argument 1 to `math.abs` must be NUMBER, got STRING"},
+		{`math.min()`, "wrong number of arguments. got=0, want at least 1"},
+		{`math.clamp(1, 10, 0)`, "`math.clamp` bounds are the wrong way round: 10 > 0"},
+		{`math.sqrt(-4)`, "argument 1 to `math.sqrt` must not be negative, got -4"},
+		{`math.pow(10, 1000000)`, "`math.pow` result too large: integers can have at most 1048576 bits here"},
+		{`math.randomInt(5, 5)`, "`math.randomInt` needs lo < hi, got 5 and 5"},
+	}
+	for _, tt := range errorTests {
+		testEvalError(t, tt.input, tt.expected)
+	}
+}
+
+func TestSeededRandom(t *testing.T) {
+	input := `[math.random(), math.randomInt(-100, 100), math.randomInt(0, 99999999999)]`
+	run := func(setup func(*Builtins)) string {
+		builtins := NewBuiltins()
+		setup(&builtins)
+		return Eval(builtins, parser.New(lexer.New(input)).ParseProgram(), object.NewEnvironment()).Inspect()
+	}
+
+	// the same seed, given by the host or by the program, gives the same numbers
+	first := run(func(b *Builtins) { b.SetRand(rand.New(rand.NewSource(7))) })
+	second := run(func(b *Builtins) { b.SetRand(rand.New(rand.NewSource(7))) })
+	if first != second {
+		t.Errorf("same seed gave different numbers: %s and %s", first, second)
+	}
+	seeded := testEval(`math.seed(7); ` + input)
+	if seeded.Inspect() != first {
+		t.Errorf("math.seed gave different numbers: %s and %s", first, seeded.Inspect())
+	}
+}
+
+func TestTimeBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`time.now()`, "2024-02-28T09:30:00Z"},
+		{`time.format(time.now(), "Mon Jan 2 15:04")`, "Wed Feb 28 09:30"},
+		{`time.format(time.add(time.now(), "36h"), time.date)`, "2024-02-29"},
+		{`time.add(time.now(), -1500)`, "2024-02-28T09:29:58.5Z"},
+		{`time.diff(time.now(), time.parse(time.date, "2024-02-28"))`, "34200000"},
+		{`time.diff(time.parse(time.date, "2024-01-01"), time.now()) < 0`, "true"},
+		{`time.parse(time.datetime, "2024-02-28T10:30:00+01:00") == time.now()`, "false"},
+		{`contains([time.parse(time.datetime, "2024-02-28T10:30:00+01:00")], time.now())`, "true"},
+		{`time.format(time.parse("02/01/2006", "29/02/2024"))`, "2024-02-29T00:00:00Z"},
+	}
+	for _, tt := range tests {
+		builtins := NewBuiltins()
+		builtins.SetClock(object.FixedClock(time.Date(2024, 2, 28, 9, 30, 0, 0, time.UTC)))
+		evaluated := Eval(builtins, parser.New(lexer.New(tt.input)).ParseProgram(), object.NewEnvironment())
+		if evaluated == nil || evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%+v", tt.input, tt.expected, evaluated)
+		}
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{`time.parse(time.date, "2024-13-01")`, "`time.parse` failed: parsing time \"2024-13-01\": month out of range"},
+		{`time.add(time.now(), "soon")`, "argument 2 to `time.add` is not a duration: time: invalid duration \"soon\""},
+		{`time.add(time.now(), true)`, "argument 2 to `time.add` must be INTEGER or STRING, got BOOLEAN"},
+		{`time.format("2024")`, "argument 1 to `time.format` must be TIME, got STRING"},
+		{`time.now(1)`, "wrong number of arguments. got=1, want=0"},
+	}
+	for _, tt := range errorTests {
+		testEvalError(t, tt.input, tt.expected)
+	}
+}
+
+func TestRegexBuiltins(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"regex.match(`^\\d+$`, \"123\")", "true"},
+		{"regex.match(`^\\d+$`, \"12a\")", "false"},
+		{"regex.find(`\\d+`, \"ab 42 7\")", "42"},
+		{"regex.find(`(\\w+)@(\\w+)?`, \"mail bob@ now\")", "[bob@, bob, null]"},
+		{"regex.find(`x`, \"abc\")", "null"},
+		{"regex.findAll(`\\d+`, \"a1b22c333\")", "[1, 22, 333]"},
+		{"regex.findAll(`(\\w)=(\\d)`, \"a=1 b=2\")", "[[a=1, a, 1], [b=2, b, 2]]"},
+		{"regex.findAll(`x`, \"abc\")", "[]"},
+		{"regex.replace(`(\\w+)@(\\w+)`, \"bob@home, al@work\", \"$2:$1\")", "home:bob, work:al"},
+		{"regex.replace(`(?P<first>\\w+) (?P<last>\\w+)`, \"Ada Lovelace\", `${last}, ${first}`)", "Lovelace, Ada"},
+		{"regex.split(`\\s*,\\s*`, \"a , b,c\")", "[a, b, c]"},
+		{"regex.split(``, \"héllo\")", "[h, é, l, l, o]"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated == nil || evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%+v", tt.input, tt.expected, evaluated)
+		}
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{`regex.match("(", "x")`, "`regex.match` failed: error parsing regexp: missing closing ): `(`"},
+		{`regex.findAll("a{2,1}", "x")`, "`regex.findAll` failed: error parsing regexp: invalid repeat count: `{2,1}`"},
+		{`regex.replace("a", "b")`, "wrong number of arguments. got=2, want=3"},
+		{`regex.split("a", 1)`, "argument 2 to `regex.split` must be STRING, got INTEGER"},
+	}
+	for _, tt := range errorTests {
+		testEvalError(t, tt.input, tt.expected)
+	}
+}
+
 func TestArrayIndexExpressions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -392,6 +808,88 @@ func TestArrayIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestBigIntegers(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775807 + 1", "9223372036854775808"},
+		{"-9223372036854775807 - 2", "-9223372036854775809"},
+		{"let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact(25)", "15511210043330985984000000"},
+		{"100000000000000000000 / 10", "10000000000000000000"},
+		{"-(-9223372036854775807 - 1)", "9223372036854775808"},
+		{`"${9223372036854775807 * 3}"`, "27670116110564327421"},
+	}
+	for _, tt := range tests {
+		result := testEval(tt.input)
+		if result.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%s (%T)", tt.input, tt.expected, result.Inspect(), result)
+		}
+	}
+
+	// values that fit in 64 bits again are plain integers
+	testIntegerObject(t, testEval("(9223372036854775807 + 10) - 20"), 9223372036854775797)
+
+	boolTests := []struct {
+		input    string
+		expected bool
+	}{
+		{"9223372036854775807 + 1 > 9223372036854775807", true},
+		{"-99999999999999999999 < 1", true},
+		{"99999999999999999999 == 99999999999999999990 + 9", true},
+		{"99999999999999999999 != 99999999999999999999", false},
+		{`{99999999999999999999: "big"}[99999999999999999990 + 9] == "big"`, true},
+	}
+	for _, tt := range boolTests {
+		testBooleanObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestInterpolatedStrings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"1 + 2 = ${1 + 2}"`, "1 + 2 = 3"},
+		{`let name = "monkey"; "hello ${name}!"`, "hello monkey!"},
+		{`let items = [1, 2]; "${len(items)} items: ${items}"`, "2 items: [1, 2]"},
+		{`let f = fn(x) { "<${x}>" }; f(true) + f("s")`, "<true><s>"},
+		{`"${"nested ${1 < 2}"}"`, "nested true"},
+		{`"${ {"a": 1}["a"] } {}"`, "1 {}"},
+	}
+	for _, tt := range tests {
+		testStringObject(t, testEval(tt.input), tt.expected)
+	}
+
+	testEvalError(t, `"a ${-true} b"`, "unknown operator: -BOOLEAN")
+}
+
+func TestStringIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"monkey"[0]`, "m"},
+		{`let s = "monkey"; s[len(s) - 1]`, "y"},
+		{`"héllo"[1]`, "é"},
+		{`"日本語"[2]`, "語"},
+	}
+	for _, tt := range tests {
+		testStringObject(t, testEval(tt.input), tt.expected)
+	}
+
+	errorTests := []struct {
+		input    string
+		expected string
+	}{
+		{`"日本語"[3]`, "string index out of bounds: size=3, index=3"},
+		{`"abc"[-1]`, "string index out of bounds: size=3, index=-1"},
+	}
+	for _, tt := range errorTests {
+		testEvalError(t, tt.input, tt.expected)
+	}
+}
+
 func TestHashIndexExpressions(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -468,3 +966,268 @@ for (let i = 1; i < 5; let i = i + 1) { i; }
 	}
 	testIntegerObject(t, result, 4)
 }
+
+func TestErrorBacktraces(t *testing.T) {
+	tests := []struct {
+		input             string
+		expectedPosition  string
+		expectedBacktrace string
+	}{
+		{
+			"1 + true",
+			"1:3",
+			"main()\n\t1:3\n",
+		},
+		{
+			`let inner = fn(a) {
+  a + true
+};
+let outer = fn() {
+  inner(1) * 2
+};
+outer();`,
+			"2:5",
+			"inner(1)\n\t2:5\nouter()\n\t5:8\nmain()\n\t7:6\n",
+		},
+		{
+			// a tail call replaces its caller's frame
+			`let inner = fn(a) {
+  a + true
+};
+let outer = fn() {
+  inner(1)
+};
+outer();`,
+			"2:5",
+			"inner(1)\n\t2:5\nmain()\n\t7:6\n",
+		},
+		{
+			`let f = fn(xs) { fn() { missing }() * 2 };
+f([1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15])`,
+			"1:25",
+			"<anonymous>()\n\t1:25\nf([1, 2, 3, 4, 5, 6, 7, 8, 9, 10, ...)\n\t1:34\nmain()\n\t2:2\n",
+		},
+		{
+			// an error in a callback keeps its own position
+			`let check = fn(x) {
+  x + true
+};
+map(fn(x) { check(x) * 2 }, [1]);`,
+			"2:5",
+			"check(1)\n\t2:5\n<anonymous>(1)\n\t4:18\nmain()\n\t4:4\n",
+		},
+	}
+	for i, tt := range tests {
+		result := testEval(tt.input)
+		errorObj, ok := result.(*object.Error)
+		if !ok {
+			t.Fatalf("tests[%d]: result is not an object.Error. got=%T (%+v)", i, result, result)
+		}
+		if errorObj.Position.String() != tt.expectedPosition {
+			t.Errorf("tests[%d]: wrong position. want=%s, got=%s", i, tt.expectedPosition, errorObj.Position)
+		}
+		if errorObj.Backtrace.String() != tt.expectedBacktrace {
+			t.Errorf("tests[%d]: wrong backtrace.\nwant=%q\ngot =%q", i, tt.expectedBacktrace, errorObj.Backtrace.String())
+		}
+	}
+}
+
+// writeModules writes each module's source to its path under a new temporary
+// directory, which it returns.
+func writeModules(t *testing.T, modules map[string]string) string {
+	dir := t.TempDir()
+	for path, source := range modules {
+		file := filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestImports(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"lib/util.monkey":     `let helper = import "helper"; let _count = helper.base + 1; let inc = fn() { _count + 1 }; println("loading util"); let name = "util";`,
+		"lib/helper.monkey":   `let base = 40;`,
+		"shared/twice.monkey": `let twice = fn(x) { x * 2 };`,
+		"a.monkey":            `let b = import "b";`,
+		"b.monkey":            `let a = import "a";`,
+		"value.monkey":        `let x = 42;`,
+	})
+	input := `let u = import "lib/util";
+let again = import "lib/util.monkey";
+let s = import "twice";
+"${u.inc()} ${u.name} ${again.name} ${s.twice(4)} ${u._count}"`
+
+	var stdout bytes.Buffer
+	builtins := NewBuiltins()
+	builtins.SetIO(object.NewIO(&stdout, &stdout, strings.NewReader("")))
+	builtins.SetLoader(module.NewLoader([]string{filepath.Join(dir, "shared")}))
+	env := object.NewFileEnvironment(filepath.Join(dir, "main.monkey"))
+	evaluated := Eval(builtins, parser.New(lexer.New(input)).ParseProgram(), env)
+	testStringObject(t, evaluated, "42 util util 8 null")
+	// a module only runs the first time it is imported
+	if stdout.String() != "loading util\n" {
+		t.Errorf("wrong stdout. want=%q, got=%q", "loading util\n", stdout.String())
+	}
+
+	// a module first imported inside a function leaves the program's globals alone
+	input = `let id = fn(x) { x }; let f = fn() { let m = import "value"; m.x }; [id(5), f(), id(5)]`
+	env = object.NewFileEnvironment(filepath.Join(dir, "main.monkey"))
+	evaluated = Eval(builtins, parser.New(lexer.New(input)).ParseProgram(), env)
+	if evaluated.Inspect() != "[5, 42, 5]" {
+		t.Errorf("wrong result. want=%q, got=%q", "[5, 42, 5]", evaluated.Inspect())
+	}
+
+	errors := []struct {
+		input    string
+		expected string
+	}{
+		{`import "a"`, `import "a": import cycle: a.monkey -> b.monkey -> a.monkey`},
+		{`import "missing"`, `import "missing": module "missing.monkey" not found`},
+	}
+	for _, tt := range errors {
+		env := object.NewFileEnvironment(filepath.Join(dir, "main.monkey"))
+		errObj, ok := Eval(NewBuiltins(), parser.New(lexer.New(tt.input)).ParseProgram(), env).(*object.Error)
+		if !ok || errObj.Message != tt.expected {
+			t.Errorf("wrong result for %s. want=%q, got=%+v", tt.input, tt.expected, errObj)
+		}
+	}
+}
+
+func TestExceptions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`try { 1 } catch (e) { 2 }`, "1"},
+		{`try { throw "boom"; 1 } catch (e) { "caught " + e }`, "caught boom"},
+		{`1 + try { throw 1 } catch (e) { e + 1 }`, "3"},
+		{`let e = try { [1][5] } catch (e) { e }; [e.message, e.kind, e.line, e.column]`, "[array index out of bounds: size=1, index=5, runtime, 1, 18]"},
+		{`try { json.parse("[") } catch (e) { e.kind }`, "builtin"},
+		{`try { throw {"kind": "mine"} } catch (e) { e.kind }`, "mine"},
+		{`try { map(fn(x) { throw x * 2 }, [7]) } catch (e) { e }`, "14"},
+		{`try { try { throw "a" } catch (e) { throw e + "b" } } catch (e) { e }`, "ab"},
+		{`let f = fn() { try { return 1 } finally { return 2 } }; f()`, "2"},
+		{`let f = fn() { try { 1 } finally { [][0] } }; try { f() } catch (e) { e.message }`, "array index out of bounds: size=0, index=0"},
+		{`try { 1 } finally { 2 }`, "1"},
+		{`let n = 0; let r = try { n } catch (e) { 0 } finally { let n = 5; }; [r, n]`, "[0, 5]"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated == nil || evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%+v", tt.input, tt.expected, evaluated)
+		}
+	}
+
+	// finally blocks run on every way out, innermost first
+	input := `let f = fn(x) {
+  try {
+    try { if (x) { return "early" } throw "late" } finally { println("inner") }
+  } catch (e) { e } finally { println("outer") }
+};
+[f(true), f(false)]`
+	var stdout bytes.Buffer
+	builtins := NewBuiltins()
+	builtins.SetIO(object.NewIO(&stdout, &stdout, strings.NewReader("")))
+	evaluated := Eval(builtins, parser.New(lexer.New(input)).ParseProgram(), object.NewEnvironment())
+	if evaluated.Inspect() != "[early, late]" {
+		t.Errorf("wrong result. want=%s, got=%s", "[early, late]", evaluated.Inspect())
+	}
+	if stdout.String() != "inner\nouter\ninner\nouter\n" {
+		t.Errorf("wrong stdout. got=%q", stdout.String())
+	}
+
+	// uncaught errors keep the position they were first raised at
+	errorTests := []struct {
+		input    string
+		expected string
+		position string
+	}{
+		{`throw {"message": "bad thing"}`, "bad thing", "1:1"},
+		{"let f = fn() { try { throw 1 } finally { 2 } };\nf()", "1", "1:22"},
+		{`try { throw "a" } catch (e) { [][0] }`, "array index out of bounds: size=0, index=0", "1:33"},
+	}
+	for _, tt := range errorTests {
+		errObj := testEvalError(t, tt.input, tt.expected)
+		if errObj.Position.String() != tt.position {
+			t.Errorf("wrong position for %q. want=%s, got=%s", tt.input, tt.position, errObj.Position)
+		}
+	}
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`match (2) { 1 => "one", 2 => "two", _ => "many" }`, "two"},
+		{`match (7) { 1 => "one", _ => "many" }`, "many"},
+		{`match (-3) { -3 => "minus three", n => n }`, "minus three"},
+		{`match ("b") { "a" => 1, s => s + s }`, "bb"},
+		{`match (1 < 2) { true => "yes", false => "no" }`, "yes"},
+		{`match ([1, 2, 3]) { [] => 0, [h, ...t] => h + len(t) }`, "3"},
+		{`match ([1, 2]) { [a] => a, [a, b, c] => c, [a, b] => a + b }`, "3"},
+		{`[match ([1]) { [_, ...] => "some", [] => "none" }, match ([]) { [_, ...] => "some", [] => "none" }]`, "[some, none]"},
+		{`match ({"type": "circle", "r": 2}) { {"type": "square"} => 0, {"type": "circle", "r": r} => r * r }`, "4"},
+		{`match ({"a": [1, 2]}) { {"a": [x, y]} => x + y }`, "3"},
+		{`match ([1, [2]]) { [1, [y]] => y }`, "2"},
+		{`match ("x") { [a] => 1, {"a": b} => 2, _ => 3 }`, "3"},
+		{`let f = fn(n) { match (n) { x if x > 10 => "big", x if x > 0 => "small", _ => "none" } }; [f(20), f(5), f(0)]`, "[big, small, none]"},
+		{`let f = fn(xs) { match (xs) { [h, ...t] => h + f(t), [] => 0 } }; f([1, 2, 3])`, "6"},
+		{`let r = match ([4, 5]) { [a, b] => a }; [r, b]`, "[4, 5]"},
+		{`match (1) { 1 => 2 } + match (2) { 2 => 3 }`, "5"},
+		{`try { match (3) { 4 => 0 } } catch (e) { e.message }`, "no match for value: 3"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if evaluated == nil || evaluated.Inspect() != tt.expected {
+			t.Errorf("wrong result for %q. want=%s, got=%+v", tt.input, tt.expected, evaluated)
+		}
+	}
+
+	errObj := testEvalError(t, "let x = 1;\nlet y = match ([x]) { [2] => 0 };", "no match for value: [1]")
+	if errObj.Position.String() != "2:9" {
+		t.Errorf("wrong position. want=2:9, got=%s", errObj.Position)
+	}
+}
+
+func TestDeferStatements(t *testing.T) {
+	input := `let f = fn(x) {
+  defer println("first", x);
+  defer println("second", x);
+  if (x) { return "early" }
+  "normal"
+};
+let fails = fn() { defer println("cleanup"); [][0] };
+let cleanupFails = fn() { defer fn() { throw "in defer" }(); defer println("still runs"); 1 };
+let noValue = fn() { defer println("last"); };
+[f(true), f(false), try { fails() } catch (e) { e.message }, try { cleanupFails() } catch (e) { e }, noValue()]`
+	var stdout bytes.Buffer
+	builtins := NewBuiltins()
+	builtins.SetIO(object.NewIO(&stdout, &stdout, strings.NewReader("")))
+	evaluated := Eval(builtins, parser.New(lexer.New(input)).ParseProgram(), object.NewEnvironment())
+	expected := "[early, normal, array index out of bounds: size=0, index=0, in defer, null]"
+	if evaluated.Inspect() != expected {
+		t.Errorf("wrong result. want=%s, got=%s", expected, evaluated.Inspect())
+	}
+	// deferred calls run last-in-first-out, with the arguments they were
+	// deferred with
+	expectedStdout := "second true\nfirst true\nsecond false\nfirst false\ncleanup\nstill runs\nlast\n"
+	if stdout.String() != expectedStdout {
+		t.Errorf("wrong stdout. want=%q, got=%q", expectedStdout, stdout.String())
+	}
+
+	// a failing deferred call is reported at its defer statement
+	errObj := testEvalError(t, "let f = fn() {\n  defer json.parse(\"[\");\n  1\n};\nf()", "`json.parse` failed: unexpected end of JSON input")
+	if errObj.Position.String() != "2:3" || errObj.Kind != object.BUILTIN_ERROR {
+		t.Fatalf("wrong error. got=%+v", errObj)
+	}
+	expectedBacktrace := "f()\n\t2:3\nmain()\n\t5:2\n"
+	if errObj.Backtrace.String() != expectedBacktrace {
+		t.Errorf("wrong backtrace.\nwant=%q\ngot=%q", expectedBacktrace, errObj.Backtrace.String())
+	}
+}
@@ -1,38 +1,88 @@
 package lexer
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
 	"interpego/token"
 )
 
 type Lexer struct {
 	input   string
-	curpos  int  // index of the char we are currently reading
-	ch      byte // char we are currently reading
-	nextpos int  // index of the next char to read
+	curpos  int  // byte offset of the char we are currently reading
+	ch      rune // char we are currently reading
+	nextpos int  // byte offset of the next char to read
+	line    int  // line of the char we are currently reading
+	column  int  // column of the char we are currently reading, counted in runes
+
+	errors []Error
+	// the ${ ... } interpolations we are currently inside of, innermost last
+	interpolations []interpolation
+}
+
+type interpolation struct {
+	start  token.Position // where the enclosing string starts
+	braces int            // number of { opened inside the interpolation and not yet closed
+}
+
+// Error is a problem the lexer found in the input, such as a string literal
+// that is never closed. The lexer keeps going after an error so the parser can
+// report everything it finds in one pass.
+type Error struct {
+	Position token.Position
+	Message  string
+}
+
+func (e Error) String() string {
+	return fmt.Sprintf("%s: %s", e.Position, e.Message)
+}
+
+// Errors returns the errors found in the input read so far.
+func (l *Lexer) Errors() []Error {
+	return l.errors
+}
+
+func (l *Lexer) errorf(pos token.Position, format string, a ...interface{}) {
+	l.errors = append(l.errors, Error{Position: pos, Message: fmt.Sprintf(format, a...)})
+}
+
+func (l *Lexer) position() token.Position {
+	return token.Position{Line: l.line, Column: l.column}
 }
 
-// gracefully handles reading end of input
+// gracefully handles reading end of input. Invalid UTF-8 is read one byte at a
+// time, each as utf8.RuneError.
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line += 1
+		l.column = 1
+	} else {
+		l.column += 1
+	}
+	width := 0
 	if l.nextpos >= len(l.input) {
 		// we define 0 to be an "EOF" char
 		l.ch = 0
 	} else {
-		l.ch = l.input[l.nextpos]
+		l.ch, width = utf8.DecodeRuneInString(l.input[l.nextpos:])
 	}
 	l.curpos = l.nextpos
-	l.nextpos += 1
+	l.nextpos += width
 }
 
-func (l *Lexer) peekChar() byte {
+func (l *Lexer) peekChar() rune {
 	if l.nextpos >= len(l.input) {
 		return 0
-	} else {
-		return l.input[l.nextpos]
 	}
+	ch, _ := utf8.DecodeRuneInString(l.input[l.nextpos:])
+	return ch
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1}
 	l.readChar()
 	return l
 }
@@ -40,6 +90,7 @@ func New(input string) *Lexer {
 func (l *Lexer) NextToken() token.Token {
 	var tok token.Token
 	l.skipWhitespace()
+	pos := l.position()
 	switch l.ch {
 	case '=':
 		if l.peekChar() == '=' {
@@ -48,6 +99,9 @@ func (l *Lexer) NextToken() token.Token {
 			nextchar := l.ch
 			lit := string(curchar) + string(nextchar)
 			tok = token.Token{Type: token.EQ, Literal: lit}
+		} else if l.peekChar() == '>' {
+			l.readChar()
+			tok = token.Token{Type: token.ARROW, Literal: "=>"}
 		} else {
 			tok = token.Token{Type: token.ASSIGN, Literal: string(l.ch)}
 		}
@@ -62,8 +116,22 @@ func (l *Lexer) NextToken() token.Token {
 	case ')':
 		tok = token.Token{Type: token.RPAREN, Literal: string(l.ch)}
 	case '{':
+		if n := len(l.interpolations); n > 0 {
+			l.interpolations[n-1].braces++
+		}
 		tok = token.Token{Type: token.LBRACE, Literal: string(l.ch)}
 	case '}':
+		n := len(l.interpolations)
+		if n > 0 && l.interpolations[n-1].braces == 0 {
+			// closes the interpolation, so the string carries on
+			start := l.interpolations[n-1].start
+			l.interpolations = l.interpolations[:n-1]
+			tok = l.readStringPart(start, token.INTERP_MID, token.INTERP_END)
+			break
+		}
+		if n > 0 {
+			l.interpolations[n-1].braces--
+		}
 		tok = token.Token{Type: token.RBRACE, Literal: string(l.ch)}
 	case '[':
 		tok = token.Token{Type: token.LBRACKET, Literal: string(l.ch)}
@@ -71,6 +139,14 @@ func (l *Lexer) NextToken() token.Token {
 		tok = token.Token{Type: token.RBRACKET, Literal: string(l.ch)}
 	case ',':
 		tok = token.Token{Type: token.COMMA, Literal: string(l.ch)}
+	case '.':
+		if strings.HasPrefix(l.input[l.curpos:], "...") {
+			l.readChar()
+			l.readChar()
+			tok = token.Token{Type: token.ELLIPSIS, Literal: "..."}
+		} else {
+			tok = token.Token{Type: token.DOT, Literal: string(l.ch)}
+		}
 	case '-':
 		tok = token.Token{Type: token.MINUS, Literal: string(l.ch)}
 	case '!':
@@ -92,8 +168,10 @@ func (l *Lexer) NextToken() token.Token {
 	case '>':
 		tok = token.Token{Type: token.GT, Literal: string(l.ch)}
 	case '"':
+		tok = l.readStringPart(pos, token.INTERP_START, token.STRING)
+	case '`':
 		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		tok.Literal = l.readRawString(pos)
 	case 0:
 		tok = token.Token{Type: token.EOF, Literal: ""}
 	default:
@@ -102,40 +180,171 @@ func (l *Lexer) NextToken() token.Token {
 
 			tok.Literal = ident
 			tok.Type = token.LookupIdent(tok.Literal)
+			tok.Position = pos
 			return tok
 		} else if isDigit(l.ch) {
 			tok.Type = token.INT
 			tok.Literal = l.readNumber()
+			tok.Position = pos
 			return tok
 		} else {
-			tok = token.Token{Type: token.ILLEGAL, Literal: ""}
+			tok = token.Token{Type: token.ILLEGAL, Literal: string(l.ch)}
 		}
 	}
 	l.readChar()
+	tok.Position = pos
 	return tok
 }
 
-func isDigit(ch byte) bool {
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
+
+// readNumber reads an integer literal: decimal digits, or hex, octal or binary
+// digits after a 0x, 0o or 0b prefix, any of them with _ separators. Digits
+// that don't belong to the base are read too and left for the parser to
+// reject.
 func (l *Lexer) readNumber() string {
 	startpos := l.curpos
-	for isDigit(l.ch) {
+	if l.ch == '0' && strings.ContainsRune("xXoObB", l.peekChar()) {
+		l.readChar()
+		l.readChar()
+	}
+	for isHexDigit(l.ch) || l.ch == '_' {
 		l.readChar()
 	}
 
 	return l.input[startpos:l.curpos]
 }
 
-func (l *Lexer) readString() string {
+// readStringPart reads the text of a double quoted string from the char
+// under the cursor, which is the opening quote or the } closing an
+// interpolation, up to the closing quote or the next ${. start is where the
+// string began. The part is returned as a token of type interp when it ends
+// at a ${, and of type end otherwise.
+//
+// Escape sequences are decoded as the text is read. \xNN adds a single byte,
+// so a few of them can spell out a UTF-8 sequence; bytes that don't end up
+// forming valid UTF-8 are replaced with utf8.RuneError.
+func (l *Lexer) readStringPart(start token.Position, interp, end token.TokenType) token.Token {
+	var out strings.Builder
+	typ := end
+loop:
+	for {
+		l.readChar()
+		switch l.ch {
+		case '"':
+			break loop
+		case '$':
+			if l.peekChar() == '{' {
+				l.readChar()
+				l.interpolations = append(l.interpolations, interpolation{start: start})
+				typ = interp
+				break loop
+			}
+			out.WriteRune(l.ch)
+		case 0:
+			if l.curpos >= len(l.input) {
+				l.errorf(start, "unterminated string literal")
+				break loop
+			}
+			out.WriteRune(l.ch)
+		case '\\':
+			l.readEscape(&out)
+		default:
+			out.WriteRune(l.ch)
+		}
+	}
+	// string values are always valid UTF-8, whatever the source contained
+	return token.Token{Type: typ, Literal: strings.ToValidUTF8(out.String(), string(utf8.RuneError))}
+}
+
+// readEscape decodes the escape sequence starting at the backslash under the
+// cursor, leaving the cursor on its last char. An invalid sequence is reported
+// and copied into the string as written.
+func (l *Lexer) readEscape(out *strings.Builder) {
+	pos := l.position()
 	l.readChar()
-	startpos := l.curpos
-	for l.ch != '"' && l.ch != 0 {
+	switch l.ch {
+	case 'n':
+		out.WriteByte('\n')
+	case 't':
+		out.WriteByte('\t')
+	case '\\':
+		out.WriteByte('\\')
+	case '"':
+		out.WriteByte('"')
+	case '$':
+		out.WriteByte('$')
+	case 'x':
+		digits := l.readHexDigits(2)
+		if len(digits) != 2 {
+			l.errorf(pos, "invalid escape sequence `%s`: \\x takes exactly 2 hex digits", `\x`+digits)
+			out.WriteString(`\x` + digits)
+			return
+		}
+		b, _ := strconv.ParseUint(digits, 16, 8)
+		out.WriteByte(byte(b))
+	case 'u':
+		if l.peekChar() != '{' {
+			l.errorf(pos, "invalid escape sequence `%s`: expected \\u{...}", `\u`)
+			out.WriteString(`\u`)
+			return
+		}
+		l.readChar()
+		digits := l.readHexDigits(6)
+		if l.peekChar() != '}' || digits == "" {
+			l.errorf(pos, "invalid escape sequence `%s`: expected 1 to 6 hex digits followed by }", `\u{`+digits)
+			out.WriteString(`\u{` + digits)
+			return
+		}
 		l.readChar()
+		r, _ := strconv.ParseUint(digits, 16, 32)
+		if r > unicode.MaxRune || 0xD800 <= r && r <= 0xDFFF {
+			l.errorf(pos, "invalid escape sequence `%s`: not a valid code point", `\u{`+digits+`}`)
+			out.WriteRune(utf8.RuneError)
+			return
+		}
+		out.WriteRune(rune(r))
+	default:
+		if l.ch == 0 && l.curpos >= len(l.input) {
+			// let readString report the missing quote
+			out.WriteByte('\\')
+			return
+		}
+		l.errorf(pos, "invalid escape sequence `%s`", `\`+string(l.ch))
+		out.WriteByte('\\')
+		out.WriteRune(l.ch)
 	}
+}
 
-	return l.input[startpos:l.curpos]
+// readHexDigits reads up to max hex digits following the cursor, leaving the
+// cursor on the last one read.
+func (l *Lexer) readHexDigits(max int) string {
+	startpos := l.nextpos
+	for n := 0; n < max && isHexDigit(l.peekChar()); n++ {
+		l.readChar()
+	}
+	return l.input[startpos:l.nextpos]
+}
+
+// readRawString reads a backtick string starting at the opening backtick at
+// start. Raw strings have no escapes and may span several lines.
+func (l *Lexer) readRawString(start token.Position) string {
+	l.readChar()
+	startpos := l.curpos
+	for l.ch != '`' {
+		if l.ch == 0 && l.curpos >= len(l.input) {
+			l.errorf(start, "unterminated string literal")
+			break
+		}
+		l.readChar()
+	}
+	return strings.ToValidUTF8(l.input[startpos:l.curpos], string(utf8.RuneError))
 }
 
 func (l *Lexer) skipWhitespace() {
@@ -153,6 +362,7 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[startpos:l.curpos]
 }
 
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+func isLetter(ch rune) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' ||
+		ch >= utf8.RuneSelf && unicode.IsLetter(ch)
 }
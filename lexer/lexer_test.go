@@ -31,6 +31,8 @@ if (5 < 10) {
 for (let i = 1; i < 5; let i = i + 1) {
 	i + 1;
 }
+json.parse
+match (x) { [a, ...b] => a }
    `
 	tests := []struct {
 		expectedType    token.TokenType
@@ -153,6 +155,23 @@ for (let i = 1; i < 5; let i = i + 1) {
 		{token.INT, "1"},
 		{token.SEMICOLON, ";"},
 		{token.RBRACE, "}"},
+		{token.IDENT, "json"},
+		{token.DOT, "."},
+		{token.IDENT, "parse"},
+		{token.MATCH, "match"},
+		{token.LPAREN, "("},
+		{token.IDENT, "x"},
+		{token.RPAREN, ")"},
+		{token.LBRACE, "{"},
+		{token.LBRACKET, "["},
+		{token.IDENT, "a"},
+		{token.COMMA, ","},
+		{token.ELLIPSIS, "..."},
+		{token.IDENT, "b"},
+		{token.RBRACKET, "]"},
+		{token.ARROW, "=>"},
+		{token.IDENT, "a"},
+		{token.RBRACE, "}"},
 		{token.EOF, ""},
 	}
 
@@ -164,3 +183,198 @@ for (let i = 1; i < 5; let i = i + 1) {
 		}
 	}
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := `let x = 5;
+  x + "ab";`
+	tests := []struct {
+		expectedType     token.TokenType
+		expectedPosition token.Position
+	}{
+		{token.LET, token.Position{Line: 1, Column: 1}},
+		{token.IDENT, token.Position{Line: 1, Column: 5}},
+		{token.ASSIGN, token.Position{Line: 1, Column: 7}},
+		{token.INT, token.Position{Line: 1, Column: 9}},
+		{token.SEMICOLON, token.Position{Line: 1, Column: 10}},
+		{token.IDENT, token.Position{Line: 2, Column: 3}},
+		{token.PLUS, token.Position{Line: 2, Column: 5}},
+		{token.STRING, token.Position{Line: 2, Column: 7}},
+		{token.SEMICOLON, token.Position{Line: 2, Column: 11}},
+	}
+
+	lexer := New(input)
+	for i, tt := range tests {
+		tok := lexer.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - token type wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Position != tt.expectedPosition {
+			t.Fatalf("tests[%d] - position wrong. expected=%s, got=%s", i, tt.expectedPosition, tok.Position)
+		}
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	input := "let café = \"naïve ☃\"; 日本 € café; \"bad \xff byte\""
+	tests := []struct {
+		expectedType     token.TokenType
+		expectedLiteral  string
+		expectedPosition token.Position
+	}{
+		{token.LET, "let", token.Position{Line: 1, Column: 1}},
+		{token.IDENT, "café", token.Position{Line: 1, Column: 5}},
+		{token.ASSIGN, "=", token.Position{Line: 1, Column: 10}},
+		{token.STRING, "naïve ☃", token.Position{Line: 1, Column: 12}},
+		{token.SEMICOLON, ";", token.Position{Line: 1, Column: 21}},
+		{token.IDENT, "日本", token.Position{Line: 1, Column: 23}},
+		{token.ILLEGAL, "€", token.Position{Line: 1, Column: 26}},
+		{token.IDENT, "café", token.Position{Line: 1, Column: 28}},
+		{token.SEMICOLON, ";", token.Position{Line: 1, Column: 32}},
+		{token.STRING, "bad � byte", token.Position{Line: 1, Column: 34}},
+		{token.EOF, "", token.Position{Line: 1, Column: 46}},
+	}
+
+	lexer := New(input)
+	for i, tt := range tests {
+		tok := lexer.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - token type wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Position != tt.expectedPosition {
+			t.Fatalf("tests[%d] - position wrong. expected=%s, got=%s", i, tt.expectedPosition, tok.Position)
+		}
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`"a\nb"`, "a\nb"},
+		{`"a\tb"`, "a\tb"},
+		{`"\\"`, `\`},
+		{`"say \"hi\""`, `say "hi"`},
+		{`"\x41\x7a"`, "Az"},
+		{`"\xe2\x82\xac"`, "€"},
+		{`"\xff"`, "�"},
+		{`"\u{e9}t\u{E9}"`, "été"},
+		{`"\u{1F600}"`, "😀"},
+		{"`raw \\n \"string\"`", `raw \n "string"`},
+		{"`two\nlines`", "two\nlines"},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+		tok := l.NextToken()
+		if tok.Type != token.STRING {
+			t.Fatalf("tests[%d] - token type wrong. expected=%q, got=%q", i, token.STRING, tok.Type)
+		}
+		if tok.Literal != tt.expected {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expected, tok.Literal)
+		}
+		if errs := l.Errors(); len(errs) != 0 {
+			t.Fatalf("tests[%d] - unexpected errors: %v", i, errs)
+		}
+		if next := l.NextToken(); next.Type != token.EOF {
+			t.Fatalf("tests[%d] - expected EOF after string, got=%q", i, next.Type)
+		}
+	}
+}
+
+func TestLexerErrors(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedLiteral string
+		expectedErrors  []string
+	}{
+		{`"abc`, "abc", []string{"1:1: unterminated string literal"}},
+		{"let s = `abc\n", "abc\n", []string{"1:9: unterminated string literal"}},
+		{`"a\`, "a\\", []string{"1:1: unterminated string literal"}},
+		{`"\q"`, `\q`, []string{"1:2: invalid escape sequence `\\q`"}},
+		{`"ab\x4"`, `ab\x4`, []string{"1:4: invalid escape sequence `\\x4`: \\x takes exactly 2 hex digits"}},
+		{`"\u41"`, `\u41`, []string{"1:2: invalid escape sequence `\\u`: expected \\u{...}"}},
+		{`"\u{}"`, `\u{}`, []string{"1:2: invalid escape sequence `\\u{`: expected 1 to 6 hex digits followed by }"}},
+		{`"\u{D800}"`, "�", []string{"1:2: invalid escape sequence `\\u{D800}`: not a valid code point"}},
+	}
+
+	for i, tt := range tests {
+		l := New(tt.input)
+		var tok token.Token
+		for tok = l.NextToken(); tok.Type != token.STRING; tok = l.NextToken() {
+			if tok.Type == token.EOF {
+				t.Fatalf("tests[%d] - no string token", i)
+			}
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Errorf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+		errs := l.Errors()
+		if len(errs) != len(tt.expectedErrors) {
+			t.Fatalf("tests[%d] - wrong number of errors. expected=%v, got=%v", i, tt.expectedErrors, errs)
+		}
+		for j, err := range errs {
+			if err.String() != tt.expectedErrors[j] {
+				t.Errorf("tests[%d] - error %d wrong. expected=%q, got=%q", i, j, tt.expectedErrors[j], err.String())
+			}
+		}
+	}
+}
+
+func TestInterpolatedStrings(t *testing.T) {
+	input := `"a ${x} b ${ {"k": "${y}"} } c" "\${x}"`
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INTERP_START, "a "},
+		{token.IDENT, "x"},
+		{token.INTERP_MID, " b "},
+		{token.LBRACE, "{"},
+		{token.STRING, "k"},
+		{token.COLON, ":"},
+		{token.INTERP_START, ""},
+		{token.IDENT, "y"},
+		{token.INTERP_END, ""},
+		{token.RBRACE, "}"},
+		{token.INTERP_END, " c"},
+		{token.STRING, "${x}"},
+		{token.EOF, ""},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - token type wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+	if errs := l.Errors(); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+}
+
+func TestIntegerLiterals(t *testing.T) {
+	input := "1_000 0xFF_ff 0o17 0b1010 0x 0b102 7;"
+	expected := []string{"1_000", "0xFF_ff", "0o17", "0b1010", "0x", "0b102", "7"}
+
+	l := New(input)
+	for i, lit := range expected {
+		tok := l.NextToken()
+		if tok.Type != token.INT {
+			t.Fatalf("tests[%d] - token type wrong. expected=%q, got=%q", i, token.INT, tok.Type)
+		}
+		if tok.Literal != lit {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, lit, tok.Literal)
+		}
+	}
+	if tok := l.NextToken(); tok.Type != token.SEMICOLON {
+		t.Fatalf("expected SEMICOLON after the literals, got=%q", tok.Type)
+	}
+}
@@ -1,13 +1,41 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"interpego/repl"
 	"os"
 	"os/user"
+	"path/filepath"
 )
 
 func main() {
+	engine := flag.String("engine", string(repl.VM_ENGINE), "engine to run programs with: vm or eval")
+	noOptimize := flag.Bool("O0", false, "disable compile-time optimisations, for debugging")
+	searchPath := flag.String("path", "", "list of directories to look for imported modules in, separated like $PATH")
+	flag.Parse()
+	opts := repl.Options{Engine: repl.Engine(*engine), Optimize: !*noOptimize}
+	if *searchPath != "" {
+		opts.SearchPath = filepath.SplitList(*searchPath)
+	}
+
+	if flag.NArg() > 0 {
+		if flag.Arg(0) != "run" || flag.NArg() != 2 {
+			fmt.Fprintf(os.Stderr, "usage: %s [-engine=vm|eval] [-O0] [-path=dirs] [run <file>]\n", os.Args[0])
+			os.Exit(2)
+		}
+		source, err := os.ReadFile(flag.Arg(1))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		opts.File = flag.Arg(1)
+		if !repl.Run(string(source), os.Stderr, opts) {
+			os.Exit(1)
+		}
+		return
+	}
+
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
@@ -15,5 +43,5 @@ func main() {
 
 	fmt.Printf("Hello %s! This is the Monkey programming language!\n", user.Username)
 	fmt.Printf("Feel free to type in commands\n")
-	repl.Start(os.Stdin, os.Stdout)
+	repl.Start(os.Stdin, os.Stdout, opts)
 }
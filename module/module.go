@@ -0,0 +1,119 @@
+// Package module finds and parses the files Monkey programs import. Running a
+// module is left to the engine importing it; both engines use a Loader so they
+// resolve paths and report cycles the same way.
+package module
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"interpego/ast"
+	"interpego/lexer"
+	"interpego/parser"
+)
+
+// EXTENSION is added to an import path that doesn't have one.
+const EXTENSION = ".monkey"
+
+// Loader resolves import paths to files and tracks the modules being loaded,
+// to detect import cycles.
+type Loader struct {
+	searchPath []string
+	// files of the modules being loaded, outermost first
+	loading []string
+}
+
+// NewLoader returns a Loader that looks for modules in the directories of
+// searchPath when they aren't found next to the file importing them.
+func NewLoader(searchPath []string) *Loader {
+	return &Loader{searchPath: searchPath}
+}
+
+// Resolve returns the file path imported from importer refers to. Relative
+// paths are looked up in the directory of importer first, or the working
+// directory if importer is empty, and then in each directory of the search
+// path. The returned path is absolute, so it identifies the module.
+func (l *Loader) Resolve(importer, path string) (string, error) {
+	if filepath.Ext(path) == "" {
+		path += EXTENSION
+	}
+	if filepath.IsAbs(path) {
+		return checkFile(path, path)
+	}
+
+	dirs := append([]string{filepath.Dir(importer)}, l.searchPath...)
+	if importer == "" {
+		dirs[0] = "."
+	}
+	for _, dir := range dirs {
+		file, err := checkFile(path, filepath.Join(dir, path))
+		if err == nil {
+			return file, nil
+		}
+	}
+	return "", fmt.Errorf("module %q not found", path)
+}
+
+func checkFile(path, file string) (string, error) {
+	info, err := os.Stat(file)
+	if err != nil || info.IsDir() {
+		return "", fmt.Errorf("module %q not found", path)
+	}
+	return filepath.Abs(file)
+}
+
+// Enter records that the module in file is being loaded, failing if it is
+// already being loaded further out, which means modules import each other.
+// Every successful Enter must be followed by a Leave.
+func (l *Loader) Enter(file string) error {
+	for i, loading := range l.loading {
+		if loading == file {
+			cycle := append(append([]string{}, l.loading[i:]...), file)
+			for j := range cycle {
+				cycle[j] = filepath.Base(cycle[j])
+			}
+			return fmt.Errorf("import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+	l.loading = append(l.loading, file)
+	return nil
+}
+
+// Leave records that the module most recently entered has been loaded.
+func (l *Loader) Leave() {
+	l.loading = l.loading[:len(l.loading)-1]
+}
+
+// Parse reads and parses the module in file.
+func Parse(file string) (*ast.Program, error) {
+	source, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	p := parser.New(lexer.New(string(source)))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return nil, errors.New(filepath.Base(file) + ": " + strings.Join(p.Errors(), "; "))
+	}
+	return program, nil
+}
+
+// Exports returns the names a module exports: those bound by its top-level
+// let statements, in the order they are first bound. Names starting with an
+// underscore are private to the module.
+func Exports(program *ast.Program) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, stmt := range program.Statements {
+		let, ok := stmt.(*ast.LetStatement)
+		if !ok || seen[let.Name.Value] || strings.HasPrefix(let.Name.Value, "_") {
+			continue
+		}
+		seen[let.Name.Value] = true
+		names = append(names, let.Name.Value)
+	}
+	return names
+}
@@ -0,0 +1,232 @@
+package object
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// BuiltinDef names a builtin value: a builtin function, or a module hash of
+// builtin functions such as `json`.
+type BuiltinDef struct {
+	Name  string
+	Value Object
+}
+
+// Builtins are the builtin functions shared by the evaluator and the VM. The
+// compiler refers to them by their index, so new ones go at the end.
+var Builtins = concatBuiltins(
+	coreBuiltins,
+	stringBuiltins,
+	collectionBuiltins,
+	ioBuiltins,
+	[]BuiltinDef{
+		{"json", newModule(jsonBuiltins)},
+		{"math", newModule(mathBuiltins)},
+		{"time", newModule(timeBuiltins)},
+		{"regex", newModule(regexBuiltins)},
+	},
+	functionBuiltins,
+)
+
+func concatBuiltins(groups ...[]BuiltinDef) []BuiltinDef {
+	var all []BuiltinDef
+	for _, group := range groups {
+		all = append(all, group...)
+	}
+	return all
+}
+
+var coreBuiltins = []BuiltinDef{
+	{
+		"len",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			switch arg := args[0].(type) {
+			case *String:
+				return &Integer{Value: int64(arg.Len())}
+			case *Array:
+				return &Integer{Value: int64(len(arg.Elements))}
+			default:
+				return newError("argument to `len` not supported, got %s", args[0].Type())
+			}
+		}},
+	},
+	{
+		"first",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			if args[0].Type() != ARRAY_TYPE {
+				return newError("argument to `first` not supported, got=%s, expected=%s", args[0].Type(), ARRAY_TYPE)
+			}
+
+			arr := args[0].(*Array).Elements
+			if len(arr) == 0 {
+				return newError("array index out of bounds: size=%d, index=%d", len(arr), 0)
+			}
+			return arr[0]
+		}},
+	},
+	{
+		"last",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			if args[0].Type() != ARRAY_TYPE {
+				return newError("argument to `last` not supported, got=%s, expected=%s", args[0].Type(), ARRAY_TYPE)
+			}
+
+			arr := args[0].(*Array).Elements
+			if len(arr) == 0 {
+				return newError("array index out of bounds: size=%d, index=%d", len(arr), 0)
+			}
+			return arr[len(arr)-1]
+		}},
+	},
+	{
+		"rest",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			if args[0].Type() != ARRAY_TYPE {
+				return newError("argument to `rest` not supported, got=%s, expected=%s", args[0].Type(), ARRAY_TYPE)
+			}
+
+			arr := args[0].(*Array).Elements
+			if len(arr) > 0 {
+				// arrays are never modified in place, so the rest can share
+				// its elements with the original and recursing over an array
+				// doesn't copy it over and over
+				return &Array{Elements: arr[1:len(arr):len(arr)]}
+			}
+			return nil
+		}},
+	},
+	{
+		"push",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			if args[0].Type() != ARRAY_TYPE {
+				return newError("argument to `push` must be ARRAY, got %s", args[0].Type())
+			}
+
+			arr := args[0].(*Array).Elements
+			length := len(arr)
+			newArr := make([]Object, length+1, length+1)
+			copy(newArr, arr)
+			newArr[length] = args[1]
+			return &Array{Elements: newArr}
+		}},
+	},
+	{
+		"print",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			return writeValues(rt.IO().Stdout, "print", args)
+		}},
+	},
+}
+
+// GetBuiltinByName returns the builtin function called name, or nil if there
+// isn't one.
+func GetBuiltinByName(name string) *Builtin {
+	for _, def := range Builtins {
+		if def.Name == name {
+			builtin, _ := def.Value.(*Builtin)
+			return builtin
+		}
+	}
+	return nil
+}
+
+// newModule returns a hash of defs by name, which a program reaches with
+// `module.name`.
+func newModule(defs []BuiltinDef) *Hash {
+	module := NewHash()
+	for _, def := range defs {
+		module.Set(&String{Value: def.Name}, def.Value)
+	}
+	return module
+}
+
+var functionBuiltins = []BuiltinDef{
+	{
+		// compose(f, g, h)(x) is f(g(h(x))). It is a builtin rather than part
+		// of the prelude because the function it returns has to keep hold of
+		// its arguments, which the VM's functions can't.
+		"compose",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) == 0 {
+				return newError("wrong number of arguments. got=0, want at least 1")
+			}
+			for i, arg := range args {
+				if err := checkArg("compose", i, arg, FUNCTION_TYPE); err != nil {
+					return err
+				}
+			}
+			fns := append([]Object{}, args...)
+			return &Builtin{Fn: func(rt Runtime, args ...Object) Object {
+				result := rt.Call(fns[len(fns)-1], args...)
+				for i := len(fns) - 2; i >= 0 && !isError(result); i-- {
+					result = rt.Call(fns[i], result)
+				}
+				return result
+			}}
+		}},
+	},
+}
+
+// NewRand returns a source of random numbers seeded from the current time, for
+// engines to use when the host doesn't provide one.
+func NewRand() *rand.Rand {
+	return rand.New(rand.NewSource(time.Now().UnixNano()))
+}
+
+func newError(format string, a ...interface{}) *Error {
+	return &Error{Message: fmt.Sprintf(format, a...)}
+}
+
+// checkArgs checks that a builtin was called with one argument of each of the
+// given types. ANY_TYPE accepts any value, NUMBER_TYPE any integer or Float,
+// and FUNCTION_TYPE anything that can be called, whichever engine it belongs
+// to.
+func checkArgs(name string, args []Object, types ...ObjectType) *Error {
+	if len(args) != len(types) {
+		return newError("wrong number of arguments. got=%d, want=%d", len(args), len(types))
+	}
+	for i, typ := range types {
+		if err := checkArg(name, i, args[i], typ); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkArg(name string, i int, arg Object, typ ObjectType) *Error {
+	switch {
+	case typ == ANY_TYPE:
+		return nil
+	case typ == NUMBER_TYPE:
+		if IsNumber(arg) {
+			return nil
+		}
+	case typ == FUNCTION_TYPE:
+		switch arg.(type) {
+		case *Function, *CompiledFunction, *Builtin:
+			return nil
+		}
+	case arg.Type() == typ:
+		return nil
+	}
+	return newError("argument %d to `%s` must be %s, got %s", i+1, name, typ, arg.Type())
+}
@@ -0,0 +1,404 @@
+package object
+
+import "sort"
+
+// MAX_ARRAY_LENGTH bounds the arrays `range` will build. Their length comes
+// from the bounds and step alone, so an extra digit in a bound asks for an
+// array far larger than anything the program has built itself.
+const MAX_ARRAY_LENGTH = 1 << 26
+
+var collectionBuiltins = []BuiltinDef{
+	{
+		"map",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("map", args, FUNCTION_TYPE, ARRAY_TYPE); err != nil {
+				return err
+			}
+			elements := args[1].(*Array).Elements
+			mapped := make([]Object, len(elements))
+			for i, elem := range elements {
+				result := rt.Call(args[0], elem)
+				if isError(result) {
+					return result
+				}
+				mapped[i] = result
+			}
+			return &Array{Elements: mapped}
+		}},
+	},
+	{
+		"reduce",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("reduce", args, FUNCTION_TYPE, ARRAY_TYPE, ANY_TYPE); err != nil {
+				return err
+			}
+			acc := args[2]
+			for _, elem := range args[1].(*Array).Elements {
+				acc = rt.Call(args[0], elem, acc)
+				if isError(acc) {
+					return acc
+				}
+			}
+			return acc
+		}},
+	},
+	{
+		"filter",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("filter", args, FUNCTION_TYPE, ARRAY_TYPE); err != nil {
+				return err
+			}
+			kept := []Object{}
+			for _, elem := range args[1].(*Array).Elements {
+				keep, err := callPredicate(rt, "filter", args[0], elem)
+				if err != nil {
+					return err
+				}
+				if keep {
+					kept = append(kept, elem)
+				}
+			}
+			return &Array{Elements: kept}
+		}},
+	},
+	{
+		"find",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("find", args, FUNCTION_TYPE, ARRAY_TYPE); err != nil {
+				return err
+			}
+			for _, elem := range args[1].(*Array).Elements {
+				found, err := callPredicate(rt, "find", args[0], elem)
+				if err != nil {
+					return err
+				}
+				if found {
+					return elem
+				}
+			}
+			return nil
+		}},
+	},
+	{
+		"any",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("any", args, FUNCTION_TYPE, ARRAY_TYPE); err != nil {
+				return err
+			}
+			for _, elem := range args[1].(*Array).Elements {
+				ok, err := callPredicate(rt, "any", args[0], elem)
+				if err != nil {
+					return err
+				}
+				if ok {
+					return TRUE
+				}
+			}
+			return FALSE
+		}},
+	},
+	{
+		"all",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("all", args, FUNCTION_TYPE, ARRAY_TYPE); err != nil {
+				return err
+			}
+			for _, elem := range args[1].(*Array).Elements {
+				ok, err := callPredicate(rt, "all", args[0], elem)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return FALSE
+				}
+			}
+			return TRUE
+		}},
+	},
+	{"sort", &Builtin{Fn: sortBuiltin}},
+	{
+		"reverse",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) != 1 {
+				return newError("wrong number of arguments. got=%d, want=1", len(args))
+			}
+			switch arg := args[0].(type) {
+			case *Array:
+				n := len(arg.Elements)
+				reversed := make([]Object, n)
+				for i, elem := range arg.Elements {
+					reversed[n-1-i] = elem
+				}
+				return &Array{Elements: reversed}
+			case *String:
+				chars := []rune(arg.Value)
+				for i, j := 0, len(chars)-1; i < j; i, j = i+1, j-1 {
+					chars[i], chars[j] = chars[j], chars[i]
+				}
+				return &String{Value: string(chars)}
+			default:
+				return newError("argument 1 to `reverse` must be ARRAY or STRING, got %s", args[0].Type())
+			}
+		}},
+	},
+	{
+		"slice",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) != 2 && len(args) != 3 {
+				return newError("wrong number of arguments. got=%d, want=2 or 3", len(args))
+			}
+			types := []ObjectType{ARRAY_TYPE, INTEGER_TYPE, INTEGER_TYPE}
+			for i, arg := range args {
+				if err := checkArg("slice", i, arg, types[i]); err != nil {
+					return err
+				}
+			}
+
+			elements := args[0].(*Array).Elements
+			start, end := args[1].(*Integer).Value, int64(len(elements))
+			if len(args) == 3 {
+				end = args[2].(*Integer).Value
+			}
+			if start < 0 || end < start || end > int64(len(elements)) {
+				return newError("slice out of bounds: size=%d, start=%d, end=%d", len(elements), start, end)
+			}
+			// like `rest`, the slice shares its elements with the original
+			return &Array{Elements: elements[start:end:end]}
+		}},
+	},
+	{
+		"range",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) < 1 || len(args) > 3 {
+				return newError("wrong number of arguments. got=%d, want=1 to 3", len(args))
+			}
+			for i, arg := range args {
+				if err := checkArg("range", i, arg, INTEGER_TYPE); err != nil {
+					return err
+				}
+			}
+
+			// range(end), range(start, end) or range(start, end, step)
+			start, end, step := int64(0), args[0].(*Integer).Value, int64(1)
+			if len(args) > 1 {
+				start, end = end, args[1].(*Integer).Value
+			}
+			if len(args) > 2 {
+				step = args[2].(*Integer).Value
+			}
+			if step == 0 {
+				return newError("argument 3 to `range` must not be zero")
+			}
+
+			// worked out on unsigned values, which can hold the distance
+			// between any two int64s
+			var distance, stride uint64
+			switch {
+			case step > 0 && end > start:
+				distance, stride = uint64(end)-uint64(start), uint64(step)
+			case step < 0 && end < start:
+				distance, stride = uint64(start)-uint64(end), -uint64(step)
+			default:
+				return &Array{Elements: []Object{}}
+			}
+			count := distance / stride
+			if distance%stride != 0 {
+				count++
+			}
+			if count > MAX_ARRAY_LENGTH {
+				return newError("`range` result too long: arrays can have at most %d elements", MAX_ARRAY_LENGTH)
+			}
+			elements := make([]Object, count)
+			for i := range elements {
+				elements[i] = &Integer{Value: start + int64(i)*step}
+			}
+			return &Array{Elements: elements}
+		}},
+	},
+	{
+		"zip",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("zip", args, ARRAY_TYPE, ARRAY_TYPE); err != nil {
+				return err
+			}
+			left, right := args[0].(*Array).Elements, args[1].(*Array).Elements
+			pairs := make([]Object, min(len(left), len(right)))
+			for i := range pairs {
+				pairs[i] = &Array{Elements: []Object{left[i], right[i]}}
+			}
+			return &Array{Elements: pairs}
+		}},
+	},
+	{
+		"flatten",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("flatten", args, ARRAY_TYPE); err != nil {
+				return err
+			}
+			// only one level of nesting is removed
+			flat := []Object{}
+			for _, elem := range args[0].(*Array).Elements {
+				if inner, ok := elem.(*Array); ok {
+					flat = append(flat, inner.Elements...)
+				} else {
+					flat = append(flat, elem)
+				}
+			}
+			return &Array{Elements: flat}
+		}},
+	},
+	{
+		"keys",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("keys", args, HASH_TYPE); err != nil {
+				return err
+			}
+			pairs := args[0].(*Hash).Ordered()
+			keys := make([]Object, len(pairs))
+			for i, pair := range pairs {
+				keys[i] = pair.Key
+			}
+			return &Array{Elements: keys}
+		}},
+	},
+	{
+		"values",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("values", args, HASH_TYPE); err != nil {
+				return err
+			}
+			pairs := args[0].(*Hash).Ordered()
+			values := make([]Object, len(pairs))
+			for i, pair := range pairs {
+				values[i] = pair.Value
+			}
+			return &Array{Elements: values}
+		}},
+	},
+	{
+		"has",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("has", args, HASH_TYPE, ANY_TYPE); err != nil {
+				return err
+			}
+			key, ok := args[1].(Hashable)
+			if !ok {
+				return newError("unusable as hash key: %s", args[1].Type())
+			}
+			_, ok = args[0].(*Hash).Get(key)
+			return nativeBool(ok)
+		}},
+	},
+	{
+		"delete",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("delete", args, HASH_TYPE, ANY_TYPE); err != nil {
+				return err
+			}
+			key, ok := args[1].(Hashable)
+			if !ok {
+				return newError("unusable as hash key: %s", args[1].Type())
+			}
+			// hashes are never modified in place, so this builds a new one
+			deleted := key.HashKey()
+			result := NewHash()
+			for _, pair := range args[0].(*Hash).Ordered() {
+				if pair.Key.(Hashable).HashKey() != deleted {
+					result.Set(pair.Key.(Hashable), pair.Value)
+				}
+			}
+			return result
+		}},
+	},
+	{
+		"merge",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("merge", args, HASH_TYPE, HASH_TYPE); err != nil {
+				return err
+			}
+			// keys of the second hash win, but keys of the first keep their
+			// place in the order
+			result := NewHash()
+			for _, hash := range args {
+				for _, pair := range hash.(*Hash).Ordered() {
+					result.Set(pair.Key.(Hashable), pair.Value)
+				}
+			}
+			return result
+		}},
+	},
+}
+
+// sortBuiltin implements `sort(array)` and `sort(array, less)`. Without a
+// comparator the elements must all be integers or all be strings. less is
+// called with two elements and returns whether the first goes before the
+// second. The sort is stable.
+func sortBuiltin(rt Runtime, args ...Object) Object {
+	if len(args) != 1 && len(args) != 2 {
+		return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+	}
+	if err := checkArg("sort", 0, args[0], ARRAY_TYPE); err != nil {
+		return err
+	}
+	sorted := append([]Object(nil), args[0].(*Array).Elements...)
+
+	if len(args) == 1 {
+		for _, elem := range sorted {
+			if !naturallyOrdered(sorted[0], elem) {
+				return newError("`sort` without a comparator needs all INTEGER or all STRING elements, got %s and %s", sorted[0].Type(), elem.Type())
+			}
+		}
+		sort.SliceStable(sorted, func(i, j int) bool {
+			if a, ok := sorted[i].(*String); ok {
+				return a.Value < sorted[j].(*String).Value
+			}
+			return CompareIntegers(sorted[i], sorted[j]) < 0
+		})
+		return &Array{Elements: sorted}
+	}
+
+	if err := checkArg("sort", 1, args[1], FUNCTION_TYPE); err != nil {
+		return err
+	}
+	var failed Object
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if failed != nil {
+			return false
+		}
+		less, err := callPredicate(rt, "sort", args[1], sorted[i], sorted[j])
+		if err != nil {
+			failed = err
+		}
+		return less
+	})
+	if failed != nil {
+		return failed
+	}
+	return &Array{Elements: sorted}
+}
+
+// naturallyOrdered reports whether a and b can be sorted without a comparator,
+// which needs them to both be integers or both be strings.
+func naturallyOrdered(a, b Object) bool {
+	if IsInteger(a) {
+		return IsInteger(b)
+	}
+	return a.Type() == STRING_TYPE && b.Type() == STRING_TYPE
+}
+
+// callPredicate calls fn with args and checks that it returned a boolean.
+func callPredicate(rt Runtime, name string, fn Object, args ...Object) (bool, Object) {
+	result := rt.Call(fn, args...)
+	if isError(result) {
+		return false, result
+	}
+	boolean, ok := result.(*Boolean)
+	if !ok {
+		return false, newError("function passed to `%s` must return BOOLEAN, got %s", name, result.Type())
+	}
+	return boolean.Value, nil
+}
+
+func isError(obj Object) bool {
+	return obj != nil && obj.Type() == ERROR_TYPE
+}
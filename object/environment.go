@@ -3,6 +3,10 @@ package object
 type Environment struct {
 	store map[string]Object
 	outer *Environment
+	// file the program or module whose top level this is was read from
+	file string
+	// calls deferred by the function call this is the environment of
+	deferred []func() Object
 }
 
 func NewEnclosedEnvironment(outer *Environment) *Environment {
@@ -16,6 +20,36 @@ func NewEnvironment() *Environment {
 	return &Environment{store: s}
 }
 
+// NewFileEnvironment returns the environment for the top level of the program
+// or module read from file, which the imports in it are relative to.
+func NewFileEnvironment(file string) *Environment {
+	env := NewEnvironment()
+	env.file = file
+	return env
+}
+
+// File returns the file of the program or module e belongs to, or "" if it
+// wasn't read from a file.
+func (e *Environment) File() string {
+	for ; e != nil; e = e.outer {
+		if e.file != "" {
+			return e.file
+		}
+	}
+	return ""
+}
+
+// Defer records call, to be made when the function call e is the environment
+// of returns.
+func (e *Environment) Defer(call func() Object) {
+	e.deferred = append(e.deferred, call)
+}
+
+// Deferred returns the calls deferred in e, in the order they were deferred.
+func (e *Environment) Deferred() []func() Object {
+	return e.deferred
+}
+
 func (e *Environment) Set(name string, val Object) {
 	e.store[name] = val
 }
@@ -0,0 +1,107 @@
+package object
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Float is a 64-bit floating point number. There is no literal syntax for
+// floats; they come from builtins such as `json.parse`. Arithmetic mixing a
+// Float with an integer converts the integer and gives a Float.
+type Float struct {
+	Value float64
+}
+
+func (f *Float) Type() ObjectType {
+	return FLOAT_TYPE
+}
+
+// Inspect always includes a decimal point or an exponent, so a whole Float
+// can be told apart from an Integer, as in JSON output read back in.
+func (f *Float) Inspect() string {
+	str := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(str, ".eIN") {
+		str += ".0"
+	}
+	return str
+}
+
+// IsNumber reports whether obj is an integer or a Float.
+func IsNumber(obj Object) bool {
+	return IsInteger(obj) || obj.Type() == FLOAT_TYPE
+}
+
+// ToFloat converts an integer or a Float to a float64, rounding integers that
+// can't be represented exactly.
+func ToFloat(obj Object) float64 {
+	switch obj := obj.(type) {
+	case *Integer:
+		return float64(obj.Value)
+	case *BigInt:
+		f, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return f
+	case *Float:
+		return obj.Value
+	default:
+		panic(fmt.Sprintf("not a number: %s", obj.Type()))
+	}
+}
+
+// FloatArithmetic applies one of the operators + - * / to two numbers, at
+// least one of which is a Float. Like integer division, dividing by zero is an
+// error rather than an infinity.
+func FloatArithmetic(operator string, left, right Object) (Object, error) {
+	x, y := ToFloat(left), ToFloat(right)
+	switch operator {
+	case "+":
+		return &Float{Value: x + y}, nil
+	case "-":
+		return &Float{Value: x - y}, nil
+	case "*":
+		return &Float{Value: x * y}, nil
+	case "/":
+		if y == 0 {
+			return nil, errDivisionByZero
+		}
+		return &Float{Value: x / y}, nil
+	default:
+		return nil, fmt.Errorf("unknown float operator: %s", operator)
+	}
+}
+
+// Arithmetic applies one of the operators + - * / to two numbers, with
+// IntegerArithmetic when both are integers and FloatArithmetic otherwise.
+func Arithmetic(operator string, left, right Object) (Object, error) {
+	if IsInteger(left) && IsInteger(right) {
+		return IntegerArithmetic(operator, left, right)
+	}
+	return FloatArithmetic(operator, left, right)
+}
+
+// Negate returns -value for an integer or a Float.
+func Negate(value Object) Object {
+	if f, ok := value.(*Float); ok {
+		return &Float{Value: -f.Value}
+	}
+	return NegateInteger(value)
+}
+
+// CompareNumbers returns -1, 0 or +1 depending on whether left is less than,
+// equal to or greater than right, comparing integers exactly and anything
+// involving a Float as float64s.
+func CompareNumbers(left, right Object) int {
+	if IsInteger(left) && IsInteger(right) {
+		return CompareIntegers(left, right)
+	}
+	x, y := ToFloat(left), ToFloat(right)
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	default:
+		return 0
+	}
+}
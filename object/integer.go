@@ -0,0 +1,147 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// BigInt is an integer that doesn't fit in 64 bits. Integer arithmetic
+// promotes to a BigInt when a result overflows and goes back to an Integer
+// once a result fits again, so a BigInt never holds a value an Integer could.
+type BigInt struct {
+	Value *big.Int
+}
+
+func (bi *BigInt) Type() ObjectType {
+	return BIGINT_TYPE
+}
+
+func (bi *BigInt) Inspect() string {
+	return bi.Value.String()
+}
+
+// NewInteger returns value as an Integer if it fits in 64 bits and as a
+// BigInt otherwise.
+func NewInteger(value *big.Int) Object {
+	if value.IsInt64() {
+		return &Integer{Value: value.Int64()}
+	}
+	return &BigInt{Value: value}
+}
+
+// IsInteger reports whether obj is an Integer or a BigInt.
+func IsInteger(obj Object) bool {
+	switch obj.(type) {
+	case *Integer, *BigInt:
+		return true
+	default:
+		return false
+	}
+}
+
+func toBig(obj Object) *big.Int {
+	switch obj := obj.(type) {
+	case *Integer:
+		return big.NewInt(obj.Value)
+	case *BigInt:
+		return obj.Value
+	default:
+		panic(fmt.Sprintf("not an integer: %s", obj.Type()))
+	}
+}
+
+var errDivisionByZero = errors.New("division by zero")
+
+// IntegerArithmetic applies one of the operators + - * / to two integers,
+// either of which may be a BigInt. When both are Integers the result is
+// computed on int64s, and only a result that overflows is recomputed with
+// math/big. Division truncates towards zero, and dividing by zero is an error.
+// Both engines go through here so they agree on the result and the error.
+func IntegerArithmetic(operator string, left, right Object) (Object, error) {
+	l, lok := left.(*Integer)
+	r, rok := right.(*Integer)
+	if lok && rok {
+		if result, ok, err := int64Arithmetic(operator, l.Value, r.Value); err != nil || ok {
+			return result, err
+		}
+	}
+
+	x, y := toBig(left), toBig(right)
+	result := new(big.Int)
+	switch operator {
+	case "+":
+		result.Add(x, y)
+	case "-":
+		result.Sub(x, y)
+	case "*":
+		result.Mul(x, y)
+	case "/":
+		if y.Sign() == 0 {
+			return nil, errDivisionByZero
+		}
+		result.Quo(x, y)
+	default:
+		return nil, fmt.Errorf("unknown integer operator: %s", operator)
+	}
+	return NewInteger(result), nil
+}
+
+// int64Arithmetic is the fast path of IntegerArithmetic. ok is false when the
+// result doesn't fit in 64 bits.
+func int64Arithmetic(operator string, left, right int64) (result Object, ok bool, err error) {
+	var value int64
+	overflow := false
+	switch operator {
+	case "+":
+		value = left + right
+		overflow = (left > 0 && right > 0 && value < 0) || (left < 0 && right < 0 && value >= 0)
+	case "-":
+		value = left - right
+		overflow = (left >= 0 && right < 0 && value < 0) || (left < 0 && right > 0 && value >= 0)
+	case "*":
+		value = left * right
+		overflow = left != 0 && (value/left != right || (left == -1 && right == math.MinInt64))
+	case "/":
+		if right == 0 {
+			return nil, false, errDivisionByZero
+		}
+		overflow = left == math.MinInt64 && right == -1
+		if !overflow {
+			value = left / right
+		}
+	default:
+		return nil, false, fmt.Errorf("unknown integer operator: %s", operator)
+	}
+	if overflow {
+		return nil, false, nil
+	}
+	return &Integer{Value: value}, true, nil
+}
+
+// NegateInteger returns -value for an Integer or a BigInt.
+func NegateInteger(value Object) Object {
+	if i, ok := value.(*Integer); ok && i.Value != math.MinInt64 {
+		return &Integer{Value: -i.Value}
+	}
+	return NewInteger(new(big.Int).Neg(toBig(value)))
+}
+
+// CompareIntegers returns -1, 0 or +1 depending on whether left is less than,
+// equal to or greater than right. Either may be a BigInt.
+func CompareIntegers(left, right Object) int {
+	l, lok := left.(*Integer)
+	r, rok := right.(*Integer)
+	if lok && rok {
+		switch {
+		case l.Value < r.Value:
+			return -1
+		case l.Value > r.Value:
+			return 1
+		default:
+			return 0
+		}
+	}
+	return toBig(left).Cmp(toBig(right))
+}
@@ -0,0 +1,88 @@
+package object
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// IO holds the streams builtins read input from and write output to, so a host
+// embedding an engine can capture or redirect them.
+type IO struct {
+	Stdout io.Writer
+	Stderr io.Writer
+	// Stdin is buffered so that lines can be read from it one at a time
+	// without losing what was read ahead
+	Stdin *bufio.Reader
+}
+
+// NewIO returns an IO using the given streams.
+func NewIO(stdout, stderr io.Writer, stdin io.Reader) *IO {
+	return &IO{Stdout: stdout, Stderr: stderr, Stdin: bufio.NewReader(stdin)}
+}
+
+// DefaultIO returns an IO using the process's standard streams.
+func DefaultIO() *IO {
+	return NewIO(os.Stdout, os.Stderr, os.Stdin)
+}
+
+// ReadLine reads a line from Stdin without its line ending. At the end of the
+// input it returns io.EOF, unless there was an unterminated last line to
+// return first.
+func (i *IO) ReadLine() (string, error) {
+	line, err := i.Stdin.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r"), nil
+}
+
+var ioBuiltins = []BuiltinDef{
+	{
+		"println",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			return writeValues(rt.IO().Stdout, "println", args)
+		}},
+	},
+	{
+		"eprint",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			return writeValues(rt.IO().Stderr, "eprint", args)
+		}},
+	},
+	{
+		"readLine",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) != 0 {
+				return newError("wrong number of arguments. got=%d, want=0", len(args))
+			}
+			line, err := rt.IO().ReadLine()
+			if err == io.EOF {
+				return NULL
+			}
+			if err != nil {
+				return newError("`readLine` failed: %s", err)
+			}
+			return &String{Value: line}
+		}},
+	},
+}
+
+// writeValues writes the Inspect form of each value to out, separated by
+// spaces and followed by a newline.
+func writeValues(out io.Writer, name string, values []Object) Object {
+	parts := make([]string, len(values))
+	for i, value := range values {
+		parts[i] = value.Inspect()
+	}
+	if _, err := fmt.Fprintln(out, strings.Join(parts, " ")); err != nil {
+		return newError("`%s` failed: %s", name, err)
+	}
+	return nil
+}
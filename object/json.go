@@ -0,0 +1,242 @@
+package object
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// MAX_JSON_DEPTH bounds how deeply the arrays and objects `json.parse` reads
+// can nest.
+const MAX_JSON_DEPTH = 10000
+
+var jsonBuiltins = []BuiltinDef{
+	{
+		"parse",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("json.parse", args, STRING_TYPE); err != nil {
+				return err
+			}
+			value, err := parseJSON(args[0].(*String).Value)
+			if err != nil {
+				return newError("`json.parse` failed: %s", err)
+			}
+			return value
+		}},
+	},
+	{
+		"stringify",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) != 1 && len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=1 or 2", len(args))
+			}
+			indent := ""
+			if len(args) == 2 {
+				switch arg := args[1].(type) {
+				case *Integer:
+					if arg.Value < 0 || arg.Value > 10 {
+						return newError("argument 2 to `json.stringify` must be between 0 and 10, got %d", arg.Value)
+					}
+					indent = strings.Repeat(" ", int(arg.Value))
+				case *String:
+					indent = arg.Value
+				default:
+					return newError("argument 2 to `json.stringify` must be INTEGER or STRING, got %s", arg.Type())
+				}
+			}
+			enc := &jsonEncoder{indent: indent}
+			if err := enc.encode(args[0], "$", 0); err != nil {
+				return newError("`json.stringify` failed: %s", err)
+			}
+			return &String{Value: enc.out.String()}
+		}},
+	},
+}
+
+// parseJSON decodes a single JSON value. Objects become hashes with their keys
+// in document order, and numbers become integers, or Floats if they have a
+// fraction or an exponent.
+func parseJSON(input string) (Object, error) {
+	dec := json.NewDecoder(strings.NewReader(input))
+	dec.UseNumber()
+	value, err := decodeJSON(dec, 0)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after top-level value")
+	}
+	return value, nil
+}
+
+func decodeJSON(dec *json.Decoder, depth int) (Object, error) {
+	tok, err := dec.Token()
+	if err == io.EOF {
+		return nil, errors.New("unexpected end of JSON input")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch tok := tok.(type) {
+	case nil:
+		return NULL, nil
+	case bool:
+		return nativeBool(tok), nil
+	case string:
+		return &String{Value: tok}, nil
+	case json.Number:
+		return jsonNumber(tok)
+	}
+
+	if depth == MAX_JSON_DEPTH {
+		return nil, fmt.Errorf("nesting deeper than %d", MAX_JSON_DEPTH)
+	}
+	if tok == json.Delim('[') {
+		elements := []Object{}
+		for dec.More() {
+			elem, err := decodeJSON(dec, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, elem)
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return &Array{Elements: elements}, nil
+	}
+
+	// the only other delimiter a value can start with is {
+	hash := NewHash()
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		value, err := decodeJSON(dec, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		// a repeated key takes the last value, but keeps its first place
+		hash.Set(&String{Value: key.(string)}, value)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return hash, nil
+}
+
+func jsonNumber(num json.Number) (Object, error) {
+	if !strings.ContainsAny(string(num), ".eE") {
+		value, ok := new(big.Int).SetString(string(num), 10)
+		if ok {
+			return NewInteger(value), nil
+		}
+	}
+	value, err := strconv.ParseFloat(string(num), 64)
+	if err != nil {
+		return nil, fmt.Errorf("number %s out of range", num)
+	}
+	return &Float{Value: value}, nil
+}
+
+// jsonEncoder writes values as JSON, with hash keys in insertion order. With
+// an indent, every element and pair goes on its own line.
+type jsonEncoder struct {
+	out    bytes.Buffer
+	indent string
+}
+
+// encode writes value, which is at path in the value being converted, for
+// error messages.
+func (e *jsonEncoder) encode(value Object, path string, depth int) error {
+	switch value := value.(type) {
+	case *Null:
+		e.out.WriteString("null")
+	case *Boolean, *Integer, *BigInt:
+		e.out.WriteString(value.Inspect())
+	case *Float:
+		if math.IsInf(value.Value, 0) || math.IsNaN(value.Value) {
+			return fmt.Errorf("%s at %s has no JSON representation", value.Inspect(), path)
+		}
+		e.out.WriteString(value.Inspect())
+	case *String:
+		e.writeString(value.Value)
+	case *Array:
+		if len(value.Elements) == 0 {
+			e.out.WriteString("[]")
+			return nil
+		}
+		e.out.WriteString("[")
+		for i, elem := range value.Elements {
+			if i > 0 {
+				e.out.WriteString(",")
+			}
+			e.newline(depth + 1)
+			if err := e.encode(elem, fmt.Sprintf("%s[%d]", path, i), depth+1); err != nil {
+				return err
+			}
+		}
+		e.newline(depth)
+		e.out.WriteString("]")
+	case *Hash:
+		if len(value.Keys) == 0 {
+			e.out.WriteString("{}")
+			return nil
+		}
+		e.out.WriteString("{")
+		for i, pair := range value.Ordered() {
+			var key string
+			switch k := pair.Key.(type) {
+			case *String:
+				key = k.Value
+			case *Integer, *BigInt:
+				// as in JavaScript, integer keys become their decimal form
+				key = k.Inspect()
+			default:
+				return fmt.Errorf("hash key %s at %s must be STRING or INTEGER, got %s", k.Inspect(), path, k.Type())
+			}
+			if i > 0 {
+				e.out.WriteString(",")
+			}
+			e.newline(depth + 1)
+			e.writeString(key)
+			e.out.WriteString(":")
+			if e.indent != "" {
+				e.out.WriteString(" ")
+			}
+			if err := e.encode(pair.Value, fmt.Sprintf("%s[%s]", path, strconv.Quote(key)), depth+1); err != nil {
+				return err
+			}
+		}
+		e.newline(depth)
+		e.out.WriteString("}")
+	default:
+		return fmt.Errorf("%s at %s can't be converted to JSON", value.Type(), path)
+	}
+	return nil
+}
+
+func (e *jsonEncoder) newline(depth int) {
+	if e.indent == "" {
+		return
+	}
+	e.out.WriteString("\n")
+	e.out.WriteString(strings.Repeat(e.indent, depth))
+}
+
+func (e *jsonEncoder) writeString(str string) {
+	// unlike json.Marshal, leave <, > and & as they are
+	enc := json.NewEncoder(&e.out)
+	enc.SetEscapeHTML(false)
+	enc.Encode(str)
+	// Encode ends every value with a newline
+	e.out.Truncate(e.out.Len() - 1)
+}
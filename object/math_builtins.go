@@ -0,0 +1,173 @@
+package object
+
+import (
+	"math"
+	"math/big"
+)
+
+// MAX_POW_BITS bounds the size of the integers `math.pow` will compute. Integer
+// powers are exact and their size grows with the exponent, so a large one would
+// keep the program busy for minutes before it ran out of memory.
+const MAX_POW_BITS = 1 << 20
+
+var mathBuiltins = []BuiltinDef{
+	{"pi", &Float{Value: math.Pi}},
+	{"e", &Float{Value: math.E}},
+	{
+		"abs",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("math.abs", args, NUMBER_TYPE); err != nil {
+				return err
+			}
+			if CompareNumbers(args[0], &Integer{Value: 0}) < 0 {
+				return Negate(args[0])
+			}
+			return args[0]
+		}},
+	},
+	{
+		"min",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			return extreme("math.min", args, -1)
+		}},
+	},
+	{
+		"max",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			return extreme("math.max", args, 1)
+		}},
+	},
+	{
+		"clamp",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("math.clamp", args, NUMBER_TYPE, NUMBER_TYPE, NUMBER_TYPE); err != nil {
+				return err
+			}
+			value, lo, hi := args[0], args[1], args[2]
+			if CompareNumbers(lo, hi) > 0 {
+				return newError("`math.clamp` bounds are the wrong way round: %s > %s", lo.Inspect(), hi.Inspect())
+			}
+			switch {
+			case CompareNumbers(value, lo) < 0:
+				return lo
+			case CompareNumbers(value, hi) > 0:
+				return hi
+			default:
+				return value
+			}
+		}},
+	},
+	{
+		"pow",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("math.pow", args, NUMBER_TYPE, NUMBER_TYPE); err != nil {
+				return err
+			}
+			base, exp := args[0], args[1]
+			// integers to non-negative integer powers stay exact
+			if !IsInteger(base) || !IsInteger(exp) || exp.Type() == BIGINT_TYPE || exp.(*Integer).Value < 0 {
+				return &Float{Value: math.Pow(ToFloat(base), ToFloat(exp))}
+			}
+			x, n := toBig(base), exp.(*Integer).Value
+			if x.CmpAbs(big.NewInt(1)) > 0 && int64(x.BitLen()-1) > MAX_POW_BITS/max(n, 1) {
+				return newError("`math.pow` result too large: integers can have at most %d bits here", MAX_POW_BITS)
+			}
+			return NewInteger(new(big.Int).Exp(x, big.NewInt(n), nil))
+		}},
+	},
+	{
+		"sqrt",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("math.sqrt", args, NUMBER_TYPE); err != nil {
+				return err
+			}
+			x := ToFloat(args[0])
+			if x < 0 {
+				return newError("argument 1 to `math.sqrt` must not be negative, got %s", args[0].Inspect())
+			}
+			return &Float{Value: math.Sqrt(x)}
+		}},
+	},
+	{
+		"floor",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			return round("math.floor", args, math.Floor)
+		}},
+	},
+	{
+		"ceil",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			return round("math.ceil", args, math.Ceil)
+		}},
+	},
+	{
+		"random",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("math.random", args); err != nil {
+				return err
+			}
+			return &Float{Value: rt.Rand().Float64()}
+		}},
+	},
+	{
+		"randomInt",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("math.randomInt", args, INTEGER_TYPE, INTEGER_TYPE); err != nil {
+				return err
+			}
+			// like `range`, the upper bound is excluded
+			lo, hi := args[0].(*Integer).Value, args[1].(*Integer).Value
+			if lo >= hi {
+				return newError("`math.randomInt` needs lo < hi, got %d and %d", lo, hi)
+			}
+			span := new(big.Int).Sub(big.NewInt(hi), big.NewInt(lo))
+			offset := new(big.Int).Rand(rt.Rand(), span)
+			return NewInteger(offset.Add(offset, big.NewInt(lo)))
+		}},
+	},
+	{
+		"seed",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("math.seed", args, INTEGER_TYPE); err != nil {
+				return err
+			}
+			rt.Rand().Seed(args[0].(*Integer).Value)
+			return nil
+		}},
+	},
+}
+
+// extreme implements `math.min` (sign -1) and `math.max` (sign +1), which take
+// one or more numbers. Of equal numbers, the first is returned.
+func extreme(name string, args []Object, sign int) Object {
+	if len(args) == 0 {
+		return newError("wrong number of arguments. got=0, want at least 1")
+	}
+	var best Object
+	for i, arg := range args {
+		if err := checkArg(name, i, arg, NUMBER_TYPE); err != nil {
+			return err
+		}
+		if best == nil || CompareNumbers(arg, best)*sign > 0 {
+			best = arg
+		}
+	}
+	return best
+}
+
+// round implements `math.floor` and `math.ceil`, which give integers unchanged
+// and round Floats to an integer with fn.
+func round(name string, args []Object, fn func(float64) float64) Object {
+	if err := checkArgs(name, args, NUMBER_TYPE); err != nil {
+		return err
+	}
+	f, ok := args[0].(*Float)
+	if !ok {
+		return args[0]
+	}
+	if math.IsInf(f.Value, 0) || math.IsNaN(f.Value) {
+		return newError("argument 1 to `%s` must be finite, got %s", name, f.Inspect())
+	}
+	rounded, _ := big.NewFloat(fn(f.Value)).Int(nil)
+	return NewInteger(rounded)
+}
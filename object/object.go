@@ -4,16 +4,21 @@ import (
 	"bytes"
 	"fmt"
 	"hash/fnv"
+	"math/rand"
 	"strings"
+	"unicode/utf8"
 
 	"interpego/ast"
 	"interpego/code"
+	"interpego/token"
 )
 
 type ObjectType string
 
 const (
 	INTEGER_TYPE           = "INTEGER"
+	BIGINT_TYPE            = "BIGINT"
+	FLOAT_TYPE             = "FLOAT"
 	BOOLEAN_TYPE           = "BOOLEAN"
 	NULL_TYPE              = "NULL"
 	RETURN_TYPE            = "RETURN"
@@ -24,6 +29,12 @@ const (
 	ARRAY_TYPE             = "ARRAY"
 	HASH_TYPE              = "HASH"
 	COMPILED_FUNCTION_TYPE = "COMPILED_FUNCTION"
+	TIME_TYPE              = "TIME"
+
+	// ANY_TYPE and NUMBER_TYPE are never the type of a value. Builtins use
+	// them to accept an argument of any type, or any integer or Float.
+	ANY_TYPE    = "ANY"
+	NUMBER_TYPE = "NUMBER"
 )
 
 type Object interface {
@@ -67,6 +78,41 @@ func (s *String) Inspect() string {
 	return s.Value
 }
 
+// Len returns the number of characters in the string. Strings are indexed by
+// character rather than by byte, so multi-byte UTF-8 sequences count once.
+func (s *String) Len() int {
+	return utf8.RuneCountInString(s.Value)
+}
+
+// CharAt returns the character at index i as a string, and false if i is out
+// of range.
+func (s *String) CharAt(i int64) (*String, bool) {
+	if i < 0 {
+		return nil, false
+	}
+	var idx int64
+	for _, r := range s.Value {
+		if idx == i {
+			return &String{Value: string(r)}, true
+		}
+		idx++
+	}
+	return nil, false
+}
+
+// Interpolate builds the value of an interpolated string, putting each value's
+// Inspect form between the strings around it. There must be one more string
+// than there are values.
+func Interpolate(strs []string, values []Object) *String {
+	var out strings.Builder
+	for i, value := range values {
+		out.WriteString(strs[i])
+		out.WriteString(value.Inspect())
+	}
+	out.WriteString(strs[len(strs)-1])
+	return &String{Value: out.String()}
+}
+
 func (s *String) Add(other Object) (Object, error) {
 	otherString, ok := other.(*String)
 	if !ok {
@@ -75,6 +121,51 @@ func (s *String) Add(other Object) (Object, error) {
 	return &String{Value: s.Value + otherString.Value}, nil
 }
 
+// TRUE, FALSE and NULL are the only Boolean and Null values. Both engines
+// compare them by identity, so builtins must return these rather than new
+// values.
+var (
+	TRUE  = &Boolean{Value: true}
+	FALSE = &Boolean{Value: false}
+	NULL  = &Null{}
+)
+
+func nativeBool(value bool) *Boolean {
+	if value {
+		return TRUE
+	}
+	return FALSE
+}
+
+// Equal reports whether two values are equal: numbers, strings, booleans and
+// times by value, arrays element by element, and anything else by identity.
+func Equal(a, b Object) bool {
+	if IsNumber(a) && IsNumber(b) {
+		return CompareNumbers(a, b) == 0
+	}
+	switch a := a.(type) {
+	case *String:
+		b, ok := b.(*String)
+		return ok && a.Value == b.Value
+	case *Time:
+		b, ok := b.(*Time)
+		return ok && a.Value.Equal(b.Value)
+	case *Array:
+		b, ok := b.(*Array)
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		for i := range a.Elements {
+			if !Equal(a.Elements[i], b.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
 type Boolean struct {
 	Value bool
 }
@@ -109,8 +200,54 @@ func (rv *ReturnValue) Inspect() string {
 	return rv.Value.Inspect()
 }
 
+// Kinds of error, which a program that catches an error can tell apart.
+const (
+	// raised by the language itself, such as a type mismatch
+	RUNTIME_ERROR = "runtime"
+	// raised by a builtin function, such as `json.parse` given bad JSON
+	BUILTIN_ERROR = "builtin"
+)
+
 type Error struct {
-	Message string
+	Message   string
+	Position  token.Position
+	Backtrace Backtrace
+	// Kind says what raised the error. Errors without one are RUNTIME_ERROR.
+	Kind string
+	// Value is the value a program threw, or nil if the error wasn't thrown
+	Value Object
+}
+
+// NewThrownError returns the error a program raises by throwing value. Its
+// message is value's `message` if value is a hash with one, so that programs
+// can throw errors of their own shape.
+func NewThrownError(value Object) *Error {
+	message := value.Inspect()
+	if hash, ok := value.(*Hash); ok {
+		if msg, ok := hash.Get(&String{Value: "message"}); ok && msg.Type() == STRING_TYPE {
+			message = msg.(*String).Value
+		}
+	}
+	return &Error{Message: message, Value: value}
+}
+
+// Caught returns the value a catch block receives for e. That is the value
+// that was thrown, or else a hash with the error's message, kind, line and
+// column.
+func (e *Error) Caught() Object {
+	if e.Value != nil {
+		return e.Value
+	}
+	kind := e.Kind
+	if kind == "" {
+		kind = RUNTIME_ERROR
+	}
+	caught := NewHash()
+	caught.Set(&String{Value: "message"}, &String{Value: e.Message})
+	caught.Set(&String{Value: "kind"}, &String{Value: kind})
+	caught.Set(&String{Value: "line"}, &Integer{Value: int64(e.Position.Line)})
+	caught.Set(&String{Value: "column"}, &Integer{Value: int64(e.Position.Column)})
+	return caught
 }
 
 func (e *Error) Type() ObjectType {
@@ -125,6 +262,7 @@ type Function struct {
 	Env    *Environment
 	Params []*ast.Identifier
 	Body   *ast.BlockStatement
+	Name   string
 }
 
 func (fl *Function) Type() ObjectType {
@@ -141,7 +279,23 @@ func (fl *Function) Inspect() string {
 	return out.String()
 }
 
-type BuiltinFunction func(args ...Object) Object
+// Runtime is what a builtin can ask of the engine running it.
+type Runtime interface {
+	// Call calls fn, which is a function value of the engine or a builtin,
+	// and returns its result. A failing call returns an *Error, which the
+	// builtin should return as it is.
+	Call(fn Object, args ...Object) Object
+	// IO returns the streams the program reads from and writes to.
+	IO() *IO
+	// Rand returns the source of the program's random numbers.
+	Rand() *rand.Rand
+	// Clock returns the program's source of the current time.
+	Clock() Clock
+}
+
+// BuiltinFunction implements a builtin. It may return nil when it has no
+// meaningful result, which callers treat as null.
+type BuiltinFunction func(rt Runtime, args ...Object) Object
 
 type Builtin struct {
 	Fn BuiltinFunction
@@ -190,6 +344,12 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+func (bi *BigInt) HashKey() HashKey {
+	val := fnv.New64a()
+	val.Write([]byte(bi.Value.String()))
+	return HashKey{Type: bi.Type(), Value: val.Sum64()}
+}
+
 func (s *String) HashKey() HashKey {
 	val := fnv.New64a()
 	val.Write([]byte(s.Value))
@@ -197,6 +357,7 @@ func (s *String) HashKey() HashKey {
 }
 
 type Hashable interface {
+	Object
 	HashKey() HashKey
 }
 
@@ -205,8 +366,41 @@ type HashPair struct {
 	Value Object
 }
 
+// Hash maps keys to values, remembering the order keys were first added in.
+// Use NewHash and Set to build one so the order stays in step with Pairs.
 type Hash struct {
 	Pairs map[HashKey]HashPair
+	// Keys lists the keys of Pairs in insertion order
+	Keys []HashKey
+}
+
+func NewHash() *Hash {
+	return &Hash{Pairs: make(map[HashKey]HashPair)}
+}
+
+// Set adds or replaces the value for key. A key that is already present keeps
+// its place in the order.
+func (h *Hash) Set(key Hashable, value Object) {
+	hashKey := key.HashKey()
+	if _, ok := h.Pairs[hashKey]; !ok {
+		h.Keys = append(h.Keys, hashKey)
+	}
+	h.Pairs[hashKey] = HashPair{Key: key, Value: value}
+}
+
+// Get returns the value for key.
+func (h *Hash) Get(key Hashable) (Object, bool) {
+	pair, ok := h.Pairs[key.HashKey()]
+	return pair.Value, ok
+}
+
+// Ordered returns the pairs of the hash in insertion order.
+func (h *Hash) Ordered() []HashPair {
+	pairs := make([]HashPair, len(h.Keys))
+	for i, key := range h.Keys {
+		pairs[i] = h.Pairs[key]
+	}
+	return pairs
 }
 
 func (h *Hash) Type() ObjectType { return HASH_TYPE }
@@ -215,7 +409,7 @@ func (h *Hash) Inspect() string {
 
 	out.WriteString("({")
 	pairs := make([]string, 0, len(h.Pairs))
-	for _, hashPair := range h.Pairs {
+	for _, hashPair := range h.Ordered() {
 		pairs = append(pairs, fmt.Sprintf("%s: %s", hashPair.Key.Inspect(), hashPair.Value.Inspect()))
 	}
 	out.WriteString(strings.Join(pairs, ", "))
@@ -228,9 +422,60 @@ type CompiledFunction struct {
 	NumLocals     int
 	NumParameters int
 	Instructions  code.Instructions
+	SourceMap     code.SourceMap
+	Name          string
 }
 
 func (cf *CompiledFunction) Type() ObjectType { return COMPILED_FUNCTION_TYPE }
 func (cf *CompiledFunction) Inspect() string {
 	return fmt.Sprintf("CompiledFunction: [%p]", cf)
 }
+
+// StackFrame is a single Monkey-level call that was active when a runtime
+// error was raised, along with where in that call execution had got to.
+type StackFrame struct {
+	Function string
+	Args     []string
+	Position token.Position
+	// Elided is set on a placeholder for this many frames left out of the
+	// middle of a very long backtrace
+	Elided int
+}
+
+// maxArgLength caps how much of each argument a stack frame shows, so that
+// large arrays and hashes don't swamp the backtrace.
+const maxArgLength = 32
+
+func NewStackFrame(function string, args []Object, pos token.Position) StackFrame {
+	if function == "" {
+		function = "<anonymous>"
+	}
+	summaries := make([]string, len(args))
+	for i, arg := range args {
+		summary := arg.Inspect()
+		if runes := []rune(summary); len(runes) > maxArgLength {
+			summary = string(runes[:maxArgLength]) + "..."
+		}
+		summaries[i] = summary
+	}
+	return StackFrame{Function: function, Args: summaries, Position: pos}
+}
+
+func (sf StackFrame) String() string {
+	if sf.Elided > 0 {
+		return fmt.Sprintf("...%d frames elided...", sf.Elided)
+	}
+	return fmt.Sprintf("%s(%s)\n\t%s", sf.Function, strings.Join(sf.Args, ", "), sf.Position)
+}
+
+// Backtrace lists the active calls at the point of an error, innermost first.
+type Backtrace []StackFrame
+
+func (bt Backtrace) String() string {
+	var out bytes.Buffer
+	for _, frame := range bt {
+		out.WriteString(frame.String())
+		out.WriteString("\n")
+	}
+	return out.String()
+}
@@ -1,6 +1,11 @@
 package object
 
-import "testing"
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"testing"
+)
 
 func TestStringHashKey(t *testing.T) {
 	hello1 := &String{Value: "Hello World"}
@@ -17,3 +22,155 @@ func TestStringHashKey(t *testing.T) {
 		t.Errorf("strings with different content have same hash keys")
 	}
 }
+
+func TestBigIntHashKey(t *testing.T) {
+	big1, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	big2, _ := new(big.Int).SetString("123456789012345678901234567890", 10)
+	diff, _ := new(big.Int).SetString("-123456789012345678901234567890", 10)
+	if (&BigInt{Value: big1}).HashKey() != (&BigInt{Value: big2}).HashKey() {
+		t.Errorf("big integers with same value have different hash keys")
+	}
+	if (&BigInt{Value: big1}).HashKey() == (&BigInt{Value: diff}).HashKey() {
+		t.Errorf("big integers with different values have same hash keys")
+	}
+}
+
+func TestFloatInspect(t *testing.T) {
+	tests := []struct {
+		value    float64
+		expected string
+	}{
+		{1.5, "1.5"},
+		{2, "2.0"},
+		{-0.25, "-0.25"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+	}
+	for _, tt := range tests {
+		if got := (&Float{Value: tt.value}).Inspect(); got != tt.expected {
+			t.Errorf("wrong Inspect for %v. want=%s, got=%s", tt.value, tt.expected, got)
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	tests := []string{
+		`null`,
+		`[]`,
+		`{}`,
+		`"tab\tquote\" <&> ünïcode"`,
+		`[1,-2,3.5,2.0,1e+21,true,false,null]`,
+		`{"b":1,"a":{"z":[],"y":{}},"c":123456789012345678901234567890}`,
+	}
+	for _, input := range tests {
+		value, err := parseJSON(input)
+		if err != nil {
+			t.Errorf("parseJSON(%q) failed: %s", input, err)
+			continue
+		}
+		enc := &jsonEncoder{}
+		if err := enc.encode(value, "$", 0); err != nil {
+			t.Errorf("encoding %q failed: %s", input, err)
+			continue
+		}
+		if enc.out.String() != input {
+			t.Errorf("round trip changed the JSON. want=%s, got=%s", input, enc.out.String())
+		}
+	}
+}
+
+func TestJSONTypes(t *testing.T) {
+	value, err := parseJSON(`{"i": 9007199254740993, "f": 0.5, "e": 1E2, "big": -99999999999999999999, "t": true, "n": null}`)
+	if err != nil {
+		t.Fatalf("parseJSON failed: %s", err)
+	}
+	hash := value.(*Hash)
+	expected := map[string]ObjectType{
+		"i": INTEGER_TYPE, "f": FLOAT_TYPE, "e": FLOAT_TYPE, "big": BIGINT_TYPE, "t": BOOLEAN_TYPE, "n": NULL_TYPE,
+	}
+	for key, typ := range expected {
+		got, ok := hash.Get(&String{Value: key})
+		if !ok || got.Type() != typ {
+			t.Errorf("wrong value for %q. want type %s, got=%+v", key, typ, got)
+		}
+	}
+	// booleans and null are the shared singletons
+	if got, _ := hash.Get(&String{Value: "t"}); got != TRUE {
+		t.Errorf("true is not TRUE")
+	}
+	if got, _ := hash.Get(&String{Value: "n"}); got != NULL {
+		t.Errorf("null is not NULL")
+	}
+	if got, _ := hash.Get(&String{Value: "i"}); got.(*Integer).Value != 9007199254740993 {
+		t.Errorf("integer lost precision. got=%s", got.Inspect())
+	}
+}
+
+func TestPatternCache(t *testing.T) {
+	first, err := compilePattern("regex.match", `a+b`)
+	if err != nil {
+		t.Fatalf("compilePattern failed: %s", err.Message)
+	}
+	second, _ := compilePattern("regex.match", `a+b`)
+	if first != second {
+		t.Errorf("pattern was compiled twice")
+	}
+
+	for i := 0; i < MAX_CACHED_PATTERNS+10; i++ {
+		compilePattern("regex.match", fmt.Sprintf("x{%d}", i))
+	}
+	if n := len(patternCache.patterns); n > MAX_CACHED_PATTERNS {
+		t.Errorf("cache grew past its limit: %d patterns", n)
+	}
+
+	if _, err := compilePattern("regex.find", `[z-a]`); err == nil || err.Message != "`regex.find` failed: error parsing regexp: invalid character class range: `z-a`" {
+		t.Errorf("wrong error for invalid pattern. got=%+v", err)
+	}
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []struct {
+		left     int64
+		operator string
+		right    int64
+		expected string
+		big      bool
+	}{
+		{1, "+", 2, "3", false},
+		{math.MaxInt64, "+", 1, "9223372036854775808", true},
+		{math.MinInt64, "-", 1, "-9223372036854775809", true},
+		{math.MaxInt64, "*", 2, "18446744073709551614", true},
+		{math.MinInt64, "*", -1, "9223372036854775808", true},
+		{-1, "*", math.MinInt64, "9223372036854775808", true},
+		{math.MinInt64, "/", -1, "9223372036854775808", true},
+		{-7, "/", 2, "-3", false},
+		{math.MinInt64, "+", 0, "-9223372036854775808", false},
+	}
+
+	for _, tt := range tests {
+		result, err := IntegerArithmetic(tt.operator, &Integer{Value: tt.left}, &Integer{Value: tt.right})
+		if err != nil {
+			t.Fatalf("%d %s %d: unexpected error: %s", tt.left, tt.operator, tt.right, err)
+		}
+		if result.Inspect() != tt.expected {
+			t.Errorf("%d %s %d: wrong result. want=%s, got=%s", tt.left, tt.operator, tt.right, tt.expected, result.Inspect())
+		}
+		if _, isBig := result.(*BigInt); isBig != tt.big {
+			t.Errorf("%d %s %d: wrong type. got=%T", tt.left, tt.operator, tt.right, result)
+		}
+	}
+
+	// results that fit again go back to being Integers
+	huge := NegateInteger(&Integer{Value: math.MinInt64})
+	result, err := IntegerArithmetic("-", huge, &Integer{Value: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if i, ok := result.(*Integer); !ok || i.Value != math.MaxInt64 {
+		t.Errorf("expected Integer %d, got=%T (%s)", int64(math.MaxInt64), result, result.Inspect())
+	}
+
+	if _, err := IntegerArithmetic("/", huge, &Integer{Value: 0}); err == nil || err.Error() != "division by zero" {
+		t.Errorf("expected division by zero, got=%v", err)
+	}
+}
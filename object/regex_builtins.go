@@ -0,0 +1,135 @@
+package object
+
+import (
+	"regexp"
+	"sync"
+)
+
+// MAX_CACHED_PATTERNS bounds how many compiled patterns the `regex` builtins
+// keep, so programs that build patterns on the fly don't grow the cache
+// forever.
+const MAX_CACHED_PATTERNS = 256
+
+// patternCache holds compiled patterns by their source. It is shared by every
+// engine, so it is safe for concurrent use.
+var patternCache = struct {
+	sync.Mutex
+	patterns map[string]*regexp.Regexp
+}{patterns: map[string]*regexp.Regexp{}}
+
+// compilePattern returns the compiled form of pattern, compiling it only if
+// it isn't cached. The error carries Go's description of an invalid pattern.
+func compilePattern(name, pattern string) (*regexp.Regexp, *Error) {
+	patternCache.Lock()
+	defer patternCache.Unlock()
+	if re, ok := patternCache.patterns[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, newError("`%s` failed: %s", name, err)
+	}
+	if len(patternCache.patterns) == MAX_CACHED_PATTERNS {
+		// start over rather than track which patterns are in use
+		patternCache.patterns = map[string]*regexp.Regexp{}
+	}
+	patternCache.patterns[pattern] = re
+	return re, nil
+}
+
+// patternArgs checks the arguments of a `regex` builtin, which are a pattern
+// followed by strings, and compiles the pattern.
+func patternArgs(name string, args []Object, numStrings int) (*regexp.Regexp, *Error) {
+	types := []ObjectType{STRING_TYPE}
+	for i := 0; i < numStrings; i++ {
+		types = append(types, STRING_TYPE)
+	}
+	if err := checkArgs(name, args, types...); err != nil {
+		return nil, err
+	}
+	return compilePattern(name, args[0].(*String).Value)
+}
+
+var regexBuiltins = []BuiltinDef{
+	{
+		"match",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			re, err := patternArgs("regex.match", args, 1)
+			if err != nil {
+				return err
+			}
+			return nativeBool(re.MatchString(args[1].(*String).Value))
+		}},
+	},
+	{
+		"find",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			re, err := patternArgs("regex.find", args, 1)
+			if err != nil {
+				return err
+			}
+			str := args[1].(*String).Value
+			loc := re.FindStringSubmatchIndex(str)
+			if loc == nil {
+				return NULL
+			}
+			return matchObject(str, loc)
+		}},
+	},
+	{
+		"findAll",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			re, err := patternArgs("regex.findAll", args, 1)
+			if err != nil {
+				return err
+			}
+			str := args[1].(*String).Value
+			locs := re.FindAllStringSubmatchIndex(str, -1)
+			matches := make([]Object, len(locs))
+			for i, loc := range locs {
+				matches[i] = matchObject(str, loc)
+			}
+			return &Array{Elements: matches}
+		}},
+	},
+	{
+		"replace",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			re, err := patternArgs("regex.replace", args, 2)
+			if err != nil {
+				return err
+			}
+			// $1 or ${name} in the replacement stands for a group's text
+			return &String{Value: re.ReplaceAllString(args[1].(*String).Value, args[2].(*String).Value)}
+		}},
+	},
+	{
+		"split",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			re, err := patternArgs("regex.split", args, 1)
+			if err != nil {
+				return err
+			}
+			return stringArray(re.Split(args[1].(*String).Value, -1))
+		}},
+	},
+}
+
+// matchObject returns a match given by its submatch indexes in str. Without
+// groups it's the matched text; with groups it's an array of the matched text
+// followed by each group's text, or null for a group that didn't take part.
+func matchObject(str string, loc []int) Object {
+	if len(loc) == 2 {
+		return &String{Value: str[loc[0]:loc[1]]}
+	}
+	groups := make([]Object, len(loc)/2)
+	for i := range groups {
+		start, end := loc[2*i], loc[2*i+1]
+		if start < 0 {
+			groups[i] = NULL
+		} else {
+			groups[i] = &String{Value: str[start:end]}
+		}
+	}
+	return &Array{Elements: groups}
+}
@@ -0,0 +1,276 @@
+package object
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var stringBuiltins = []BuiltinDef{
+	{
+		"split",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("split", args, STRING_TYPE, STRING_TYPE); err != nil {
+				return err
+			}
+			// an empty separator splits the string into its characters
+			parts := strings.Split(args[0].(*String).Value, args[1].(*String).Value)
+			return stringArray(parts)
+		}},
+	},
+	{
+		"join",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("join", args, ARRAY_TYPE, STRING_TYPE); err != nil {
+				return err
+			}
+			elements := args[0].(*Array).Elements
+			parts := make([]string, len(elements))
+			for i, elem := range elements {
+				str, ok := elem.(*String)
+				if !ok {
+					return newError("argument 1 to `join` must only contain STRING, got %s at index %d", elem.Type(), i)
+				}
+				parts[i] = str.Value
+			}
+			return &String{Value: strings.Join(parts, args[1].(*String).Value)}
+		}},
+	},
+	{
+		"trim",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("trim", args, STRING_TYPE); err != nil {
+				return err
+			}
+			return &String{Value: strings.TrimSpace(args[0].(*String).Value)}
+		}},
+	},
+	{
+		"contains",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			switch haystack := args[0].(type) {
+			case *String:
+				if err := checkArg("contains", 1, args[1], STRING_TYPE); err != nil {
+					return err
+				}
+				return nativeBool(strings.Contains(haystack.Value, args[1].(*String).Value))
+			case *Array:
+				return nativeBool(indexOfElement(haystack, args[1]) >= 0)
+			default:
+				return newError("argument 1 to `contains` must be STRING or ARRAY, got %s", args[0].Type())
+			}
+		}},
+	},
+	{
+		"startsWith",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("startsWith", args, STRING_TYPE, STRING_TYPE); err != nil {
+				return err
+			}
+			return nativeBool(strings.HasPrefix(args[0].(*String).Value, args[1].(*String).Value))
+		}},
+	},
+	{
+		"endsWith",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("endsWith", args, STRING_TYPE, STRING_TYPE); err != nil {
+				return err
+			}
+			return nativeBool(strings.HasSuffix(args[0].(*String).Value, args[1].(*String).Value))
+		}},
+	},
+	{
+		"replace",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("replace", args, STRING_TYPE, STRING_TYPE, STRING_TYPE); err != nil {
+				return err
+			}
+			return &String{Value: strings.ReplaceAll(args[0].(*String).Value, args[1].(*String).Value, args[2].(*String).Value)}
+		}},
+	},
+	{
+		"upper",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("upper", args, STRING_TYPE); err != nil {
+				return err
+			}
+			return &String{Value: strings.ToUpper(args[0].(*String).Value)}
+		}},
+	},
+	{
+		"lower",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("lower", args, STRING_TYPE); err != nil {
+				return err
+			}
+			return &String{Value: strings.ToLower(args[0].(*String).Value)}
+		}},
+	},
+	{
+		"indexOf",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			switch haystack := args[0].(type) {
+			case *String:
+				if err := checkArg("indexOf", 1, args[1], STRING_TYPE); err != nil {
+					return err
+				}
+				// strings are indexed by character, so the byte offset is
+				// turned into a character index
+				i := strings.Index(haystack.Value, args[1].(*String).Value)
+				if i > 0 {
+					i = utf8.RuneCountInString(haystack.Value[:i])
+				}
+				return &Integer{Value: int64(i)}
+			case *Array:
+				return &Integer{Value: int64(indexOfElement(haystack, args[1]))}
+			default:
+				return newError("argument 1 to `indexOf` must be STRING or ARRAY, got %s", args[0].Type())
+			}
+		}},
+	},
+	{
+		"substr",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) != 2 && len(args) != 3 {
+				return newError("wrong number of arguments. got=%d, want=2 or 3", len(args))
+			}
+			types := []ObjectType{STRING_TYPE, INTEGER_TYPE, INTEGER_TYPE}
+			for i, arg := range args {
+				if err := checkArg("substr", i, arg, types[i]); err != nil {
+					return err
+				}
+			}
+
+			chars := []rune(args[0].(*String).Value)
+			start := args[1].(*Integer).Value
+			length := int64(len(chars)) - start
+			if len(args) == 3 {
+				length = args[2].(*Integer).Value
+			}
+			if start < 0 || length < 0 || start > int64(len(chars)) || length > int64(len(chars))-start {
+				return newError("substr out of bounds: size=%d, start=%d, length=%d", len(chars), start, length)
+			}
+			return &String{Value: string(chars[start : start+length])}
+		}},
+	},
+	{
+		"repeat",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("repeat", args, STRING_TYPE, INTEGER_TYPE); err != nil {
+				return err
+			}
+			str, count := args[0].(*String).Value, args[1].(*Integer).Value
+			if count < 0 {
+				return newError("argument 2 to `repeat` must not be negative, got %d", count)
+			}
+			if str != "" && count > MAX_STRING_LENGTH/int64(len(str)) {
+				return newError("`repeat` result too long: strings can be at most %d bytes", MAX_STRING_LENGTH)
+			}
+			return &String{Value: strings.Repeat(str, int(count))}
+		}},
+	},
+	{
+		"chars",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("chars", args, STRING_TYPE); err != nil {
+				return err
+			}
+			str := args[0].(*String).Value
+			chars := make([]Object, 0, len(str))
+			for _, r := range str {
+				chars = append(chars, &String{Value: string(r)})
+			}
+			return &Array{Elements: chars}
+		}},
+	},
+	{"format", &Builtin{Fn: format}},
+	{"sprintf", &Builtin{Fn: format}},
+}
+
+// MAX_STRING_LENGTH bounds the strings `repeat` will build. The result needs
+// count times the length of its argument up front, which for a large count is
+// more than the process can allocate.
+const MAX_STRING_LENGTH = 1 << 30
+
+// format implements `format` and `sprintf`. The verbs are %s and %v for any
+// value's Inspect form, %d for integers, %q for a quoted string and %% for a
+// literal percent sign.
+func format(rt Runtime, args ...Object) Object {
+	if len(args) == 0 {
+		return newError("wrong number of arguments. got=0, want at least 1")
+	}
+	if err := checkArg("format", 0, args[0], STRING_TYPE); err != nil {
+		return err
+	}
+
+	template := args[0].(*String).Value
+	values := args[1:]
+	var out strings.Builder
+	next := 0
+	for i := 0; i < len(template); i++ {
+		if template[i] != '%' {
+			out.WriteByte(template[i])
+			continue
+		}
+		i++
+		if i == len(template) {
+			return newError("format string ends with a lone %%")
+		}
+		verb := template[i]
+		if verb == '%' {
+			out.WriteByte('%')
+			continue
+		}
+		if next == len(values) {
+			return newError("not enough arguments for format string: %%%c has no value", verb)
+		}
+		value := values[next]
+		next++
+		switch verb {
+		case 's', 'v':
+			out.WriteString(value.Inspect())
+		case 'd':
+			if !IsInteger(value) {
+				return newError("%%d expects INTEGER, got %s", value.Type())
+			}
+			out.WriteString(value.Inspect())
+		case 'q':
+			str, ok := value.(*String)
+			if !ok {
+				return newError("%%q expects STRING, got %s", value.Type())
+			}
+			out.WriteString(strconv.Quote(str.Value))
+		default:
+			return newError("unknown format verb %%%c", verb)
+		}
+	}
+	if next != len(values) {
+		return newError("too many arguments for format string: %d unused", len(values)-next)
+	}
+	return &String{Value: out.String()}
+}
+
+func stringArray(strs []string) *Array {
+	elements := make([]Object, len(strs))
+	for i, str := range strs {
+		elements[i] = &String{Value: str}
+	}
+	return &Array{Elements: elements}
+}
+
+// indexOfElement returns the index of the first element of arr equal to value,
+// or -1 if there isn't one.
+func indexOfElement(arr *Array, value Object) int {
+	for i, elem := range arr.Elements {
+		if Equal(elem, value) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,134 @@
+package object
+
+import (
+	"math"
+	"time"
+)
+
+// Time is an instant in time, made by the `time` builtins.
+type Time struct {
+	Value time.Time
+}
+
+func (t *Time) Type() ObjectType {
+	return TIME_TYPE
+}
+
+func (t *Time) Inspect() string {
+	return t.Value.Format(time.RFC3339Nano)
+}
+
+// Clock tells the `time` builtins the current time. Hosts replace it to make
+// runs deterministic.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the Clock engines use unless the host provides one.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// FixedClock is a Clock that is stopped at a single instant, for tests and
+// replays.
+type FixedClock time.Time
+
+func (c FixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+var timeBuiltins = []BuiltinDef{
+	// layouts are Go's, written as the reference time Mon Jan 2 15:04:05 MST 2006
+	{"datetime", &String{Value: time.RFC3339}},
+	{"date", &String{Value: time.DateOnly}},
+	{
+		"now",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("time.now", args); err != nil {
+				return err
+			}
+			return &Time{Value: rt.Clock().Now()}
+		}},
+	},
+	{
+		"parse",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("time.parse", args, STRING_TYPE, STRING_TYPE); err != nil {
+				return err
+			}
+			// times without a zone are taken to be in UTC
+			t, err := time.Parse(args[0].(*String).Value, args[1].(*String).Value)
+			if err != nil {
+				return newError("`time.parse` failed: %s", err)
+			}
+			return &Time{Value: t}
+		}},
+	},
+	{
+		"format",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) == 1 {
+				args = append(args, &String{Value: time.RFC3339})
+			}
+			if err := checkArgs("time.format", args, TIME_TYPE, STRING_TYPE); err != nil {
+				return err
+			}
+			return &String{Value: args[0].(*Time).Value.Format(args[1].(*String).Value)}
+		}},
+	},
+	{
+		"add",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if len(args) != 2 {
+				return newError("wrong number of arguments. got=%d, want=2", len(args))
+			}
+			if err := checkArg("time.add", 0, args[0], TIME_TYPE); err != nil {
+				return err
+			}
+			d, err := duration(args[1])
+			if err != nil {
+				return err
+			}
+			return &Time{Value: args[0].(*Time).Value.Add(d)}
+		}},
+	},
+	{
+		"diff",
+		&Builtin{Fn: func(rt Runtime, args ...Object) Object {
+			if err := checkArgs("time.diff", args, TIME_TYPE, TIME_TYPE); err != nil {
+				return err
+			}
+			// in milliseconds, like the durations `time.add` takes; Sub
+			// saturates at about 292 years, so very distant times are
+			// subtracted in whole milliseconds instead
+			a, b := args[0].(*Time).Value, args[1].(*Time).Value
+			d := a.Sub(b)
+			if d == math.MaxInt64 || d == math.MinInt64 {
+				return &Integer{Value: a.UnixMilli() - b.UnixMilli()}
+			}
+			return &Integer{Value: d.Milliseconds()}
+		}},
+	},
+}
+
+// duration converts the duration argument of `time.add`, which is a number
+// of milliseconds or a Go duration string such as "1h30m".
+func duration(arg Object) (time.Duration, *Error) {
+	switch arg := arg.(type) {
+	case *Integer:
+		if arg.Value > math.MaxInt64/int64(time.Millisecond) || arg.Value < math.MinInt64/int64(time.Millisecond) {
+			return 0, newError("duration of %d milliseconds is out of range", arg.Value)
+		}
+		return time.Duration(arg.Value) * time.Millisecond, nil
+	case *String:
+		d, err := time.ParseDuration(arg.Value)
+		if err != nil {
+			return 0, newError("argument 2 to `time.add` is not a duration: %s", err)
+		}
+		return d, nil
+	default:
+		return 0, newError("argument 2 to `time.add` must be INTEGER or STRING, got %s", arg.Type())
+	}
+}
@@ -1,7 +1,9 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"math/big"
 	"strconv"
 
 	"interpego/ast"
@@ -32,6 +34,7 @@ var precedences = map[token.TokenType]int{
 	token.ASTERISK: PRODUCT,
 	token.LPAREN:   CALL,
 	token.LBRACKET: INDEX,
+	token.DOT:      INDEX,
 }
 
 type Error string
@@ -39,6 +42,8 @@ type Error string
 type Parser struct {
 	lexer  *lexer.Lexer
 	errors []string
+	// number of the lexer's errors already copied into errors
+	lexerErrors int
 
 	curToken token.Token
 	// need this to look ahead to see if an expression is complete for example
@@ -48,6 +53,13 @@ type Parser struct {
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
+
+	// whether each function literal being parsed, innermost last, has a
+	// defer statement
+	defers []bool
+	// problems that don't stop the program from running, like a match that
+	// can't handle every value
+	warnings []string
 }
 
 func New(lexer *lexer.Lexer) *Parser {
@@ -62,6 +74,7 @@ func New(lexer *lexer.Lexer) *Parser {
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
 	p.registerPrefix(token.STRING, p.parseString)
+	p.registerPrefix(token.INTERP_START, p.parseInterpolatedString)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
@@ -72,8 +85,12 @@ func New(lexer *lexer.Lexer) *Parser {
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.FOR, p.parseForLoop)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
+	p.registerPrefix(token.IMPORT, p.parseImportExpression)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.DOT, p.parseMemberExpression)
 	p.registerInfix(token.EQ, p.parseInfixExpression)
 	p.registerInfix(token.NOT_EQ, p.parseInfixExpression)
 	p.registerInfix(token.LT, p.parseInfixExpression)
@@ -90,14 +107,33 @@ func (p *Parser) Errors() []string {
 	return p.errors
 }
 
+// Warnings returns the problems found in the program that don't stop it from
+// running.
+func (p *Parser) Warnings() []string {
+	return p.warnings
+}
+
+// warnf records a warning at pos.
+func (p *Parser) warnf(pos token.Position, format string, a ...interface{}) {
+	p.warnings = append(p.warnings, fmt.Sprintf("%s: %s", pos, fmt.Sprintf(format, a...)))
+}
+
+// errorf records a parse error at pos.
+func (p *Parser) errorf(pos token.Position, format string, a ...interface{}) {
+	p.errors = append(p.errors, fmt.Sprintf("%s: %s", pos, fmt.Sprintf(format, a...)))
+}
+
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %q, got %q instead", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	p.errorf(p.peekToken.Position, "expected next token to be %q, got %q instead", t, p.peekToken.Type)
 }
 
 func (p *Parser) nextToken() {
 	p.curToken = p.peekToken
 	p.peekToken = p.lexer.NextToken()
+	for _, err := range p.lexer.Errors()[p.lexerErrors:] {
+		p.errorf(err.Position, "%s", err.Message)
+	}
+	p.lexerErrors = len(p.lexer.Errors())
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
@@ -122,6 +158,10 @@ func (p *Parser) parseStatement() ast.Statement {
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.THROW:
+		return p.parseThrowStatement()
+	case token.DEFER:
+		return p.parseDeferStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
@@ -149,6 +189,40 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return &stmt
 }
 
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+	stmt := ast.ThrowStatement{Token: p.curToken}
+
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return &stmt
+}
+
+func (p *Parser) parseDeferStatement() ast.Statement {
+	stmt := ast.DeferStatement{Token: p.curToken}
+
+	p.nextToken()
+	call, ok := p.parseExpression(LOWEST).(*ast.CallExpression)
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	if !ok {
+		p.errorf(stmt.Token.Position, "defer needs a function call")
+		return nil
+	}
+	if len(p.defers) == 0 {
+		p.errorf(stmt.Token.Position, "defer outside a function body")
+		return nil
+	}
+	stmt.Call = call
+	p.defers[len(p.defers)-1] = true
+
+	return &stmt
+}
+
 func (p *Parser) curTokenIs(t token.TokenType) bool {
 	return p.curToken.Type == t
 }
@@ -170,6 +244,9 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 	}
 	p.nextToken()
 	statement.Value = p.parseExpression(LOWEST)
+	if fn, ok := statement.Value.(*ast.FunctionLiteral); ok {
+		fn.Name = statement.Name.Value
+	}
 	p.nextToken()
 
 	return &statement
@@ -219,10 +296,17 @@ func (p *Parser) parseIdentifier() ast.Expression {
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.curToken}
 
+	// base 0 takes care of the 0x, 0o and 0b prefixes and _ separators
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		// too large for an int64, so the literal is a BigInt
+		if big, ok := new(big.Int).SetString(p.curToken.Literal, 0); ok {
+			lit.Big = big
+			return lit
+		}
+	}
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.errorf(p.curToken.Position, "invalid integer literal %q", p.curToken.Literal)
 		return nil
 	}
 	lit.Value = value
@@ -233,6 +317,32 @@ func (p *Parser) parseString() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
 
+func (p *Parser) parseInterpolatedString() ast.Expression {
+	str := &ast.InterpolatedString{Token: p.curToken, Strings: []string{p.curToken.Literal}}
+	for {
+		p.nextToken()
+		if p.curTokenIs(token.INTERP_MID) || p.curTokenIs(token.INTERP_END) {
+			p.errorf(p.curToken.Position, "empty interpolation in string")
+			return nil
+		}
+		value := p.parseExpression(LOWEST)
+		if value == nil {
+			return nil
+		}
+		str.Values = append(str.Values, value)
+
+		if !p.peekTokenIs(token.INTERP_MID) && !p.peekTokenIs(token.INTERP_END) {
+			p.errorf(p.peekToken.Position, "expected } to close interpolation, got %q instead", p.peekToken.Type)
+			return nil
+		}
+		p.nextToken()
+		str.Strings = append(str.Strings, p.curToken.Literal)
+		if p.curTokenIs(token.INTERP_END) {
+			return str
+		}
+	}
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.BooleanLiteral{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
 }
@@ -245,8 +355,11 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse fn found for %q", t)
-	p.errors = append(p.errors, msg)
+	if t == token.ILLEGAL {
+		p.errorf(p.curToken.Position, "illegal character %q", p.curToken.Literal)
+		return
+	}
+	p.errorf(p.curToken.Position, "no prefix parse fn found for %q", t)
 }
 
 func (p *Parser) peekPrecedence() int {
@@ -324,6 +437,233 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return ifExp
 }
 
+func (p *Parser) parseTryExpression() ast.Expression {
+	tryExp := &ast.TryExpression{Token: p.curToken}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	tryExp.Block = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+		if !p.expectPeek(token.LPAREN) || !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		tryExp.CatchParam = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		if !p.expectPeek(token.RPAREN) || !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		tryExp.Catch = p.parseBlockStatement()
+	}
+
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+		tryExp.Finally = p.parseBlockStatement()
+	}
+
+	if tryExp.Catch == nil && tryExp.Finally == nil {
+		p.errorf(tryExp.Token.Position, "try needs a catch or finally block")
+		return nil
+	}
+	return tryExp
+}
+
+func (p *Parser) parseMatchExpression() ast.Expression {
+	match := &ast.MatchExpression{Token: p.curToken}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	match.Value = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.RPAREN) || !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		arm := p.parseMatchArm()
+		if arm == nil {
+			return nil
+		}
+		match.Arms = append(match.Arms, arm)
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+	p.nextToken()
+
+	if len(match.Arms) == 0 {
+		p.errorf(match.Token.Position, "match needs at least one arm")
+		return nil
+	}
+	p.checkBooleanMatch(match)
+	return match
+}
+
+func (p *Parser) parseMatchArm() *ast.MatchArm {
+	arm := &ast.MatchArm{Pattern: p.parsePattern()}
+	if arm.Pattern == nil {
+		return nil
+	}
+	if p.peekTokenIs(token.IF) {
+		p.nextToken()
+		p.nextToken()
+		arm.Guard = p.parseExpression(LOWEST)
+	}
+	if !p.expectPeek(token.ARROW) {
+		return nil
+	}
+	p.nextToken()
+	arm.Result = p.parseExpression(LOWEST)
+	return arm
+}
+
+func (p *Parser) parsePattern() ast.Pattern {
+	switch p.curToken.Type {
+	case token.IDENT:
+		if p.curToken.Literal == "_" {
+			return &ast.WildcardPattern{Token: p.curToken}
+		}
+		return &ast.BindingPattern{Name: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}}
+	case token.INT, token.STRING, token.TRUE, token.FALSE:
+		return p.parseLiteralPattern()
+	case token.MINUS:
+		if !p.peekTokenIs(token.INT) {
+			break
+		}
+		return p.parseLiteralPattern()
+	case token.LBRACKET:
+		return p.parseArrayPattern()
+	case token.LBRACE:
+		return p.parseHashPattern()
+	}
+	p.errorf(p.curToken.Position, "expected a pattern, got %q", p.curToken.Type)
+	return nil
+}
+
+// parseLiteralPattern parses an integer, which may be negative, a string or a
+// boolean.
+func (p *Parser) parseLiteralPattern() ast.Pattern {
+	var value ast.Expression
+	switch p.curToken.Type {
+	case token.MINUS:
+		value = p.parsePrefixExpression()
+	case token.INT:
+		value = p.parseIntegerLiteral()
+	case token.STRING:
+		value = p.parseString()
+	default:
+		value = p.parseBoolean()
+	}
+	if value == nil {
+		return nil
+	}
+	return &ast.LiteralPattern{Value: value}
+}
+
+func (p *Parser) parseArrayPattern() ast.Pattern {
+	array := &ast.ArrayPattern{Token: p.curToken}
+	for !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		if p.curTokenIs(token.ELLIPSIS) {
+			array.HasRest = true
+			if p.peekTokenIs(token.IDENT) {
+				p.nextToken()
+				array.Rest = p.parsePattern()
+			}
+			// the rest of the array can only come last
+			break
+		}
+		elem := p.parsePattern()
+		if elem == nil {
+			return nil
+		}
+		array.Elements = append(array.Elements, elem)
+		if !p.peekTokenIs(token.RBRACKET) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+	return array
+}
+
+func (p *Parser) parseHashPattern() ast.Pattern {
+	hash := &ast.HashPattern{Token: p.curToken}
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		if !p.curTokenIs(token.STRING) && !p.curTokenIs(token.INT) && !p.curTokenIs(token.TRUE) && !p.curTokenIs(token.FALSE) {
+			p.errorf(p.curToken.Position, "expected a literal key, got %q", p.curToken.Type)
+			return nil
+		}
+		key := p.parseLiteralPattern()
+		if key == nil || !p.expectPeek(token.COLON) {
+			return nil
+		}
+		p.nextToken()
+		value := p.parsePattern()
+		if value == nil {
+			return nil
+		}
+		hash.Keys = append(hash.Keys, key.(*ast.LiteralPattern).Value)
+		hash.Values = append(hash.Values, value)
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+	p.nextToken()
+	return hash
+}
+
+// checkBooleanMatch warns about a match on a boolean that doesn't handle both
+// true and false. A match is taken to be on a boolean if its value is a
+// comparison or negation, or one of its patterns is true or false. Arms with
+// guards don't count, since they may not match.
+func (p *Parser) checkBooleanMatch(match *ast.MatchExpression) {
+	boolean := isBooleanExpression(match.Value)
+	handled := map[bool]bool{}
+	for _, arm := range match.Arms {
+		switch pattern := arm.Pattern.(type) {
+		case *ast.WildcardPattern, *ast.BindingPattern:
+			if arm.Guard == nil {
+				return
+			}
+		case *ast.LiteralPattern:
+			if lit, ok := pattern.Value.(*ast.BooleanLiteral); ok {
+				boolean = true
+				handled[lit.Value] = handled[lit.Value] || arm.Guard == nil
+			}
+		}
+	}
+	if !boolean {
+		return
+	}
+	for _, value := range []bool{true, false} {
+		if !handled[value] {
+			p.warnf(match.Token.Position, "match on a boolean doesn't handle %t", value)
+		}
+	}
+}
+
+func isBooleanExpression(exp ast.Expression) bool {
+	switch exp := exp.(type) {
+	case *ast.BooleanLiteral:
+		return true
+	case *ast.PrefixExpression:
+		return exp.Operator == "!"
+	case *ast.InfixExpression:
+		switch exp.Operator {
+		case "==", "!=", "<", ">":
+			return true
+		}
+	}
+	return false
+}
+
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	stmt := &ast.BlockStatement{Token: p.curToken, Statements: []ast.Statement{}}
 	p.nextToken()
@@ -351,11 +691,50 @@ func (p *Parser) parseFunctionLiteral() ast.Expression {
 		return nil
 	}
 
+	p.defers = append(p.defers, false)
 	fn.FunctionBody = p.parseBlockStatement()
+	deferred := p.defers[len(p.defers)-1]
+	p.defers = p.defers[:len(p.defers)-1]
+	// the deferred calls run after the function's result is known, so
+	// none of its calls is the last thing it does
+	if !deferred {
+		markTailCalls(fn.FunctionBody, true)
+	}
 
 	return fn
 }
 
+// markTailCalls flags the calls in block whose value the enclosing function
+// returns directly. tail is set when the block's own value is returned. Loop
+// bodies are left alone, since they don't produce the function's result.
+func markTailCalls(block *ast.BlockStatement, tail bool) {
+	if block == nil {
+		return
+	}
+	for i, stmt := range block.Statements {
+		switch stmt := stmt.(type) {
+		case *ast.ReturnStatement:
+			markTailExpression(stmt.ReturnValue, true)
+		case *ast.ExpressionStatement:
+			markTailExpression(stmt.Expression, tail && i == len(block.Statements)-1)
+		}
+	}
+}
+
+func markTailExpression(exp ast.Expression, tail bool) {
+	switch exp := exp.(type) {
+	case *ast.CallExpression:
+		exp.Tail = tail
+	case *ast.IfExpression:
+		markTailCalls(exp.Consequence, tail)
+		markTailCalls(exp.Alternative, tail)
+	case *ast.MatchExpression:
+		for _, arm := range exp.Arms {
+			markTailExpression(arm.Result, tail)
+		}
+	}
+}
+
 func (p *Parser) parseForLoop() ast.Expression {
 	forLoop := &ast.ForLoop{Token: p.curToken}
 
@@ -393,8 +772,7 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 	}
 
 	if !p.curTokenIs(token.RPAREN) {
-		msg := fmt.Sprintf("expected RPAREN, got=%q", p.curToken.Type)
-		p.errors = append(p.errors, msg)
+		p.errorf(p.curToken.Position, "expected RPAREN, got=%q", p.curToken.Type)
 		return nil
 	}
 
@@ -432,6 +810,29 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	return indexExpression
 }
 
+func (p *Parser) parseImportExpression() ast.Expression {
+	imp := &ast.ImportExpression{Token: p.curToken}
+	if !p.expectPeek(token.STRING) {
+		return nil
+	}
+	imp.Path = p.curToken.Literal
+	return imp
+}
+
+// parseMemberExpression parses `left.name`, which is shorthand for
+// `left["name"]`, so that hashes can be used as modules and records.
+func (p *Parser) parseMemberExpression(left ast.Expression) ast.Expression {
+	member := &ast.IndexExpression{Token: p.curToken, Left: left}
+	// keywords are names too after a dot, so that `regex.match` still works
+	if token.LookupIdent(p.peekToken.Literal) == p.peekToken.Type {
+		p.nextToken()
+	} else if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	member.Index = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+	return member
+}
+
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{Token: p.curToken}
 	pairs := make(map[ast.Expression]ast.Expression)
@@ -443,6 +844,7 @@ func (p *Parser) parseHashLiteral() ast.Expression {
 		p.nextToken()
 		rhs := p.parseExpression(LOWEST)
 		pairs[lhs] = rhs
+		hash.Keys = append(hash.Keys, lhs)
 
 		if p.peekTokenIs(token.COMMA) {
 			p.nextToken()
@@ -2,6 +2,7 @@ package parser
 
 import (
 	"fmt"
+	"reflect"
 	"testing"
 
 	"interpego/ast"
@@ -220,6 +221,14 @@ func TestOperatorPrecedeceParsing(t *testing.T) {
 			"-a * b",
 			"((-a) * b)",
 		},
+		{
+			"a.b.c(1) + d.e[0]",
+			"(((a.b).c)(1) + ((d.e)[0]))",
+		},
+		{
+			`import "lib/util".name`,
+			`(import "lib/util".name)`,
+		},
 		{
 			"!-a",
 			"(!(-a))",
@@ -731,6 +740,44 @@ func testStringLiteral(t *testing.T, expression ast.Expression, expected string)
 	return true
 }
 
+func TestIntegerLiteralBases(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"1_000_000", 1000000},
+		{"0xff", 255},
+		{"0XDead_Beef", 0xdeadbeef},
+		{"0o17", 15},
+		{"0b1010_1010", 170},
+		{"9223372036854775807", 9223372036854775807},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		testIntegerLiteral(t, stmt.Expression, tt.expected)
+	}
+}
+
+func TestBigIntegerLiteral(t *testing.T) {
+	p := New(lexer.New("0x1_0000_0000_0000_0000"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	lit, ok := stmt.Expression.(*ast.IntegerLiteral)
+	if !ok {
+		t.Fatalf("exp not *ast.IntegerLiteral. got=%T", stmt.Expression)
+	}
+	if lit.Big == nil || lit.Big.String() != "18446744073709551616" {
+		t.Errorf("lit.Big wrong. got=%v", lit.Big)
+	}
+}
+
 func TestStringLiteralExpression(t *testing.T) {
 	input := `"thingy";`
 	l := lexer.New(input)
@@ -748,6 +795,42 @@ func TestStringLiteralExpression(t *testing.T) {
 	testStringLiteral(t, stmt.Expression, "thingy")
 }
 
+func TestInterpolatedStringExpression(t *testing.T) {
+	input := `"a ${x + 1} b ${f(y)}";`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program does not have the right amount of statements. expected 1, got %d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not an ast.ExpressionStatement. got=%T", program.Statements[0])
+	}
+	str, ok := stmt.Expression.(*ast.InterpolatedString)
+	if !ok {
+		t.Fatalf("expression is not an ast.InterpolatedString. got=%T", stmt.Expression)
+	}
+	expectedStrings := []string{"a ", " b ", ""}
+	if len(str.Strings) != len(expectedStrings) {
+		t.Fatalf("wrong number of strings. expected=%q, got=%q", expectedStrings, str.Strings)
+	}
+	for i, s := range expectedStrings {
+		if str.Strings[i] != s {
+			t.Errorf("strings[%d] wrong. expected=%q, got=%q", i, s, str.Strings[i])
+		}
+	}
+	if len(str.Values) != 2 {
+		t.Fatalf("wrong number of values. expected 2, got %d", len(str.Values))
+	}
+	testInfixExpression(t, str.Values[0], "x", "+", 1)
+	if str.String() != `"a ${(x + 1)} b ${f(y)}"` {
+		t.Errorf("wrong String(). got=%s", str.String())
+	}
+}
+
 func TestArrayLiteralExpression(t *testing.T) {
 	input := `["hello", 1, 2, fn(x) { x * x }, if (1 == 1) { return false; } else {return true; }, 1 + 1];`
 	l := lexer.New(input)
@@ -796,6 +879,152 @@ func TestParsingIndexExpressions(t *testing.T) {
 	}
 }
 
+func TestParsingMemberExpressions(t *testing.T) {
+	p := New(lexer.New("config.name"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	// a member is an index with its name as a string
+	member, ok := stmt.Expression.(*ast.IndexExpression)
+	if !ok {
+		t.Fatalf("exp not *ast.IndexExpression. got=%T", stmt.Expression)
+	}
+	if !testIdentifier(t, member.Left, "config") {
+		return
+	}
+	name, ok := member.Index.(*ast.StringLiteral)
+	if !ok || name.Value != "name" {
+		t.Fatalf("member.Index is not a StringLiteral \"name\". got=%T (%+v)", member.Index, member.Index)
+	}
+
+	// keywords can be member names
+	p = New(lexer.New("regex.match"))
+	program = p.ParseProgram()
+	checkParserErrors(t, p)
+	if program.String() != "(regex.match)" {
+		t.Errorf("wrong program. got=%q", program.String())
+	}
+}
+
+func TestParsingTryExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"try { f() } catch (e) { e }", "try {f()} catch (e) {e}"},
+		{"try { f() } finally { g() }", "try {f()} finally {g()}"},
+		{"let x = try { 1 } catch (err) { 2 } finally { 3 };", "let x = try {1} catch (err) {2} finally {3};"},
+		{"throw {\"message\": m}", "throw {message: m};"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if program.String() != tt.expected {
+			t.Errorf("wrong program for %q. want=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+
+	p := New(lexer.New("try { f() } catch (e) { e }"))
+	stmt := p.ParseProgram().Statements[0].(*ast.ExpressionStatement)
+	tryExp, ok := stmt.Expression.(*ast.TryExpression)
+	if !ok {
+		t.Fatalf("exp not *ast.TryExpression. got=%T", stmt.Expression)
+	}
+	if tryExp.CatchParam.Value != "e" || tryExp.Finally != nil {
+		t.Errorf("wrong try expression. got=%+v", tryExp)
+	}
+}
+
+func TestParsingDeferStatements(t *testing.T) {
+	p := New(lexer.New("fn() { defer close(f, 1); g() }"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	if program.String() != "fn() {defer close(f, 1);g()}" {
+		t.Errorf("wrong program. got=%q", program.String())
+	}
+
+	fn := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	stmt, ok := fn.FunctionBody.Statements[0].(*ast.DeferStatement)
+	if !ok {
+		t.Fatalf("stmt not *ast.DeferStatement. got=%T", fn.FunctionBody.Statements[0])
+	}
+	if stmt.Call.Function.String() != "close" || len(stmt.Call.Arguments) != 2 {
+		t.Errorf("wrong deferred call. got=%s", stmt.Call)
+	}
+}
+
+func TestParsingMatchExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`match (x) { 1 => "one", -1 => "minus one", _ => "other" }`, `match (x) {1 => one, (-1) => minus one, _ => other}`},
+		{`match (x) { n if n > 1 => n, }`, `match (x) {n if (n > 1) => n}`},
+		{`match (xs) { [] => 0, [x] => x, [h, ...t] => h, [_, ...] => 1 }`, `match (xs) {[] => 0, [x] => x, [h, ...t] => h, [_, ...] => 1}`},
+		{`match (s) { {"type": "circle", "r": r} => r, {1: [x]} => x }`, `match (s) {{type: circle, r: r} => r, {1: [x]} => x}`},
+		{`match (b) { true => 1, false => 0 } + 1`, `(match (b) {true => 1, false => 0} + 1)`},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if program.String() != tt.expected {
+			t.Errorf("wrong program for %s. want=%q, got=%q", tt.input, tt.expected, program.String())
+		}
+	}
+
+	p := New(lexer.New(`match (x) { [a, ...b] if a => b }`))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+	match, ok := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.MatchExpression)
+	if !ok {
+		t.Fatalf("exp not *ast.MatchExpression. got=%T", program.Statements[0])
+	}
+	array, ok := match.Arms[0].Pattern.(*ast.ArrayPattern)
+	if !ok {
+		t.Fatalf("pattern not *ast.ArrayPattern. got=%T", match.Arms[0].Pattern)
+	}
+	if len(array.Elements) != 1 || !array.HasRest {
+		t.Errorf("wrong array pattern. got=%s", array)
+	}
+	if rest, ok := array.Rest.(*ast.BindingPattern); !ok || rest.Name.Value != "b" {
+		t.Errorf("wrong rest pattern. got=%s", array.Rest)
+	}
+	if !testIdentifier(t, match.Arms[0].Guard, "a") {
+		return
+	}
+}
+
+func TestMatchWarnings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{`match (x) { true => 1 }`, []string{"1:1: match on a boolean doesn't handle false"}},
+		{`match (x > 1) { false => 1 }`, []string{"1:1: match on a boolean doesn't handle true"}},
+		{`match (!x) { true if y => 1 }`, []string{
+			"1:1: match on a boolean doesn't handle true",
+			"1:1: match on a boolean doesn't handle false",
+		}},
+		{`match (x == 1) { true => 1, false => 2 }`, nil},
+		{`match (x < 1) { true => 1, b => 2 }`, nil},
+		{`match (x < 1) { true => 1, _ if y => 2 }`, []string{"1:1: match on a boolean doesn't handle false"}},
+		{`match (x) { 1 => 1 }`, nil},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		checkParserErrors(t, p)
+		if !reflect.DeepEqual(p.Warnings(), tt.expected) {
+			t.Errorf("wrong warnings for %s. want=%q, got=%q", tt.input, tt.expected, p.Warnings())
+		}
+	}
+}
+
 func TestHashLiteralExpression(t *testing.T) {
 	input := `{"key1": "value1", "key2": "value2", 1: 2, "key3": [1, 2, 3]}`
 	l := lexer.New(input)
@@ -924,3 +1153,117 @@ func TestParsingForLoops(t *testing.T) {
 		t.Fatalf("incorrect number of statements in for loop body. expected=%d, got=%d", 2, len(forLoop.ForBody.Statements))
 	}
 }
+
+func TestTailCallMarking(t *testing.T) {
+	input := `g(); fn() { a(); if (x) { return b(); c() }; if (y) { d() } else { e(f()) } };
+fn() { defer h(); if (z) { return i() } j() };
+fn() { match (k()) { 1 => l(), n if m() => o(p()) } }`
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	tail := map[string]bool{}
+	var collect func(node ast.Node)
+	collect = func(node ast.Node) {
+		switch node := node.(type) {
+		case *ast.Program:
+			for _, stmt := range node.Statements {
+				collect(stmt)
+			}
+		case *ast.BlockStatement:
+			for _, stmt := range node.Statements {
+				collect(stmt)
+			}
+		case *ast.ExpressionStatement:
+			collect(node.Expression)
+		case *ast.ReturnStatement:
+			collect(node.ReturnValue)
+		case *ast.DeferStatement:
+			collect(node.Call)
+		case *ast.FunctionLiteral:
+			collect(node.FunctionBody)
+		case *ast.IfExpression:
+			collect(node.Consequence)
+			if node.Alternative != nil {
+				collect(node.Alternative)
+			}
+		case *ast.MatchExpression:
+			collect(node.Value)
+			for _, arm := range node.Arms {
+				if arm.Guard != nil {
+					collect(arm.Guard)
+				}
+				collect(arm.Result)
+			}
+		case *ast.CallExpression:
+			tail[node.Function.String()] = node.Tail
+			for _, arg := range node.Arguments {
+				collect(arg)
+			}
+		}
+	}
+	collect(program)
+
+	// a function with deferred calls still has work to do after any call
+	expected := map[string]bool{
+		"g": false, "a": false, "b": true, "c": false, "d": true, "e": true, "f": false,
+		"h": false, "i": false, "j": false,
+		"k": false, "l": true, "m": false, "o": true, "p": false,
+	}
+	for name, want := range expected {
+		got, ok := tail[name]
+		if !ok {
+			t.Errorf("call to %s not found", name)
+			continue
+		}
+		if got != want {
+			t.Errorf("wrong Tail for call to %s. want=%t, got=%t", name, want, got)
+		}
+	}
+}
+
+func TestParserErrorPositions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let ünïcode = €;", "1:15: illegal character \"€\""},
+		{"let x 5;", "1:7: expected next token to be \"=\", got \"INT\" instead"},
+		{"let a = 1;\n  日本 = ;", "2:6: no prefix parse fn found for \"=\""},
+		{"let s = \"abc;", "1:9: unterminated string literal"},
+		{"let n = 0b102;", "1:9: invalid integer literal \"0b102\""},
+		{"let n = 1__0;", "1:9: invalid integer literal \"1__0\""},
+		{"let n = 0x;", "1:9: invalid integer literal \"0x\""},
+		{`let s = "a ${} b";`, "1:14: empty interpolation in string"},
+		{`let s = "a ${x y} b";`, "1:16: expected } to close interpolation, got \"IDENT\" instead"},
+		{`let s = "a\qb";`, "1:11: invalid escape sequence `\\q`"},
+		{"let x = a.1;", "1:11: expected next token to be \"IDENT\", got \"INT\" instead"},
+		{"let m = import util;", "1:16: expected next token to be \"STRING\", got \"IDENT\" instead"},
+		{"let x = try { 1 };", "1:9: try needs a catch or finally block"},
+		{"try { 1 } catch e { 2 }", "1:17: expected next token to be \"(\", got \"IDENT\" instead"},
+		{"fn() { defer x; }", "1:8: defer needs a function call"},
+		{"defer f();", "1:1: defer outside a function body"},
+		{"match (x) { }", "1:1: match needs at least one arm"},
+		{"match (x) { 1 }", "1:15: expected next token to be \"=>\", got \"}\" instead"},
+		{"match (x) { x + 1 => 2 }", "1:15: expected next token to be \"=>\", got \"+\" instead"},
+		{"match (x) { f(x) => 2 }", "1:14: expected next token to be \"=>\", got \"(\" instead"},
+		{"match (x) { (1) => 2 }", "1:13: expected a pattern, got \"(\""},
+		{"match (x) { [a, ...b, c] => 2 }", "1:21: expected next token to be \"]\", got \",\" instead"},
+		{"match (x) { {k: v} => 2 }", "1:14: expected a literal key, got \"IDENT\""},
+		{"match (x) { 1 => 2 3 => 4 }", "1:20: expected next token to be \",\", got \"INT\" instead"},
+	}
+
+	for i, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) == 0 {
+			t.Errorf("tests[%d]: expected parser errors", i)
+			continue
+		}
+		if errors[0] != tt.expected {
+			t.Errorf("tests[%d]: wrong error. want=%q, got=%q", i, tt.expected, errors[0])
+		}
+	}
+}
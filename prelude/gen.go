@@ -0,0 +1,26 @@
+//go:build ignore
+
+// gen compiles the prelude and writes its bytecode to the file the interpreter
+// embeds. It is run by `go generate`.
+package main
+
+import (
+	"log"
+	"os"
+
+	"interpego/prelude"
+)
+
+func main() {
+	compiled, err := prelude.Compile()
+	if err != nil {
+		log.Fatal(err)
+	}
+	encoded, err := compiled.Encode()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(prelude.BYTECODE_FILE, encoded, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,186 @@
+// Package prelude is the part of the standard library written in Monkey. It is
+// embedded in the interpreter and loaded before every program, whichever
+// engine runs it.
+//
+// The VM loads the prelude from bytecode compiled when the interpreter is
+// built, so that start-up doesn't compile it. After changing prelude.monkey,
+// or the compiler, regenerate the bytecode with `go generate ./prelude`.
+package prelude
+
+//go:generate go run gen.go
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/gob"
+	"fmt"
+	"strings"
+	"sync"
+
+	"interpego/ast"
+	"interpego/compiler"
+	"interpego/evaluator"
+	"interpego/lexer"
+	"interpego/module"
+	"interpego/object"
+	"interpego/parser"
+	"interpego/vm"
+)
+
+// BYTECODE_FILE is where `go generate` writes the compiled prelude.
+const BYTECODE_FILE = "prelude.bytecode"
+
+//go:embed prelude.monkey
+var Source string
+
+//go:embed prelude.bytecode
+var bytecode []byte
+
+func init() {
+	// the types of the constants a compiled prelude can hold
+	gob.Register(&object.Integer{})
+	gob.Register(&object.BigInt{})
+	gob.Register(&object.Float{})
+	gob.Register(&object.String{})
+	gob.Register(&object.CompiledFunction{})
+}
+
+var parsed = sync.OnceValues(func() (*ast.Program, error) {
+	p := parser.New(lexer.New(Source))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return nil, fmt.Errorf("prelude: %s", strings.Join(p.Errors(), "; "))
+	}
+	// the VM finds the prelude's globals by the order of its let statements,
+	// so that is all it may contain
+	seen := map[string]bool{}
+	for _, stmt := range program.Statements {
+		let, ok := stmt.(*ast.LetStatement)
+		if !ok {
+			return nil, fmt.Errorf("prelude: %s: only let statements are allowed at the top level", stmt.Pos())
+		}
+		if seen[let.Name.Value] {
+			return nil, fmt.Errorf("prelude: %s: %s is already defined", stmt.Pos(), let.Name.Value)
+		}
+		seen[let.Name.Value] = true
+	}
+	return program, nil
+})
+
+// Program returns the parsed prelude. It is parsed once, on first use.
+func Program() (*ast.Program, error) {
+	return parsed()
+}
+
+// Load runs the prelude in the evaluator and makes the functions it exports
+// available to programs run with builtins. Names starting with an underscore
+// are private to the prelude.
+func Load(builtins *evaluator.Builtins) error {
+	program, err := Program()
+	if err != nil {
+		return err
+	}
+	env := object.NewEnvironment()
+	if result, ok := evaluator.Eval(*builtins, program, env).(*object.Error); ok {
+		return fmt.Errorf("prelude: %s: %s", result.Position, result.Message)
+	}
+	for _, name := range module.Exports(program) {
+		value, _ := env.Get(name)
+		builtins.Define(name, value)
+	}
+	return nil
+}
+
+// Compiled is the prelude compiled for the VM.
+type Compiled struct {
+	Bytecode *compiler.Bytecode
+	// Globals names each global the prelude defines, by index
+	Globals []string
+}
+
+// Compile compiles the prelude. Hosts should use Precompiled instead, which
+// doesn't pay for compiling it at start-up.
+func Compile() (*Compiled, error) {
+	program, err := Program()
+	if err != nil {
+		return nil, err
+	}
+	symbols := compiler.NewSymbolTable()
+	for i, def := range object.Builtins {
+		symbols.DefineBuiltin(i, def.Name)
+	}
+	comp := compiler.NewWithSymbols(symbols)
+	comp.SetOptimize(true)
+	if err := comp.Compile(program); err != nil {
+		return nil, fmt.Errorf("prelude: %s", err)
+	}
+	names := globals(program)
+	for i, name := range names {
+		if sym, _ := symbols.Resolve(name); sym.Index != i {
+			return nil, fmt.Errorf("prelude: %s was compiled to global %d, not %d", name, sym.Index, i)
+		}
+	}
+	return &Compiled{Bytecode: comp.Bytecode(), Globals: names}, nil
+}
+
+var precompiled = sync.OnceValues(func() (*Compiled, error) {
+	compiled := &Compiled{}
+	if err := gob.NewDecoder(bytes.NewReader(bytecode)).Decode(compiled); err != nil {
+		return nil, fmt.Errorf("prelude: %s is unusable, run `go generate ./prelude`: %s", BYTECODE_FILE, err)
+	}
+	return compiled, nil
+})
+
+// Precompiled returns the prelude as compiled when the interpreter was built.
+func Precompiled() (*Compiled, error) {
+	return precompiled()
+}
+
+// Encode returns the form of c that Precompiled reads back.
+func (c *Compiled) Encode() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(c); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// SymbolTable returns a new symbol table defining the builtins and the
+// prelude's globals, for compiling a program that runs after the prelude.
+func (c *Compiled) SymbolTable() *compiler.SymbolTable {
+	symbols := compiler.NewSymbolTable()
+	for i, def := range object.Builtins {
+		symbols.DefineBuiltin(i, def.Name)
+	}
+	for _, name := range c.Globals {
+		if strings.HasPrefix(name, "_") {
+			// still takes up its slot, under a name no program can write
+			name = "prelude." + name
+		}
+		symbols.Define(name)
+	}
+	symbols.ShareGlobals()
+	return symbols
+}
+
+// Constants returns a copy of the prelude's constants, which a program's
+// constants are added after, since the prelude's functions refer to them by
+// index.
+func (c *Compiled) Constants() []object.Object {
+	return append([]object.Object(nil), c.Bytecode.Constants...)
+}
+
+// Run defines the prelude's globals in globals, which must be large enough
+// for every global of the programs that will share them.
+func (c *Compiled) Run(globals []object.Object) error {
+	return vm.NewWithGlobals(globals, c.Bytecode).Run()
+}
+
+// globals returns the names the prelude's let statements define, in order.
+func globals(program *ast.Program) []string {
+	names := make([]string, len(program.Statements))
+	for i, stmt := range program.Statements {
+		names[i] = stmt.(*ast.LetStatement).Name.Value
+	}
+	return names
+}
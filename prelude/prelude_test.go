@@ -0,0 +1,99 @@
+package prelude
+
+import (
+	"bytes"
+	"testing"
+
+	"interpego/compiler"
+	"interpego/evaluator"
+	"interpego/lexer"
+	"interpego/object"
+	"interpego/parser"
+	"interpego/vm"
+)
+
+func TestPrecompiledIsCurrent(t *testing.T) {
+	compiled, err := Compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := compiled.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, bytecode) {
+		t.Fatalf("%s is out of date, run `go generate ./prelude`", BYTECODE_FILE)
+	}
+}
+
+// run runs input after the prelude with both engines and returns the results.
+func run(t *testing.T, input string) (evaluated, executed object.Object) {
+	program := parser.New(lexer.New(input)).ParseProgram()
+
+	builtins := evaluator.NewBuiltins()
+	if err := Load(&builtins); err != nil {
+		t.Fatal(err)
+	}
+	evaluated = evaluator.Eval(builtins, program, object.NewEnvironment())
+
+	compiled, err := Precompiled()
+	if err != nil {
+		t.Fatal(err)
+	}
+	comp := compiler.NewWithState(compiled.SymbolTable(), compiled.Constants())
+	if err := comp.Compile(program); err != nil {
+		return evaluated, &object.Error{Message: err.Error()}
+	}
+	globals := make([]object.Object, vm.GLOBALS_SIZE)
+	if err := compiled.Run(globals); err != nil {
+		t.Fatal(err)
+	}
+	machine := vm.NewWithGlobals(globals, comp.Bytecode())
+	if err := machine.Run(); err != nil {
+		return evaluated, &object.Error{Message: err.Error()}
+	}
+	return evaluated, machine.LastPoppedStackElement()
+}
+
+func TestPrelude(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`identity(5)`, "5"},
+		{`pipe(3, [fn(x) { x + 1 }, fn(x) { x * 10 }])`, "40"},
+		{`pipe(3, [])`, "3"},
+		{`sum([1, 2, 3])`, "6"},
+		{`sum([])`, "0"},
+		{`count(fn(x) { x > 1 }, [1, 2, 3])`, "2"},
+		{`flatMap(fn(x) { [x, -x] }, [1, 2])`, "[1, -1, 2, -2]"},
+		{`take(2, [1, 2, 3])`, "[1, 2]"},
+		{`take(5, [1])`, "[1]"},
+		{`drop(2, [1, 2, 3])`, "[3]"},
+		{`drop(-1, [1])`, "[1]"},
+		{`partition(fn(x) { x > 2 }, [1, 3, 2, 4])`, "[[3, 4], [1, 2]]"},
+		{`partition(fn(x) { true }, [])`, "[[], []]"},
+		{`groupBy(fn(s) { len(s) }, ["a", "bb", "c"])`, "({1: [a, c], 2: [bb]})"},
+		{`let sum = fn(arr) { "mine" }; sum([1])`, "mine"},
+	}
+
+	for _, tt := range tests {
+		evaluated, executed := run(t, tt.input)
+		if evaluated.Inspect() != tt.expected {
+			t.Errorf("evaluator: wrong result for %s. want=%q, got=%q", tt.input, tt.expected, evaluated.Inspect())
+		}
+		if executed.Inspect() != tt.expected {
+			t.Errorf("vm: wrong result for %s. want=%q, got=%q", tt.input, tt.expected, executed.Inspect())
+		}
+	}
+}
+
+func TestPreludePrivateNames(t *testing.T) {
+	evaluated, executed := run(t, `_partition`)
+	if err, ok := evaluated.(*object.Error); !ok || err.Message != "unknown identifier: _partition" {
+		t.Errorf("evaluator: wrong result. got=%s", evaluated.Inspect())
+	}
+	if err, ok := executed.(*object.Error); !ok || err.Message != "unable to resolve identifier: ident=_partition" {
+		t.Errorf("vm: wrong result. got=%s", executed.Inspect())
+	}
+}
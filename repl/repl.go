@@ -1,34 +1,102 @@
 package repl
 
 import (
-	"bufio"
 	"fmt"
 	"io"
+	"math/rand"
 
 	"interpego/compiler"
+	"interpego/evaluator"
 	"interpego/lexer"
+	"interpego/module"
 	"interpego/object"
 	"interpego/parser"
+	"interpego/prelude"
 	"interpego/vm"
 )
 
 const PROMPT = ">> "
 
-func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
-	symbols := compiler.NewSymbolTable()
+// Engine selects how Monkey programs are executed.
+type Engine string
+
+const (
+	VM_ENGINE   Engine = "vm"
+	EVAL_ENGINE Engine = "eval"
+)
+
+type Options struct {
+	Engine Engine
+	// Optimize enables compile-time simplification of programs run on the VM
+	Optimize bool
+	// IO is the streams programs executed by Run read from and write to, or
+	// nil for the process's standard streams. Start always uses its own input
+	// and output.
+	IO *object.IO
+	// File is the file the program executed by Run was read from, which its
+	// imports are relative to
+	File string
+	// SearchPath lists the directories imported modules are looked for in when
+	// they aren't found relative to the program
+	SearchPath []string
+	// Clock and Rand are the sources of time and random numbers programs use,
+	// or nil for the system clock and a source seeded from it
+	Clock object.Clock
+	Rand  *rand.Rand
+}
+
+// sources returns the clock and random numbers opts asks programs to use.
+func (opts Options) sources() (object.Clock, *rand.Rand) {
+	clock, random := opts.Clock, opts.Rand
+	if clock == nil {
+		clock = object.SystemClock{}
+	}
+	if random == nil {
+		random = object.NewRand()
+	}
+	return clock, random
+}
+
+func Start(in io.Reader, out io.Writer, opts Options) {
+	// programs share the REPL's input, so a line they read with `readLine`
+	// isn't also taken as code
+	streams := object.NewIO(out, out, in)
+	env := object.NewEnvironment()
+	// one source of random numbers for the whole session, so `math.seed` on
+	// one line affects the next
+	clock, random := opts.sources()
+	builtins := evaluator.NewBuiltins()
+	builtins.SetIO(streams)
+	builtins.SetRand(random)
+	builtins.SetClock(clock)
+	loader := module.NewLoader(opts.SearchPath)
+	builtins.SetLoader(loader)
 	globals := make([]object.Object, vm.GLOBALS_SIZE)
-	// env := object.NewEnvironment()
-	// builtins := evaluator.NewBuiltins()
+	compiled, err := prelude.Precompiled()
+	if err == nil {
+		err = prelude.Load(&builtins)
+	}
+	if err == nil {
+		err = compiled.Run(globals)
+	}
+	if err != nil {
+		fmt.Fprintf(out, "Woops! Loading the prelude failed:\n %s\n", err)
+		return
+	}
+	// the state later lines compile and run against, which each line that
+	// compiles adds to
+	symbols := compiled.SymbolTable()
+	constants := compiled.Constants()
+	compiledModules := map[string]int{}
+	importedModules := map[int]object.Object{}
 	for {
 		fmt.Fprintf(out, PROMPT)
 
-		scanned := scanner.Scan()
-		if !scanned {
+		line, err := streams.ReadLine()
+		if err != nil {
 			return
 		}
 
-		line := scanner.Text()
 		lexer := lexer.New(line)
 		p := parser.New(lexer)
 		program := p.ParseProgram()
@@ -36,21 +104,46 @@ func Start(in io.Reader, out io.Writer) {
 		if len(p.Errors()) > 0 {
 			printParserErrors(out, p.Errors())
 			continue
-		} else if len(program.Statements) == 0 {
+		}
+		printWarnings(out, p.Warnings())
+		if len(program.Statements) == 0 {
 			continue
 		}
 
-		compiler := compiler.NewWithSymbols(symbols)
-		err := compiler.Compile(program)
+		if opts.Engine == EVAL_ENGINE {
+			evaluated := evaluator.Eval(builtins, program, env)
+			if evaluated == nil {
+				continue
+			}
+			io.WriteString(out, "=> "+evaluated.Inspect())
+			io.WriteString(out, "\n\n")
+			if err, ok := evaluated.(*object.Error); ok {
+				io.WriteString(out, err.Backtrace.String()+"\n")
+			}
+			continue
+		}
+
+		compiler := compiler.NewWithState(symbols, constants)
+		compiler.SetOptimize(opts.Optimize)
+		compiler.SetLoader(loader)
+		compiler.SetModules(compiledModules)
+		err = compiler.Compile(program)
 		if err != nil {
 			fmt.Fprintf(out, "Woops! Compilation failed:\n %s\n", err)
 			continue
 		}
+		bytecode := compiler.Bytecode()
+		constants, compiledModules = bytecode.Constants, compiler.Modules()
 
-		vm := vm.NewWithGlobals(globals, compiler.Bytecode())
+		vm := vm.NewWithGlobals(globals, bytecode)
+		vm.SetModules(importedModules)
+		vm.SetIO(streams)
+		vm.SetRand(random)
+		vm.SetClock(clock)
 		err = vm.Run()
 		if err != nil {
 			fmt.Fprintf(out, "Woops! Executing bytecode failed:\n %s\n", err)
+			printBacktrace(out, err)
 			continue
 		}
 
@@ -59,8 +152,93 @@ func Start(in io.Reader, out io.Writer) {
 	}
 }
 
+// Run executes a whole program, writing errors to errOut. Runtime errors are
+// reported like a Go panic: the message followed by the Monkey call stack.
+// It returns false if the program failed to parse, compile or run.
+func Run(source string, errOut io.Writer, opts Options) bool {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		printParserErrors(errOut, p.Errors())
+		return false
+	}
+	printWarnings(errOut, p.Warnings())
+
+	streams := opts.IO
+	if streams == nil {
+		streams = object.DefaultIO()
+	}
+
+	clock, random := opts.sources()
+	loader := module.NewLoader(opts.SearchPath)
+	if opts.Engine == EVAL_ENGINE {
+		builtins := evaluator.NewBuiltins()
+		builtins.SetIO(streams)
+		builtins.SetRand(random)
+		builtins.SetClock(clock)
+		builtins.SetLoader(loader)
+		if err := prelude.Load(&builtins); err != nil {
+			fmt.Fprintln(errOut, err)
+			return false
+		}
+		evaluated := evaluator.Eval(builtins, program, object.NewFileEnvironment(opts.File))
+		if err, ok := evaluated.(*object.Error); ok {
+			fmt.Fprintf(errOut, "error: %s: %s\n", err.Position, err.Message)
+			io.WriteString(errOut, "\n"+err.Backtrace.String())
+			return false
+		}
+		return true
+	}
+
+	compiled, err := prelude.Precompiled()
+	if err != nil {
+		fmt.Fprintln(errOut, err)
+		return false
+	}
+	compiler := compiler.NewWithState(compiled.SymbolTable(), compiled.Constants())
+	compiler.SetOptimize(opts.Optimize)
+	compiler.SetFile(opts.File)
+	compiler.SetLoader(loader)
+	err = compiler.Compile(program)
+	if err != nil {
+		fmt.Fprintf(errOut, "compilation failed: %s\n", err)
+		return false
+	}
+	globals := make([]object.Object, vm.GLOBALS_SIZE)
+	err = compiled.Run(globals)
+	if err != nil {
+		fmt.Fprintln(errOut, err)
+		return false
+	}
+	machine := vm.NewWithGlobals(globals, compiler.Bytecode())
+	machine.SetIO(streams)
+	machine.SetRand(random)
+	machine.SetClock(clock)
+	err = machine.Run()
+	if err != nil {
+		fmt.Fprintf(errOut, "error: %s\n", err)
+		printBacktrace(errOut, err)
+		return false
+	}
+	return true
+}
+
+func printBacktrace(out io.Writer, err error) {
+	runtimeErr, ok := err.(*vm.RuntimeError)
+	if !ok {
+		return
+	}
+	io.WriteString(out, "\n"+runtimeErr.Backtrace.String())
+}
+
 func printParserErrors(out io.Writer, errors []string) {
 	for _, msg := range errors {
 		io.WriteString(out, "\t"+msg+"\n")
 	}
 }
+
+func printWarnings(out io.Writer, warnings []string) {
+	for _, msg := range warnings {
+		io.WriteString(out, "warning: "+msg+"\n")
+	}
+}
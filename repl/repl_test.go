@@ -0,0 +1,108 @@
+package repl
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"interpego/object"
+)
+
+// session runs input through the REPL and returns the results it printed, one
+// per line of input that has a value, and all of its output.
+func session(t *testing.T, input string, opts Options) ([]string, string) {
+	t.Helper()
+	var out bytes.Buffer
+	Start(strings.NewReader(input), &out, opts)
+
+	var results []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		line = strings.TrimPrefix(line, PROMPT)
+		if strings.HasPrefix(line, "Woops!") {
+			t.Fatalf("%s: unexpected error: %s", opts.Engine, out.String())
+		}
+		if strings.HasPrefix(line, "=> ") {
+			results = append(results, strings.TrimPrefix(line, "=> "))
+		}
+	}
+	return results, out.String()
+}
+
+func TestSeedAcrossLines(t *testing.T) {
+	for _, engine := range []Engine{VM_ENGINE, EVAL_ENGINE} {
+		input := "math.seed(7)\nmath.randomInt(0, 1000000000)\n"
+		first, _ := session(t, input, Options{Engine: engine})
+		second, _ := session(t, input, Options{Engine: engine})
+		if len(first) != 2 || len(second) != 2 || first[1] != second[1] {
+			t.Errorf("%s: math.seed didn't carry over to the next line: %v and %v", engine, first, second)
+		}
+	}
+}
+
+func TestClockAndRandOptions(t *testing.T) {
+	input := `println(time.format(time.now()), math.randomInt(0, 1000000000))`
+	for _, engine := range []Engine{VM_ENGINE, EVAL_ENGINE} {
+		run := func() string {
+			var out, errOut bytes.Buffer
+			opts := Options{
+				Engine: engine,
+				IO:     object.NewIO(&out, &out, strings.NewReader("")),
+				Clock:  object.FixedClock(time.Date(2024, 2, 28, 9, 30, 0, 0, time.UTC)),
+				Rand:   rand.New(rand.NewSource(7)),
+			}
+			if !Run(input, &errOut, opts) {
+				t.Fatalf("%s: run failed: %s", engine, errOut.String())
+			}
+			return out.String()
+		}
+
+		first, second := run(), run()
+		if !strings.HasPrefix(first, "2024-02-28T09:30:00Z ") {
+			t.Errorf("%s: the clock wasn't used. got=%q", engine, first)
+		}
+		if first != second {
+			t.Errorf("%s: the same seed gave different numbers: %q and %q", engine, first, second)
+		}
+
+		var out bytes.Buffer
+		Start(strings.NewReader(input+"\n"), &out, Options{
+			Engine: engine,
+			Clock:  object.FixedClock(time.Date(2024, 2, 28, 9, 30, 0, 0, time.UTC)),
+			Rand:   rand.New(rand.NewSource(7)),
+		})
+		if !strings.Contains(out.String(), first) {
+			t.Errorf("%s: Start didn't use the options. want=%q, got=%q", engine, first, out.String())
+		}
+	}
+}
+
+func TestStateAcrossLines(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "lib.monkey"), []byte(`println("loading lib"); let f = fn() { "from " + "lib" };`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// functions and modules defined on one line are used on the next, and a
+	// module imported again isn't run again
+	input := `let a = import "lib"; 1
+a.f()
+let g = fn() { "hello" }; 2
+g()
+let b = import "lib"; b.f()
+`
+	expected := []string{"1", "from lib", "2", "hello", "from lib"}
+	for _, engine := range []Engine{VM_ENGINE, EVAL_ENGINE} {
+		results, out := session(t, input, Options{Engine: engine, SearchPath: []string{dir}})
+		if strings.Join(results, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("%s: wrong results. want=%q, got=%q", engine, expected, results)
+		}
+		if strings.Count(out, "loading lib") != 1 {
+			t.Errorf("%s: lib wasn't run exactly once:\n%s", engine, out)
+		}
+	}
+}
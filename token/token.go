@@ -1,10 +1,31 @@
 package token
 
+import "fmt"
+
 type TokenType string
 
 type Token struct {
 	Type    TokenType
 	Literal string
+	Position
+}
+
+// Position is the 1-based line and column a token starts at. The zero value
+// means the position is unknown.
+type Position struct {
+	Line   int
+	Column int
+}
+
+func (p Position) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Position) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
 }
 
 const (
@@ -21,6 +42,14 @@ const (
 	INT    = "INT"
 	STRING = "STRING"
 
+	// Interpolated strings
+	// "a ${x} b ${y} c" is lexed as INTERP_START("a "), the tokens of x,
+	// INTERP_MID(" b "), the tokens of y and INTERP_END(" c"). The literal of
+	// each part is its decoded text.
+	INTERP_START = "INTERP_START"
+	INTERP_MID   = "INTERP_MID"
+	INTERP_END   = "INTERP_END"
+
 	// Operators
 	// Operators are special symbols that represent computations like addition, subtraction, etc.
 	// The operator tokens are the actual characters like +, -, etc.
@@ -36,6 +65,11 @@ const (
 	EQ     = "=="
 	NOT_EQ = "!="
 
+	// separate a pattern from its result and mark the rest of an array in
+	// match expressions
+	ARROW    = "=>"
+	ELLIPSIS = "..."
+
 	// Delimiters
 	// Delimiters are special symbols that are used to separate tokens.
 	// The delimiter tokens are the actual characters like (, ), etc.
@@ -48,6 +82,7 @@ const (
 	RBRACE    = "}"
 	LBRACKET  = "["
 	RBRACKET  = "]"
+	DOT       = "."
 
 	// Keywords
 	// Keywords are reserved words that have special meaning in the language.
@@ -60,17 +95,31 @@ const (
 	TRUE     = "TRUE"
 	FALSE    = "FALSE"
 	FOR      = "FOR"
+	IMPORT   = "IMPORT"
+	THROW    = "THROW"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
+	DEFER    = "DEFER"
+	MATCH    = "MATCH"
 )
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"if":     IF,
-	"else":   ELSE,
-	"true":   TRUE,
-	"false":  FALSE,
-	"return": RETURN,
-	"for":    FOR,
+	"fn":      FUNCTION,
+	"let":     LET,
+	"if":      IF,
+	"else":    ELSE,
+	"true":    TRUE,
+	"false":   FALSE,
+	"return":  RETURN,
+	"for":     FOR,
+	"import":  IMPORT,
+	"throw":   THROW,
+	"try":     TRY,
+	"catch":   CATCH,
+	"finally": FINALLY,
+	"defer":   DEFER,
+	"match":   MATCH,
 }
 
 func LookupIdent(ident string) TokenType {
@@ -1,29 +1,56 @@
 package vm
 
 import (
+	"errors"
 	"fmt"
+	"math/rand"
+	"strings"
 
 	"interpego/code"
 	"interpego/compiler"
 	"interpego/object"
+	"interpego/token"
 )
 
 var (
-	TRUE  = &object.Boolean{Value: true}
-	FALSE = &object.Boolean{Value: false}
-	NULL  = &object.Null{}
+	TRUE  = object.TRUE
+	FALSE = object.FALSE
+	NULL  = object.NULL
 )
 
 const (
-	STACK_SIZE   = 2048
-	GLOBALS_SIZE = 65536
-	FRAMES_SIZE  = 1024
+	// the stacks and globals start out small and grow on demand, the stacks
+	// only up to the VM's limits
+	INITIAL_STACK_SIZE   = 128
+	INITIAL_FRAMES_SIZE  = 16
+	INITIAL_GLOBALS_SIZE = 64
+
+	DEFAULT_MAX_STACK_SIZE = 1 << 20
+	DEFAULT_MAX_FRAMES     = 1 << 16
+	GLOBALS_SIZE           = 65536
+
+	// frames kept at each end of the backtrace of a very deep call stack
+	BACKTRACE_DEPTH = 50
 )
 
+var errStackOverflow = errors.New("stack overflow")
+
 type Frame struct {
 	fn        *object.CompiledFunction
 	ip        int
 	stackBase int
+	// calls deferred until the function returns, in the order they were
+	// deferred
+	deferred []deferredCall
+}
+
+// deferredCall is a call a frame makes when its function returns.
+type deferredCall struct {
+	fn   object.Object
+	args []object.Object
+	// address of the OpDefer instruction, where the frame is reported to be
+	// while the call runs
+	ip int
 }
 
 func (f *Frame) Instructions() code.Instructions {
@@ -38,9 +65,14 @@ func (vm *VM) currentFrame() *Frame {
 	return vm.frames[vm.framesIdx]
 }
 
-func (vm *VM) pushFrame(newFrame *Frame) {
+func (vm *VM) pushFrame(newFrame *Frame) error {
+	err := vm.growFrames(vm.framesIdx + 2)
+	if err != nil {
+		return err
+	}
 	vm.framesIdx++
 	vm.frames[vm.framesIdx] = newFrame
+	return nil
 }
 
 func (vm *VM) popFrame() *Frame {
@@ -52,44 +84,272 @@ func (vm *VM) popFrame() *Frame {
 type VM struct {
 	stack        []object.Object
 	stackPointer int
+	maxStackSize int
 	frames       []*Frame
 	framesIdx    int
+	maxFrames    int
 	constants    []object.Object
 	globals      []object.Object
+	io           *object.IO
+	rand         *rand.Rand
+	clock        object.Clock
+
+	// the error a function called back into by a builtin failed with, kept so
+	// the builtin's failure can be reported with the callback's position
+	callbackErr *RuntimeError
+	// hash of the exports of each module imported so far, by the index of its
+	// init function in the constant pool
+	modules map[int]object.Object
+	// the handler table: the try blocks that are running, innermost last
+	handlers []handler
 }
 
 func New(bytecode *compiler.Bytecode) *VM {
-	vm := &VM{
-		stack:        make([]object.Object, STACK_SIZE),
-		stackPointer: 0,
-		frames:       make([]*Frame, FRAMES_SIZE),
-		framesIdx:    -1,
-		constants:    bytecode.Constants,
-		globals:      make([]object.Object, GLOBALS_SIZE),
-	}
-	vm.pushFrame(NewFrame(&object.CompiledFunction{Instructions: bytecode.Instructions}, 0))
-	return vm
+	return NewWithGlobals(make([]object.Object, INITIAL_GLOBALS_SIZE), bytecode)
 }
 
+// NewWithGlobals creates a VM that uses globals as its global store, which
+// must be large enough for every global bytecode uses if it is to be shared
+// between VMs.
 func NewWithGlobals(globals []object.Object, bytecode *compiler.Bytecode) *VM {
 	vm := &VM{
-		stack:        make([]object.Object, STACK_SIZE),
+		stack:        make([]object.Object, INITIAL_STACK_SIZE),
 		stackPointer: 0,
-		frames:       make([]*Frame, FRAMES_SIZE),
+		maxStackSize: DEFAULT_MAX_STACK_SIZE,
+		frames:       make([]*Frame, INITIAL_FRAMES_SIZE),
 		framesIdx:    -1,
+		maxFrames:    DEFAULT_MAX_FRAMES,
 		constants:    bytecode.Constants,
 		globals:      globals,
+		io:           object.DefaultIO(),
+		rand:         object.NewRand(),
+		clock:        object.SystemClock{},
+		modules:      map[int]object.Object{},
 	}
-	vm.pushFrame(NewFrame(&object.CompiledFunction{Instructions: bytecode.Instructions}, 0))
+	vm.pushFrame(NewFrame(mainFunction(bytecode), 0))
 	return vm
 }
 
+// SetIO sets the streams builtins like `print` and `readLine` use.
+func (vm *VM) SetIO(io *object.IO) {
+	vm.io = io
+}
+
+// IO implements object.Runtime.
+func (vm *VM) IO() *object.IO {
+	return vm.io
+}
+
+// SetRand sets the source of random numbers for builtins like `math.random`,
+// so that a host can make runs reproducible.
+func (vm *VM) SetRand(rand *rand.Rand) {
+	vm.rand = rand
+}
+
+// Rand implements object.Runtime.
+func (vm *VM) Rand() *rand.Rand {
+	return vm.rand
+}
+
+// SetClock sets the clock builtins like `time.now` read, so that a host can
+// make runs deterministic.
+func (vm *VM) SetClock(clock object.Clock) {
+	vm.clock = clock
+}
+
+// Clock implements object.Runtime.
+func (vm *VM) Clock() object.Clock {
+	return vm.clock
+}
+
+// SetModules sets the exports of the modules imported so far, by the index of
+// their init function in the constant pool, so a VM continuing a program, like
+// the next line in the REPL, doesn't run them again. The VM adds the modules it
+// imports to modules.
+func (vm *VM) SetModules(modules map[int]object.Object) {
+	vm.modules = modules
+}
+
+// SetMaxStackSize limits how many values the VM's stack can hold, including
+// the locals of every active call. Exceeding it is a stack overflow.
+func (vm *VM) SetMaxStackSize(size int) {
+	vm.maxStackSize = size
+}
+
+// SetMaxFrames limits how deeply calls can nest. Exceeding it is a stack
+// overflow.
+func (vm *VM) SetMaxFrames(frames int) {
+	vm.maxFrames = frames
+}
+
+func mainFunction(bytecode *compiler.Bytecode) *object.CompiledFunction {
+	return &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		SourceMap:    bytecode.SourceMap,
+		Name:         "main",
+	}
+}
+
+// RuntimeError is returned by Run when executing the bytecode fails. It carries
+// the source position of the failing instruction and the Monkey call stack at
+// the time of the failure.
+type RuntimeError struct {
+	Message   string
+	Position  token.Position
+	Backtrace object.Backtrace
+	// Kind and Value are as in object.Error
+	Kind  string
+	Value object.Object
+}
+
+// object returns the error as the value a handler starts with.
+func (e *RuntimeError) object() *object.Error {
+	return &object.Error{Message: e.Message, Position: e.Position, Backtrace: e.Backtrace, Kind: e.Kind, Value: e.Value}
+}
+
+// handler is a try block that is running: the frame it runs in, the height
+// of the stack when it started, and where its handler starts.
+type handler struct {
+	framesIdx    int
+	stackPointer int
+	ip           int
+}
+
+func (e *RuntimeError) Error() string {
+	if !e.Position.IsValid() {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Position, e.Message)
+}
+
 func (vm *VM) Run() error {
+	err := vm.run(-1)
+	if err != nil {
+		return vm.newRuntimeError(err)
+	}
+	return nil
+}
+
+// newRuntimeError walks the frame stack to attach a position and backtrace to
+// err. The innermost frame's ip still points at the failing instruction, while
+// every caller's ip has already moved past its OpCall.
+func (vm *VM) newRuntimeError(err error) *RuntimeError {
+	runtimeErr, ok := err.(*RuntimeError)
+	if ok && runtimeErr.Backtrace != nil {
+		// raised in a callback or rethrown, and already carries its position
+		return runtimeErr
+	}
+	if !ok {
+		runtimeErr = &RuntimeError{Message: err.Error()}
+	}
+	backtrace := make(object.Backtrace, 0, min(vm.framesIdx+1, 2*BACKTRACE_DEPTH+1))
+	for i := vm.framesIdx; i >= 0; i-- {
+		if vm.framesIdx-i == BACKTRACE_DEPTH && i >= BACKTRACE_DEPTH {
+			backtrace = append(backtrace, object.StackFrame{Elided: i - BACKTRACE_DEPTH + 1})
+			i = BACKTRACE_DEPTH
+			continue
+		}
+		frame := vm.frames[i]
+		ip := frame.ip
+		if i != vm.framesIdx {
+			ip--
+		}
+		pos, _ := frame.fn.SourceMap.Lookup(ip)
+
+		args := vm.stack[frame.stackBase : frame.stackBase+frame.fn.NumParameters]
+		backtrace = append(backtrace, object.NewStackFrame(frame.fn.Name, args, pos))
+	}
+	runtimeErr.Position, runtimeErr.Backtrace = backtrace[0].Position, backtrace
+	return runtimeErr
+}
+
+// catch hands err to the innermost try block running above the frame at index
+// base, unwinding the frames and stack to where it started. The calls deferred
+// by the frames in between are made first, and one that fails takes the place
+// of err. If no try block catches the error, it is returned once the calls
+// deferred above base have been made.
+func (vm *VM) catch(err error, base int) error {
+	// the position and backtrace are taken before the frames go
+	runtimeErr := vm.newRuntimeError(err)
+	n := len(vm.handlers)
+	target := base
+	if n > 0 && vm.handlers[n-1].framesIdx > base {
+		target = vm.handlers[n-1].framesIdx
+	}
+	if deferredErr := vm.unwind(target); deferredErr != nil {
+		runtimeErr = deferredErr
+	}
+	if target == base {
+		return runtimeErr
+	}
+
+	h := vm.handlers[n-1]
+	vm.handlers = vm.handlers[:n-1]
+	vm.framesIdx, vm.stackPointer = h.framesIdx, h.stackPointer
+	vm.currentFrame().ip = h.ip
+	// the stack was at least this high before, so there's room
+	vm.push(runtimeErr.object())
+	return nil
+}
+
+// unwind makes the calls deferred by the frames above the one at index target,
+// innermost first, as an error abandons them. It returns the error of the last
+// call to fail, if any.
+func (vm *VM) unwind(target int) *RuntimeError {
+	var err *RuntimeError
+	for i := vm.framesIdx; i > target; i-- {
+		if len(vm.frames[i].deferred) == 0 {
+			continue
+		}
+		if i < vm.framesIdx {
+			// the calls run on top of the frame, as when it returns
+			vm.framesIdx, vm.stackPointer = i, vm.frames[i+1].stackBase
+		}
+		if deferredErr := vm.runDeferred(); deferredErr != nil {
+			err = deferredErr
+		}
+	}
+	return err
+}
+
+// runDeferred makes the calls the current frame deferred, most recent first.
+// Like a finally block, a call that fails replaces the frame's result with its
+// error, which is returned; the calls after it are still made.
+func (vm *VM) runDeferred() *RuntimeError {
+	frame := vm.currentFrame()
+	var err *RuntimeError
+	for n := len(frame.deferred); n > 0; n = len(frame.deferred) {
+		call := frame.deferred[n-1]
+		frame.deferred = frame.deferred[:n-1]
+		frame.ip = call.ip
+		if _, failed := vm.Call(call.fn, call.args...).(*object.Error); failed {
+			err = vm.callbackErr
+		}
+	}
+	return err
+}
+
+// run executes instructions until the frame at index base returns, or until the
+// main function runs out of instructions when base is -1. Errors are handed to
+// the try blocks running above base, and returned if none catches them.
+func (vm *VM) run(base int) error {
+	for {
+		err := vm.execute(base)
+		if err == nil {
+			return nil
+		}
+		if err = vm.catch(err, base); err != nil {
+			return err
+		}
+	}
+}
+
+func (vm *VM) execute(base int) error {
 	var ip int
 	var instructions code.Instructions
 	var op code.Opcode
 
-	for vm.currentFrame().ip < len(vm.currentFrame().Instructions()) {
+	for vm.framesIdx > base && vm.currentFrame().ip < len(vm.currentFrame().Instructions()) {
 		ip = vm.currentFrame().ip
 		instructions = vm.currentFrame().Instructions()
 		op = code.Opcode(instructions[ip])
@@ -104,11 +364,13 @@ func (vm *VM) Run() error {
 			vm.currentFrame().ip += 1
 		case code.OpMinus:
 			popped := vm.pop()
-			if popped.Type() != object.INTEGER_TYPE {
-				return fmt.Errorf("only integer objects are supported by minus prefix operator. got=%T (%+v)", popped, popped)
+			if !object.IsNumber(popped) {
+				return fmt.Errorf("only number objects are supported by minus prefix operator. got=%T (%+v)", popped, popped)
+			}
+			err := vm.push(object.Negate(popped))
+			if err != nil {
+				return err
 			}
-			int := popped.(*object.Integer)
-			vm.push(&object.Integer{Value: -int.Value})
 			vm.currentFrame().ip += 1
 		case code.OpConstant:
 			constantAddress := code.ReadUint16(instructions[ip+1:])
@@ -118,8 +380,32 @@ func (vm *VM) Run() error {
 				return err
 			}
 			vm.currentFrame().ip += 3
+		case code.OpConstantWide:
+			constantAddress := code.ReadUint32(instructions[ip+1:])
+
+			err := vm.push(vm.constants[constantAddress])
+			if err != nil {
+				return err
+			}
+			vm.currentFrame().ip += 5
 		case code.OpAdd, code.OpMul, code.OpDiv, code.OpSub:
-			err := vm.executeBinaryOperation(op)
+			right := vm.pop()
+			left := vm.pop()
+			err := vm.executeBinaryOperation(op, left, right)
+			if err != nil {
+				return err
+			}
+			vm.currentFrame().ip += 1
+		case code.OpAddConstant:
+			constantAddress := code.ReadUint16(instructions[ip+1:])
+			left := vm.pop()
+			err := vm.executeBinaryOperation(code.OpAdd, left, vm.constants[constantAddress])
+			if err != nil {
+				return err
+			}
+			vm.currentFrame().ip += 3
+		case code.OpDup:
+			err := vm.push(vm.stack[vm.stackPointer-1])
 			if err != nil {
 				return err
 			}
@@ -156,21 +442,32 @@ func (vm *VM) Run() error {
 
 			switch popped {
 			case FALSE:
-				jumpAddress := code.ReadUint16(instructions[ip+1:])
+				jumpAddress := code.ReadUint32(instructions[ip+1:])
 				vm.currentFrame().ip = int(jumpAddress)
 			case TRUE:
-				vm.currentFrame().ip += 3
+				vm.currentFrame().ip += 5
 			default:
 				return fmt.Errorf("conditional expression does not have expected type. expected=ast.Boolean, got=%T (%+v)", popped, popped)
 			}
 		case code.OpJump:
-			jumpAddress := code.ReadUint16(instructions[ip+1:])
+			jumpAddress := code.ReadUint32(instructions[ip+1:])
 			vm.currentFrame().ip = int(jumpAddress)
 		case code.OpSetGlobal:
-			vm.globals[code.ReadUint16(instructions[ip+1:])] = vm.pop()
+			globalIndex := int(code.ReadUint16(instructions[ip+1:]))
+			if globalIndex >= len(vm.globals) {
+				globals := make([]object.Object, max(2*len(vm.globals), globalIndex+1))
+				copy(globals, vm.globals)
+				vm.globals = globals
+			}
+			vm.globals[globalIndex] = vm.pop()
 			vm.currentFrame().ip += 3
 		case code.OpGetGlobal:
-			err := vm.push(vm.globals[code.ReadUint16(instructions[ip+1:])])
+			globalIndex := int(code.ReadUint16(instructions[ip+1:]))
+			var global object.Object
+			if globalIndex < len(vm.globals) {
+				global = vm.globals[globalIndex]
+			}
+			err := vm.push(global)
 			if err != nil {
 				return err
 			}
@@ -180,6 +477,11 @@ func (vm *VM) Run() error {
 
 			vm.stack[vm.currentFrame().stackBase+int(localsOffset)] = vm.pop()
 			vm.currentFrame().ip += 2
+		case code.OpSetLocalWide:
+			localsOffset := code.ReadUint16(instructions[ip+1:])
+
+			vm.stack[vm.currentFrame().stackBase+int(localsOffset)] = vm.pop()
+			vm.currentFrame().ip += 3
 		case code.OpGetLocal:
 			localsOffset := instructions[ip+1]
 			err := vm.push(vm.stack[vm.currentFrame().stackBase+int(localsOffset)])
@@ -187,62 +489,171 @@ func (vm *VM) Run() error {
 				return err
 			}
 			vm.currentFrame().ip += 2
+		case code.OpGetLocalWide:
+			localsOffset := code.ReadUint16(instructions[ip+1:])
+			err := vm.push(vm.stack[vm.currentFrame().stackBase+int(localsOffset)])
+			if err != nil {
+				return err
+			}
+			vm.currentFrame().ip += 3
 		case code.OpCall:
-			numArgs := code.ReadUint8(instructions[ip+1:])
-			vm.currentFrame().ip += 2
+			err := vm.callFunction(int(code.ReadUint8(instructions[ip+1:])), 2, false)
+			if err != nil {
+				return err
+			}
+		case code.OpCallWide:
+			err := vm.callFunction(int(code.ReadUint16(instructions[ip+1:])), 3, false)
+			if err != nil {
+				return err
+			}
+		case code.OpTailCall:
+			err := vm.callFunction(int(code.ReadUint8(instructions[ip+1:])), 2, true)
+			if err != nil {
+				return err
+			}
+		case code.OpArray:
+			numElements := int(code.ReadUint16(instructions[ip+1:]))
+			elements := make([]object.Object, numElements)
+			copy(elements, vm.stack[vm.stackPointer-numElements:vm.stackPointer])
+			vm.stackPointer -= numElements
 
-			// given the following fn(x, y) { let a = 1; x + y + a }(2, 3) the stack looks as follows:
-			// [CompiledFunction, 2, 3, null, null, null, null, ...]
-			//                           ^------ stackpointer
-
-			// we want it to look like the following when the function starts executing:
-			// [2, 3, null, null, null, null, ...]
-			//  ^------ stackBase
-			//                ^------- stackPointer
-
-			var args []object.Object
-			if numArgs > 0 {
-				args = make([]object.Object, numArgs)
-				for i := int(numArgs) - 1; i >= 0; i-- {
-					popped := vm.pop()
-					args[i] = popped
-				}
+			err := vm.push(&object.Array{Elements: elements})
+			if err != nil {
+				return err
+			}
+			vm.currentFrame().ip += 3
+		case code.OpHash:
+			numItems := int(code.ReadUint16(instructions[ip+1:]))
+			hash, err := vm.buildHash(vm.stackPointer-numItems, vm.stackPointer)
+			if err != nil {
+				return err
+			}
+			vm.stackPointer -= numItems
 
+			err = vm.push(hash)
+			if err != nil {
+				return err
+			}
+			vm.currentFrame().ip += 3
+		case code.OpInterpolate:
+			numParts := int(code.ReadUint16(instructions[ip+1:]))
+			var out strings.Builder
+			for _, part := range vm.stack[vm.stackPointer-numParts : vm.stackPointer] {
+				out.WriteString(part.Inspect())
 			}
+			vm.stackPointer -= numParts
 
-			// the stack now looks like
-			// [CompiledFunction, null, null, null, null, null, null, ...]
-			//                     ^------ stackpointer
-			popped := vm.pop()
-			fn, ok := popped.(*object.CompiledFunction)
+			err := vm.push(&object.String{Value: out.String()})
+			if err != nil {
+				return err
+			}
+			vm.currentFrame().ip += 3
+		case code.OpImport:
+			constantAddress := int(code.ReadUint32(instructions[ip+1:]))
+			exports, ok := vm.modules[constantAddress]
 			if !ok {
-				return fmt.Errorf("calling non-function! type=%T", popped)
+				exports = vm.Call(vm.constants[constantAddress])
+				if _, failed := exports.(*object.Error); failed {
+					return vm.callbackErr
+				}
+				vm.modules[constantAddress] = exports
 			}
+			err := vm.push(exports)
+			if err != nil {
+				return err
+			}
+			vm.currentFrame().ip += 5
+		case code.OpTry:
+			vm.handlers = append(vm.handlers, handler{
+				framesIdx:    vm.framesIdx,
+				stackPointer: vm.stackPointer,
+				ip:           int(code.ReadUint32(instructions[ip+1:])),
+			})
+			vm.currentFrame().ip += 5
+		case code.OpEndTry:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+			vm.currentFrame().ip += 1
+		case code.OpThrow:
+			thrown := vm.pop()
+			if err, ok := thrown.(*object.Error); ok {
+				// rethrown after a finally block, as it was first raised
+				return &RuntimeError{Message: err.Message, Position: err.Position, Backtrace: err.Backtrace, Kind: err.Kind, Value: err.Value}
+			}
+			err := object.NewThrownError(thrown)
+			return &RuntimeError{Message: err.Message, Value: err.Value}
+		case code.OpCatch:
+			err := vm.pop().(*object.Error)
+			vm.push(err.Caught())
+			vm.currentFrame().ip += 1
+		case code.OpDefer:
+			numArgs := int(code.ReadUint8(instructions[ip+1:]))
+			args := make([]object.Object, numArgs)
+			copy(args, vm.stack[vm.stackPointer-numArgs:vm.stackPointer])
+			fn := vm.stack[vm.stackPointer-numArgs-1]
+			vm.stackPointer -= numArgs + 1
 
-			// the stack is now empty
-			// [null, null, null, null, null, null, null, ...]
-			//    ^------ stackpointer
-			// this should also be the stack base, since stackBase + 0 is the first local (which is the first fn param if it exists)
-			newFrame := NewFrame(fn, vm.stackPointer)
-
-			if numArgs > 0 {
-				for i := 0; i < int(numArgs); i++ {
-					vm.push(args[i])
+			frame := vm.currentFrame()
+			frame.deferred = append(frame.deferred, deferredCall{fn: fn, args: args, ip: ip})
+			frame.ip += 2
+		case code.OpMatchValue:
+			literal := vm.pop()
+			subject := vm.pop()
+			vm.push(nativeBoolToBooleanObject(object.Equal(literal, subject)))
+			vm.currentFrame().ip += 1
+		case code.OpMatchArray, code.OpMatchArrayMin:
+			numElements := int(code.ReadUint16(instructions[ip+1:]))
+			array, ok := vm.pop().(*object.Array)
+			matched := ok && len(array.Elements) == numElements
+			if ok && op == code.OpMatchArrayMin {
+				matched = len(array.Elements) >= numElements
+			}
+			vm.push(nativeBoolToBooleanObject(matched))
+			vm.currentFrame().ip += 3
+		case code.OpArrayRest:
+			start := int(code.ReadUint16(instructions[ip+1:]))
+			array := vm.pop().(*object.Array)
+			// as with the `rest` builtin, sharing the elements lets a
+			// function recurse over an array without copying it each time
+			rest := array.Elements[start:len(array.Elements):len(array.Elements)]
+			vm.push(&object.Array{Elements: rest})
+			vm.currentFrame().ip += 3
+		case code.OpMatchHash:
+			numKeys := int(code.ReadUint16(instructions[ip+1:]))
+			keys := vm.stack[vm.stackPointer-numKeys : vm.stackPointer]
+			hash, matched := vm.stack[vm.stackPointer-numKeys-1].(*object.Hash)
+			for _, key := range keys {
+				if !matched {
+					break
 				}
+				_, matched = hash.Get(key.(object.Hashable))
 			}
-			// the stack now looks like
-			// [2, 3, null, null, null, null, null, ...]
-			//  ^------ stackBase
-			//          ^------ stackPointer
-			vm.stackPointer += fn.NumLocals - int(numArgs)
-
-			// the stack now looks like
-			// [2, 3, null, null, null, null, null, ...]
-			//  ^------- stackBase
-			//               ^------ stackpointer
-			vm.pushFrame(newFrame)
+			vm.stackPointer -= numKeys + 1
+			vm.push(nativeBoolToBooleanObject(matched))
+			vm.currentFrame().ip += 3
+		case code.OpNoMatch:
+			return fmt.Errorf("no match for value: %s", vm.pop().Inspect())
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+			err := vm.executeIndexExpression(left, index)
+			if err != nil {
+				return err
+			}
+			vm.currentFrame().ip += 1
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint8(instructions[ip+1:])
+			err := vm.push(object.Builtins[builtinIndex].Value)
+			if err != nil {
+				return err
+			}
+			vm.currentFrame().ip += 2
 		case code.OpReturnValue:
 			popped := vm.pop()
+			if len(vm.currentFrame().deferred) > 0 {
+				if err := vm.runDeferred(); err != nil {
+					return err
+				}
+			}
 			frame := vm.popFrame()
 			vm.stackPointer = frame.stackBase
 
@@ -251,8 +662,19 @@ func (vm *VM) Run() error {
 				return err
 			}
 		case code.OpReturn:
+			if len(vm.currentFrame().deferred) > 0 {
+				if err := vm.runDeferred(); err != nil {
+					return err
+				}
+			}
 			frame := vm.popFrame()
 			vm.stackPointer = frame.stackBase
+
+			// the body ended with a statement that has no value
+			err := vm.push(NULL)
+			if err != nil {
+				return err
+			}
 		default:
 			return fmt.Errorf("unknown opcode encountered: %d", op)
 		}
@@ -260,19 +682,184 @@ func (vm *VM) Run() error {
 	return nil
 }
 
+// callFunction calls the function below the numArgs arguments on top of the
+// stack. width is the length of the calling instruction, which the caller's ip
+// moves past once the call is known to be valid. A tail call hands the
+// caller's frame over to the callee, since the caller would only return the
+// callee's result.
+func (vm *VM) callFunction(numArgs int, width int, tail bool) error {
+	// given the following fn(x, y) { let a = 1; x + y + a }(2, 3) the stack looks as follows:
+	// [CompiledFunction, 2, 3, null, null, null, null, ...]
+	//                           ^------ stackpointer
+
+	// we want it to look like the following when the function starts executing:
+	// [2, 3, null, null, null, null, ...]
+	//  ^------ stackBase
+	//                ^------- stackPointer
+
+	var args []object.Object
+	if numArgs > 0 {
+		args = make([]object.Object, numArgs)
+		for i := numArgs - 1; i >= 0; i-- {
+			popped := vm.pop()
+			args[i] = popped
+		}
+
+	}
+
+	// the stack now looks like
+	// [CompiledFunction, null, null, null, null, null, null, ...]
+	//                     ^------ stackpointer
+	popped := vm.pop()
+	if builtin, ok := popped.(*object.Builtin); ok {
+		return vm.callBuiltin(builtin, args, width)
+	}
+	fn, ok := popped.(*object.CompiledFunction)
+	if !ok {
+		return fmt.Errorf("calling non-function! type=%T", popped)
+	}
+	if fn.NumParameters != numArgs {
+		return fmt.Errorf("wrong number of arguments: want=%d, got=%d", fn.NumParameters, numArgs)
+	}
+
+	if tail {
+		frame := vm.currentFrame()
+		err := vm.growStack(frame.stackBase + fn.NumLocals)
+		if err != nil {
+			return err
+		}
+		vm.stackPointer = frame.stackBase
+		for _, arg := range args {
+			vm.push(arg)
+		}
+		vm.stackPointer += fn.NumLocals - numArgs
+		frame.fn = fn
+		frame.ip = 0
+		return nil
+	}
+	err := vm.growStack(vm.stackPointer + fn.NumLocals)
+	if err != nil {
+		return err
+	}
+	err = vm.growFrames(vm.framesIdx + 2)
+	if err != nil {
+		return err
+	}
+	vm.currentFrame().ip += width
+
+	// the stack is now empty
+	// [null, null, null, null, null, null, null, ...]
+	//    ^------ stackpointer
+	// this should also be the stack base, since stackBase + 0 is the first local (which is the first fn param if it exists)
+	newFrame := NewFrame(fn, vm.stackPointer)
+
+	if numArgs > 0 {
+		for i := 0; i < numArgs; i++ {
+			vm.push(args[i])
+		}
+	}
+	// the stack now looks like
+	// [2, 3, null, null, null, null, null, ...]
+	//  ^------ stackBase
+	//          ^------ stackPointer
+	vm.stackPointer += fn.NumLocals - numArgs
+
+	// the stack now looks like
+	// [2, 3, null, null, null, null, null, ...]
+	//  ^------- stackBase
+	//               ^------ stackpointer
+	return vm.pushFrame(newFrame)
+}
+
+func (vm *VM) callBuiltin(builtin *object.Builtin, args []object.Object, width int) error {
+	vm.callbackErr = nil
+	result := builtin.Fn(vm, args...)
+	if err, ok := result.(*object.Error); ok {
+		if vm.callbackErr != nil {
+			return vm.callbackErr
+		}
+		return &RuntimeError{Message: err.Message, Kind: object.BUILTIN_ERROR}
+	}
+	if result == nil {
+		result = NULL
+	}
+	vm.currentFrame().ip += width
+	return vm.push(result)
+}
+
+// Call runs fn with args to completion on behalf of a builtin, on top of the
+// frames of the running program. It implements object.Runtime.
+func (vm *VM) Call(fn object.Object, args ...object.Object) object.Object {
+	base, stackPointer := vm.framesIdx, vm.stackPointer
+	// the caller is stopped on its call to the builtin; stepping past that
+	// instruction lets backtraces point at the call, as for any other caller
+	caller := vm.currentFrame()
+	caller.ip++
+	defer func() { caller.ip-- }()
+
+	err := vm.push(fn)
+	for _, arg := range args {
+		if err == nil {
+			err = vm.push(arg)
+		}
+	}
+	if err == nil {
+		err = vm.callFunction(len(args), 0, false)
+	}
+	if err == nil && vm.framesIdx > base {
+		err = vm.run(base)
+	}
+	if err != nil {
+		vm.callbackErr = vm.newRuntimeError(err)
+		vm.framesIdx, vm.stackPointer = base, stackPointer
+		return &object.Error{Message: vm.callbackErr.Message}
+	}
+	return vm.pop()
+}
+
 func (vm *VM) LastPoppedStackElement() object.Object {
 	return vm.stack[vm.stackPointer]
 }
 
 func (vm *VM) push(obj object.Object) error {
-	if vm.stackPointer >= STACK_SIZE {
-		return fmt.Errorf("stack overflow")
+	err := vm.growStack(vm.stackPointer + 1)
+	if err != nil {
+		return err
 	}
 	vm.stack[vm.stackPointer] = obj
 	vm.stackPointer++
 	return nil
 }
 
+// growStack makes room for size values on the stack. It at least doubles the
+// stack each time, so pushing values one by one stays cheap.
+func (vm *VM) growStack(size int) error {
+	if size > vm.maxStackSize {
+		return errStackOverflow
+	}
+	if size <= len(vm.stack) {
+		return nil
+	}
+	stack := make([]object.Object, min(max(2*len(vm.stack), size), vm.maxStackSize))
+	copy(stack, vm.stack)
+	vm.stack = stack
+	return nil
+}
+
+// growFrames makes room for count frames, like growStack does for values.
+func (vm *VM) growFrames(count int) error {
+	if count > vm.maxFrames {
+		return errStackOverflow
+	}
+	if count <= len(vm.frames) {
+		return nil
+	}
+	frames := make([]*Frame, min(max(2*len(vm.frames), count), vm.maxFrames))
+	copy(frames, vm.frames)
+	vm.frames = frames
+	return nil
+}
+
 func (vm *VM) pop() object.Object {
 	if vm.stackPointer == 0 {
 		return nil
@@ -282,12 +869,24 @@ func (vm *VM) pop() object.Object {
 	return top
 }
 
-func (vm *VM) executeBinaryOperation(op code.Opcode) error {
-	right := vm.pop()
-	left := vm.pop()
-
-	if right.Type() == object.INTEGER_TYPE && left.Type() == object.INTEGER_TYPE {
-		return vm.executeIntegerBinaryOperation(op, left.(*object.Integer), right.(*object.Integer))
+func (vm *VM) executeBinaryOperation(op code.Opcode, left object.Object, right object.Object) error {
+	if object.IsNumber(left) && object.IsNumber(right) {
+		return vm.executeNumberBinaryOperation(op, left, right)
+	}
+	if right.Type() == object.STRING_TYPE && left.Type() == object.STRING_TYPE && op == code.OpAdd {
+		result, err := left.(*object.String).Add(right)
+		if err != nil {
+			return err
+		}
+		return vm.push(result)
+	}
+	if right.Type() == object.ARRAY_TYPE && left.Type() == object.ARRAY_TYPE && op == code.OpAdd {
+		leftElements := left.(*object.Array).Elements
+		rightElements := right.(*object.Array).Elements
+		concatenated := make([]object.Object, len(leftElements)+len(rightElements))
+		copy(concatenated, leftElements)
+		copy(concatenated[len(leftElements):], rightElements)
+		return vm.push(&object.Array{Elements: concatenated})
 	}
 
 	return fmt.Errorf("unsupported types for binary operation: %s %s", left.Type(), right.Type())
@@ -297,8 +896,11 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 	right := vm.pop()
 	left := vm.pop()
 
-	if right.Type() == object.INTEGER_TYPE && left.Type() == object.INTEGER_TYPE {
-		return vm.executeIntegerComparison(op, left.(*object.Integer), right.(*object.Integer))
+	if object.IsNumber(left) && object.IsNumber(right) {
+		return vm.executeNumberComparison(op, left, right)
+	}
+	if right.Type() == object.STRING_TYPE && left.Type() == object.STRING_TYPE {
+		return vm.executeStringComparison(op, left.(*object.String), right.(*object.String))
 	}
 
 	switch op {
@@ -311,26 +913,48 @@ func (vm *VM) executeComparison(op code.Opcode) error {
 	}
 }
 
-func (vm *VM) executeIntegerBinaryOperation(op code.Opcode, left *object.Integer, right *object.Integer) error {
-	var result object.Integer
+// executeNumberBinaryOperation applies op to two numbers, any of which may be a
+// BigInt or a Float.
+func (vm *VM) executeNumberBinaryOperation(op code.Opcode, left object.Object, right object.Object) error {
+	var operator string
 	switch op {
 	case code.OpAdd:
-		result = object.Integer{Value: left.Value + right.Value}
+		operator = "+"
 	case code.OpDiv:
-		result = object.Integer{Value: left.Value / right.Value}
+		operator = "/"
 	case code.OpMul:
-		result = object.Integer{Value: left.Value * right.Value}
+		operator = "*"
 	case code.OpSub:
-		result = object.Integer{Value: left.Value - right.Value}
+		operator = "-"
 	default:
 		return fmt.Errorf("unknown integer operator: %d (%T)", op, op)
 	}
 
-	vm.push(&result)
+	result, err := object.Arithmetic(operator, left, right)
+	if err != nil {
+		return err
+	}
+	return vm.push(result)
+}
+
+func (vm *VM) executeNumberComparison(op code.Opcode, left object.Object, right object.Object) error {
+	var result *object.Boolean
+	switch op {
+	case code.OpEqual:
+		result = nativeBoolToBooleanObject(object.CompareNumbers(left, right) == 0)
+	case code.OpNotEqual:
+		result = nativeBoolToBooleanObject(object.CompareNumbers(left, right) != 0)
+	case code.OpGreaterThan:
+		result = nativeBoolToBooleanObject(object.CompareNumbers(left, right) > 0)
+	default:
+		return fmt.Errorf("unknown integer comparison operator: %d (%T)", op, op)
+	}
+
+	vm.push(result)
 	return nil
 }
 
-func (vm *VM) executeIntegerComparison(op code.Opcode, left *object.Integer, right *object.Integer) error {
+func (vm *VM) executeStringComparison(op code.Opcode, left *object.String, right *object.String) error {
 	var result *object.Boolean
 	switch op {
 	case code.OpEqual:
@@ -340,11 +964,56 @@ func (vm *VM) executeIntegerComparison(op code.Opcode, left *object.Integer, rig
 	case code.OpGreaterThan:
 		result = nativeBoolToBooleanObject(left.Value > right.Value)
 	default:
-		return fmt.Errorf("unknown integer comparison operator: %d (%T)", op, op)
+		return fmt.Errorf("unknown string comparison operator: %d (%T)", op, op)
 	}
 
-	vm.push(result)
-	return nil
+	return vm.push(result)
+}
+
+func (vm *VM) executeIndexExpression(left object.Object, index object.Object) error {
+	switch {
+	case left.Type() == object.ARRAY_TYPE && index.Type() == object.INTEGER_TYPE:
+		elements := left.(*object.Array).Elements
+		idx := index.(*object.Integer).Value
+		if idx < 0 || idx > int64(len(elements)-1) {
+			return fmt.Errorf("array index out of bounds: size=%d, index=%d", len(elements), idx)
+		}
+		return vm.push(elements[idx])
+	case left.Type() == object.STRING_TYPE && index.Type() == object.INTEGER_TYPE:
+		str := left.(*object.String)
+		idx := index.(*object.Integer).Value
+		char, ok := str.CharAt(idx)
+		if !ok {
+			return fmt.Errorf("string index out of bounds: size=%d, index=%d", str.Len(), idx)
+		}
+		return vm.push(char)
+	case left.Type() == object.HASH_TYPE:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		value, ok := left.(*object.Hash).Get(key)
+		if !ok {
+			return vm.push(NULL)
+		}
+		return vm.push(value)
+	default:
+		return fmt.Errorf("index operator not supported: %s", left.Type())
+	}
+}
+
+// buildHash builds a hash from the keys and values on the stack between start
+// and end, with each key directly below its value.
+func (vm *VM) buildHash(start, end int) (*object.Hash, error) {
+	hash := object.NewHash()
+	for i := start; i < end; i += 2 {
+		key, ok := vm.stack[i].(object.Hashable)
+		if !ok {
+			return nil, fmt.Errorf("key type is not hashable: %s", vm.stack[i].Type())
+		}
+		hash.Set(key, vm.stack[i+1])
+	}
+	return hash, nil
 }
 
 func nativeBoolToBooleanObject(val bool) *object.Boolean {
@@ -1,12 +1,19 @@
 package vm
 
 import (
+	"bytes"
 	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"interpego/ast"
 	"interpego/compiler"
 	"interpego/lexer"
+	"interpego/module"
 	"interpego/object"
 	"interpego/parser"
 )
@@ -49,24 +56,74 @@ type vmTestCase struct {
 func runVmTests(t *testing.T, tests []vmTestCase) {
 	t.Helper()
 
-	for i, tt := range tests {
-		program := parse(tt.input)
-		compiler := compiler.New()
-		err := compiler.Compile(program)
-		if err != nil {
-			t.Fatalf("tests[%d]: compiler error: %s", i, err)
+	// every program must behave the same with and without optimisations
+	for _, optimize := range []bool{false, true} {
+		for i, tt := range tests {
+			program := parse(tt.input)
+			compiler := compiler.New()
+			compiler.SetOptimize(optimize)
+			err := compiler.Compile(program)
+			if err != nil {
+				t.Fatalf("tests[%d] (optimize=%t): compiler error: %s", i, optimize, err)
+			}
+
+			vm := New(compiler.Bytecode())
+			err = vm.Run()
+			if err != nil {
+				t.Fatalf("tests[%d] (optimize=%t): vm error: %s", i, optimize, err)
+			}
+			stackElem := vm.LastPoppedStackElement()
+			testExpectedObject(t, stackElem, tt.expected)
 		}
+	}
+}
 
-		vm := New(compiler.Bytecode())
-		err = vm.Run()
-		if err != nil {
-			t.Fatalf("tests[%d]: vm error: %s", i, err)
+type vmErrorTestCase struct {
+	input    string
+	expected string
+}
+
+func runVmErrorTests(t *testing.T, tests []vmErrorTestCase) {
+	t.Helper()
+
+	// every program must fail the same way with and without optimisations
+	for _, optimize := range []bool{false, true} {
+		for i, tt := range tests {
+			compiler := compiler.New()
+			compiler.SetOptimize(optimize)
+			err := compiler.Compile(parse(tt.input))
+			if err != nil {
+				t.Fatalf("tests[%d] (optimize=%t): compiler error: %s", i, optimize, err)
+			}
+
+			err = New(compiler.Bytecode()).Run()
+			if _, ok := err.(*RuntimeError); !ok {
+				t.Fatalf("tests[%d] (optimize=%t): error is not *RuntimeError. got=%T (%+v)", i, optimize, err, err)
+			}
+			if err.Error() != tt.expected {
+				t.Errorf("tests[%d] (optimize=%t): wrong error. want=%q, got=%q", i, optimize, tt.expected, err.Error())
+			}
 		}
-		stackElem := vm.LastPoppedStackElement()
-		testExpectedObject(t, stackElem, tt.expected)
 	}
 }
 
+// runVmError compiles and runs input, which must fail with a *RuntimeError.
+func runVmError(t *testing.T, input string) *RuntimeError {
+	t.Helper()
+
+	compiler := compiler.New()
+	err := compiler.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	err = New(compiler.Bytecode()).Run()
+	runtimeErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("error is not *RuntimeError. got=%T (%+v)", err, err)
+	}
+	return runtimeErr
+}
+
 func testBooleanObject(actual object.Object, expected bool) error {
 	boolean, ok := actual.(*object.Boolean)
 	if !ok {
@@ -105,6 +162,42 @@ func testExpectedObject(t *testing.T, actual object.Object, expected interface{}
 		if err != nil {
 			t.Errorf("testBooleanObject failed: %s", err)
 		}
+	case []int:
+		array, ok := actual.(*object.Array)
+		if !ok {
+			t.Errorf("object not Array: %T (%+v)", actual, actual)
+			return
+		}
+		if len(array.Elements) != len(expected) {
+			t.Errorf("wrong num of elements. want=%d, got=%d", len(expected), len(array.Elements))
+			return
+		}
+		for i, expectedElem := range expected {
+			err := testIntegerObject(array.Elements[i], int64(expectedElem))
+			if err != nil {
+				t.Errorf("testIntegerObject failed: %s", err)
+			}
+		}
+	case []string:
+		array, ok := actual.(*object.Array)
+		if !ok {
+			t.Errorf("object not Array: %T (%+v)", actual, actual)
+			return
+		}
+		if len(array.Elements) != len(expected) {
+			t.Errorf("wrong num of elements. want=%d, got=%d", len(expected), len(array.Elements))
+			return
+		}
+		for i, expectedElem := range expected {
+			err := testStringObject(array.Elements[i], expectedElem)
+			if err != nil {
+				t.Errorf("testStringObject failed: %s", err)
+			}
+		}
+	case *object.Null:
+		if actual != NULL {
+			t.Errorf("object is not Null: %T (%+v)", actual, actual)
+		}
 	case nil:
 
 	default:
@@ -189,6 +282,10 @@ func TestGlobalLetStatements(t *testing.T) {
 		{"let one = 1; one", 1},
 		{"let one = 1; let two = 2; one + two", 3},
 		{"let one = 1; let two = one + one; one + two", 3},
+		{"let countDown = fn(n) { if (n == 0) { 0 } else { 1 + countDown(n - 1) } }; countDown(10)", 10},
+		// a let in a block rebinds the name, so it isn't a constant
+		{"let x = 1; let y = if (true) { let x = 2; x } else { 0 }; x", 2},
+		{"let f = fn() { let x = 1; return [if (true) { let x = 2; x } else { 0 }, x][1]; }; f()", 2},
 	}
 	runVmTests(t, tests)
 }
@@ -384,6 +481,7 @@ func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 			expected: `wrong number of arguments: want=0, got=1`,
 		},
 		{
+			input:    `fn(a) { a; }();`,
 			expected: `wrong number of arguments: want=1, got=0`,
 		},
 		{
@@ -403,8 +501,876 @@ func TestCallingFunctionsWithWrongArguments(t *testing.T) {
 		if err == nil {
 			t.Fatalf("expected VM error but resulted in none.")
 		}
-		if err.Error() != tt.expected {
-			t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, err)
+		runtimeErr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("error is not *RuntimeError. got=%T (%+v)", err, err)
+		}
+		if runtimeErr.Message != tt.expected {
+			t.Fatalf("wrong VM error: want=%q, got=%q", tt.expected, runtimeErr.Message)
+		}
+	}
+}
+
+func TestArithmeticErrors(t *testing.T) {
+	// the optimiser must leave these for the program to raise
+	tests := []vmErrorTestCase{
+		{"1 / 0", "1:3: division by zero"},
+		{"let f = fn(x) { 10 / x }; f(0)", "1:20: division by zero"},
+		{"99999999999999999999 / 0", "1:22: division by zero"},
+	}
+	runVmErrorTests(t, tests)
+}
+
+func TestRuntimeErrorPositions(t *testing.T) {
+	input := `let inner = fn(a) {
+  a + true
+};
+let outer = fn() {
+  inner(1) * 2
+};
+outer();`
+	runtimeErr := runVmError(t, input)
+
+	expectedError := "2:5: unsupported types for binary operation: INTEGER BOOLEAN"
+	if runtimeErr.Error() != expectedError {
+		t.Errorf("wrong error. want=%q, got=%q", expectedError, runtimeErr.Error())
+	}
+
+	expectedBacktrace := `inner(1)
+	2:5
+outer()
+	5:8
+main()
+	7:6
+`
+	if runtimeErr.Backtrace.String() != expectedBacktrace {
+		t.Errorf("wrong backtrace.\nwant=%q\ngot=%q", expectedBacktrace, runtimeErr.Backtrace.String())
+	}
+}
+
+func TestStringExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`"monkey"`, "monkey"},
+		{`"mon" + "key"`, "monkey"},
+		{`"mon" + "key" + "banana"`, "monkeybanana"},
+		{`"a" < "b"`, true},
+		{`"b" < "a"`, false},
+		{`"abc" > "abd"`, false},
+		{`"ab" < "abc"`, true},
+		{`"monkey" == "mon" + "key"`, true},
+		{`"monkey" != "mon" + "key"`, false},
+		{`let f = fn(a, b) { a == b }; f("x", "x")`, true},
+		{`let f = fn(a, b) { a != b }; f("x", "y")`, true},
+	}
+	runVmTests(t, tests)
+}
+
+func TestBigIntegers(t *testing.T) {
+	tests := []vmTestCase{
+		{"(9223372036854775807 + 10) - 20", 9223372036854775797},
+		{"9223372036854775807 + 1 > 9223372036854775807", true},
+		{"-99999999999999999999 < 1", true},
+		{"99999999999999999999 == 99999999999999999990 + 9", true},
+		{"99999999999999999999 != 99999999999999999999", false},
+		{`"${9223372036854775807 + 1}"`, "9223372036854775808"},
+		{`"${-9223372036854775807 - 2}"`, "-9223372036854775809"},
+		{`let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; "${fact(25)}"`, "15511210043330985984000000"},
+		{`"${100000000000000000000 / 10}"`, "10000000000000000000"},
+		{`let min = -9223372036854775807 - 1; "${-min} ${min / -1}"`, "9223372036854775808 9223372036854775808"},
+	}
+	runVmTests(t, tests)
+}
+
+func TestInterpolatedStrings(t *testing.T) {
+	tests := []vmTestCase{
+		{`"1 + 2 = ${1 + 2}"`, "1 + 2 = 3"},
+		{`let name = "monkey"; "hello ${name}!"`, "hello monkey!"},
+		{`let items = [1, 2]; "${len(items)} items: ${items}"`, "2 items: [1, 2]"},
+		{`let f = fn(x) { "<${x}>" }; f(true) + f("s")`, "<true><s>"},
+		{`"${"nested ${1 < 2}"}"`, "nested true"},
+	}
+	runVmTests(t, tests)
+}
+
+func TestIndexExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"[1, 2, 3][1]", 2},
+		{"[[1, 1, 1]][0][0]", 1},
+		{"let i = 0; [1][i]", 1},
+		{`"monkey"[0]`, "m"},
+		{`"monkey"[5]`, "y"},
+		{`"héllo"[1]`, "é"},
+		{`"日本語"[2]`, "語"},
+		{`len("日本語")`, 3},
+		{`{"one": 1, "two": 2}["two"]`, 2},
+		{`{1: "a"}[1]`, "a"},
+		{`{true: 1}[false]`, NULL},
+		{`{99999999999999999999: 1}[99999999999999999999]`, 1},
+	}
+	runVmTests(t, tests)
+
+	errorTests := []vmErrorTestCase{
+		{"[1, 2, 3][3]", "1:10: array index out of bounds: size=3, index=3"},
+		{"[1, 2, 3][-1]", "1:10: array index out of bounds: size=3, index=-1"},
+		{`"日本語"[3]`, "1:6: string index out of bounds: size=3, index=3"},
+		{`"abc"[-1]`, "1:6: string index out of bounds: size=3, index=-1"},
+		{`1[0]`, "1:2: index operator not supported: INTEGER"},
+		{`{}[[1]]`, "1:3: unusable as hash key: ARRAY"},
+		{`{[1]: 1}`, "1:1: key type is not hashable: ARRAY"},
+	}
+	runVmErrorTests(t, errorTests)
+}
+
+func TestArrayLiterals(t *testing.T) {
+	tests := []vmTestCase{
+		{"[]", []int{}},
+		{"[1, 2, 3]", []int{1, 2, 3}},
+		{"[1 + 2, 3 * 4, 5 + 6]", []int{3, 12, 11}},
+		{"[1, 2] + [3]", []int{1, 2, 3}},
+	}
+	runVmTests(t, tests)
+}
+
+func TestHashLiterals(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"{}", "({})"},
+		{`{"b": 1, "a": 2 * 3, 4: true}`, "({b: 1, a: 6, 4: true})"},
+		{`let k = "x"; {k: 1, "y": 2, k: 3}`, "({x: 3, y: 2})"},
+	}
+	for i, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("tests[%d]: compiler error: %s", i, err)
+		}
+		vm := New(comp.Bytecode())
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("tests[%d]: vm error: %s", i, err)
+		}
+		if got := vm.LastPoppedStackElement().Inspect(); got != tt.expected {
+			t.Errorf("tests[%d]: wrong hash. want=%s, got=%s", i, tt.expected, got)
 		}
 	}
 }
+
+func TestBuiltinFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{`len("four")`, 4},
+		{`len([1, 2, 3])`, 3},
+		{`first([1, 2, 3])`, 1},
+		{`last([1, 2, 3])`, 3},
+		{`rest([1, 2, 3])`, []int{2, 3}},
+		{`rest([])`, NULL},
+		{`push([1], 2)`, []int{1, 2}},
+		{`let len = fn(x) { 42 }; len([])`, 42},
+	}
+	runVmTests(t, tests)
+
+	errorTests := []vmErrorTestCase{
+		{`len(1)`, "1:4: argument to `len` not supported, got INTEGER"},
+		{`first([])`, "1:6: array index out of bounds: size=0, index=0"},
+	}
+	runVmErrorTests(t, errorTests)
+}
+
+func TestStringBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`split("a,b,,c", ",")`, []string{"a", "b", "", "c"}},
+		{`split("héllo", "")`, []string{"h", "é", "l", "l", "o"}},
+		{`join(["a", "b", "c"], ", ")`, "a, b, c"},
+		{`join([], "-")`, ""},
+		{`trim("  \t hi there \n")`, "hi there"},
+		{`contains("monkey", "key")`, true},
+		{`contains("monkey", "ape")`, false},
+		{`contains([1, "two", [3]], [3])`, true},
+		{`contains([1, 2], "1")`, false},
+		{`startsWith("monkey", "mon")`, true},
+		{`endsWith("monkey", "mon")`, false},
+		{`replace("a-b-c", "-", "+")`, "a+b+c"},
+		{`upper("héllo")`, "HÉLLO"},
+		{`lower("MONKEY")`, "monkey"},
+		{`indexOf("日本語です", "語")`, 2},
+		{`indexOf("monkey", "z")`, -1},
+		{`indexOf([true, false], false)`, 1},
+		{`substr("héllo", 1, 3)`, "éll"},
+		{`substr("héllo", 2)`, "llo"},
+		{`substr("abc", 3, 0)`, ""},
+		{`repeat("ab", 3)`, "ababab"},
+		{`chars("añb")`, []string{"a", "ñ", "b"}},
+		{`format("%s has %d items: %v (%q) 100%%", "list", 3, [1, 2, 3], "x")`, `list has 3 items: [1, 2, 3] ("x") 100%`},
+		{`sprintf("%d", 99999999999999999999)`, "99999999999999999999"},
+		{`contains("monkey", "key") == true`, true},
+	}
+	runVmTests(t, tests)
+
+	errorTests := []vmErrorTestCase{
+		{`split("a")`, "1:6: wrong number of arguments. got=1, want=2"},
+		{`split("a", 1)`, "1:6: argument 2 to `split` must be STRING, got INTEGER"},
+		{`join(["a", 1], "")`, "1:5: argument 1 to `join` must only contain STRING, got INTEGER at index 1"},
+		{`contains(1, 1)`, "1:9: argument 1 to `contains` must be STRING or ARRAY, got INTEGER"},
+		{`substr("abc", 2, 2)`, "1:7: substr out of bounds: size=3, start=2, length=2"},
+		{`substr("abc", -1)`, "1:7: substr out of bounds: size=3, start=-1, length=4"},
+		{`repeat("a", -1)`, "1:7: argument 2 to `repeat` must not be negative, got -1"},
+		{`format("%d", "x")`, "1:7: %d expects INTEGER, got STRING"},
+		{`format("%s %s", "x")`, "1:7: not enough arguments for format string: %s has no value"},
+		{`format("%s", "x", "y")`, "1:7: too many arguments for format string: 1 unused"},
+		{`format("%z", 1)`, "1:7: unknown format verb %z"},
+	}
+	runVmErrorTests(t, errorTests)
+}
+
+func TestCollectionBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`map(fn(x) { x * x }, [1, 2, 3])`, []int{1, 4, 9}},
+		{`map(len, ["a", "bc"])`, []int{1, 2}},
+		{`let add = fn(x, acc) { acc + x }; reduce(add, [1, 2, 3], 10)`, 16},
+		{`filter(fn(x) { x > 1 }, [1, 2, 3])`, []int{2, 3}},
+		{`find(fn(x) { x > 1 }, [1, 2, 3])`, 2},
+		{`find(fn(x) { x > 5 }, [1, 2, 3])`, NULL},
+		{`any(fn(x) { x > 2 }, [1, 2, 3])`, true},
+		{`all(fn(x) { x > 2 }, [1, 2, 3])`, false},
+		{`compose(fn(x) { x + 1 }, fn(x) { x * 10 })(3)`, 31},
+		{`compose(len)("abc")`, 3},
+		{`map(compose(fn(x) { x * 2 }, len, trim), [" a ", "bc"])`, []int{2, 4}},
+		{`let add = fn(a, b) { a + b }; compose(fn(x) { -x }, add)(1, 2)`, -3},
+		{`compose(compose(fn(x) { x + 1 }, fn(x) { x + 1 }), fn(x) { x * 2 })(5)`, 12},
+		{`sort([3, 1, 2])`, []int{1, 2, 3}},
+		{`sort(["b", "c", "a"])`, []string{"a", "b", "c"}},
+		{`sort([3, 1, 2], fn(a, b) { a > b })`, []int{3, 2, 1}},
+		{`reverse([1, 2, 3])`, []int{3, 2, 1}},
+		{`reverse("héllo")`, "olléh"},
+		{`slice([1, 2, 3, 4], 1, 3)`, []int{2, 3}},
+		{`range(4)`, []int{0, 1, 2, 3}},
+		{`range(10, 0, -4)`, []int{10, 6, 2}},
+		{`len(zip([1, 2, 3], ["a", "b"]))`, 2},
+		{`flatten([[1, 2], 3, []])`, []int{1, 2, 3}},
+		{`keys({"b": 1, "a": 2})`, []string{"b", "a"}},
+		{`values({"b": 1, "a": 2})`, []int{1, 2}},
+		{`has({"a": 1}, "a")`, true},
+		{`keys(delete({"a": 1, "b": 2, "c": 3}, "b"))`, []string{"a", "c"}},
+		{`values(merge({"a": 1, "b": 2}, {"c": 3, "a": 4}))`, []int{4, 2, 3}},
+		// callbacks run nested inside other calls and other callbacks
+		{`let sum = fn(xs) { reduce(fn(x, acc) { acc + x }, xs, 0) }; sum(map(fn(xs) { sum(xs) }, [[1, 2], [3]]))`, 6},
+		{`let fact = fn(n) { if (n == 0) { 1 } else { n * fact(n - 1) } }; map(fact, range(5))`, []int{1, 1, 2, 6, 24}},
+	}
+	runVmTests(t, tests)
+
+	errorTests := []vmErrorTestCase{
+		{`map([1], fn(x) { x })`, "1:4: argument 1 to `map` must be FUNCTION, got ARRAY"},
+		{`filter(fn(x) { x }, [1])`, "1:7: function passed to `filter` must return BOOLEAN, got INTEGER"},
+		{`map(fn(x, y) { x }, [1])`, "1:4: wrong number of arguments: want=2, got=1"},
+		{`sort([1, "a"])`, "1:5: `sort` without a comparator needs all INTEGER or all STRING elements, got INTEGER and STRING"},
+		{`range(1, 2, 0)`, "1:6: argument 3 to `range` must not be zero"},
+		{`has({}, [1])`, "1:4: unusable as hash key: ARRAY"},
+		{`compose()`, "1:8: wrong number of arguments. got=0, want at least 1"},
+		{`compose(len, 1)`, "1:8: argument 2 to `compose` must be FUNCTION, got INTEGER"},
+		{`compose(fn(x) { x + true }, len)("ab")`, "1:19: unsupported types for binary operation: INTEGER BOOLEAN"},
+	}
+	runVmErrorTests(t, errorTests)
+}
+
+func TestCallbackErrorPositions(t *testing.T) {
+	input := `let check = fn(x) {
+  x + true
+};
+map(fn(x) { check(x) * 2 }, [1]);`
+	runtimeErr := runVmError(t, input)
+
+	expectedError := "2:5: unsupported types for binary operation: INTEGER BOOLEAN"
+	if runtimeErr.Error() != expectedError {
+		t.Errorf("wrong error. want=%q, got=%q", expectedError, runtimeErr.Error())
+	}
+	expectedBacktrace := "check(1)\n\t2:5\n<anonymous>(1)\n\t4:18\nmain()\n\t4:4\n"
+	if runtimeErr.Backtrace.String() != expectedBacktrace {
+		t.Errorf("wrong backtrace.\nwant=%q\ngot=%q", expectedBacktrace, runtimeErr.Backtrace.String())
+	}
+}
+
+func TestIOBuiltins(t *testing.T) {
+	input := `let name = readLine();
+println("hello", name, [1, 2]);
+eprint("warning:", 42);
+print(readLine());
+let echo = fn() { println(readLine()) };
+map(fn(x) { echo() }, [1, 2]);
+readLine()`
+
+	comp := compiler.New()
+	err := comp.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	var stdout, stderr bytes.Buffer
+	vm := New(comp.Bytecode())
+	vm.SetIO(object.NewIO(&stdout, &stderr, strings.NewReader("monkey\r\nsecond\nlast")))
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, vm.LastPoppedStackElement(), NULL)
+
+	expectedStdout := "hello monkey [1, 2]\nsecond\nlast\nnull\n"
+	if stdout.String() != expectedStdout {
+		t.Errorf("wrong stdout. want=%q, got=%q", expectedStdout, stdout.String())
+	}
+	if stderr.String() != "warning: 42\n" {
+		t.Errorf("wrong stderr. want=%q, got=%q", "warning: 42\n", stderr.String())
+	}
+}
+
+func TestJSONBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`let doc = json.parse("{\"b\": {\"c\": [1, 2]}, \"a\": 1}"); doc.b.c[1] + doc.a`, 3},
+		{`keys(json.parse("{\"b\": 1, \"a\": 2, \"b\": 3}"))`, []string{"b", "a"}},
+		{`json.parse("[1, 2]")`, []int{1, 2}},
+		{`json.parse("true")`, true},
+		{`json.parse("null")`, NULL},
+		{`"${json.parse("0.5") * 3}"`, "1.5"},
+		{`json.parse("1.5") > 1`, true},
+		{`-json.parse("2.0") == -2`, true},
+		{`json.stringify({"b": [1, "two"], "a": {}, "c": json.parse("null")})`, `{"b":[1,"two"],"a":{},"c":null}`},
+		{`json.stringify({"a": [1]}, 2)`, "{\n  \"a\": [\n    1\n  ]\n}"},
+		{`let s = "{\"k\":[1,2.5,{\"n\":null}]}"; json.stringify(json.parse(s)) == s`, true},
+	}
+	runVmTests(t, tests)
+
+	errorTests := []vmErrorTestCase{
+		{`json.parse("{")`, "1:11: `json.parse` failed: unexpected end of JSON input"},
+		{`json.stringify([fn() { 1 }])`, "1:15: `json.stringify` failed: COMPILED_FUNCTION at $[0] can't be converted to JSON"},
+		{`json.stringify({true: 1})`, "1:15: `json.stringify` failed: hash key true at $ must be STRING or INTEGER, got BOOLEAN"},
+	}
+	runVmErrorTests(t, errorTests)
+}
+
+func TestMathBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`math.abs(-3)`, 3},
+		{`"${math.abs(json.parse("-2.5"))}"`, "2.5"},
+		{`math.min(3, 1, 2)`, 1},
+		{`math.max(3, 5, 4)`, 5},
+		{`math.clamp(15, 0, 10)`, 10},
+		{`"${math.pow(2, 100)}"`, "1267650600228229401496703205376"},
+		{`"${math.pow(2, -1)}"`, "0.5"},
+		{`"${math.sqrt(16)}"`, "4.0"},
+		{`math.floor(json.parse("-2.5"))`, -3},
+		{`math.ceil(json.parse("2.1"))`, 3},
+		{`math.floor(math.pi * 100)`, 314},
+		{`let r = math.random(); r < 1`, true},
+		{`let n = math.randomInt(3, 5); n > 2 == (n < 5)`, true},
+	}
+	runVmTests(t, tests)
+
+	errorTests := []vmErrorTestCase{
+		{`math.abs("1")`, "1:9: argument 1 to `math.abs` must be NUMBER, got STRING"},
+		{`math.sqrt(-4)`, "1:10: argument 1 to `math.sqrt` must not be negative, got -4"},
+		{`math.randomInt(5, 5)`, "1:15: `math.randomInt` needs lo < hi, got 5 and 5"},
+	}
+	runVmErrorTests(t, errorTests)
+}
+
+func TestSeededRandom(t *testing.T) {
+	run := func(input string, seed int64) string {
+		comp := compiler.New()
+		err := comp.Compile(parse(input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+		vm := New(comp.Bytecode())
+		vm.SetRand(rand.New(rand.NewSource(seed)))
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+		return vm.LastPoppedStackElement().Inspect()
+	}
+
+	// the same seed, given by the host or by the program, gives the same numbers
+	input := `[math.random(), math.randomInt(-100, 100), math.randomInt(0, 99999999999)]`
+	first, second := run(input, 7), run(input, 7)
+	if first != second {
+		t.Errorf("same seed gave different numbers: %s and %s", first, second)
+	}
+	if seeded := run(`math.seed(7); `+input, 1); seeded != first {
+		t.Errorf("math.seed gave different numbers: %s and %s", first, seeded)
+	}
+}
+
+func TestTimeBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{`time.format(time.now())`, "2024-02-28T09:30:00Z"},
+		{`time.format(time.add(time.now(), "36h"), time.date)`, "2024-02-29"},
+		{`time.diff(time.now(), time.parse(time.date, "2024-02-28"))`, 34200000},
+		{`let start = time.now(); time.diff(time.add(start, 250), start)`, 250},
+	}
+	for i, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("tests[%d]: compiler error: %s", i, err)
+		}
+		vm := New(comp.Bytecode())
+		vm.SetClock(object.FixedClock(time.Date(2024, 2, 28, 9, 30, 0, 0, time.UTC)))
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("tests[%d]: vm error: %s", i, err)
+		}
+		testExpectedObject(t, vm.LastPoppedStackElement(), tt.expected)
+	}
+}
+
+func TestRegexBuiltins(t *testing.T) {
+	tests := []vmTestCase{
+		{"regex.match(`^\\d+$`, \"123\")", true},
+		{"regex.find(`\\d+`, \"ab 42 7\")", "42"},
+		{"regex.find(`(\\w+)@(\\w+)`, \"mail bob@home\")", []string{"bob@home", "bob", "home"}},
+		{"regex.find(`x`, \"abc\")", NULL},
+		{"regex.findAll(`\\d+`, \"a1b22c333\")", []string{"1", "22", "333"}},
+		{"regex.replace(`(\\w+)@(\\w+)`, \"bob@home\", \"$2:$1\")", "home:bob"},
+		{"regex.split(`\\s*,\\s*`, \"a , b,c\")", []string{"a", "b", "c"}},
+		{"let words = fn(s) { regex.findAll(`\\w+`, s) }; map(len, words(\"to be or\"))", []int{2, 2, 2}},
+	}
+	runVmTests(t, tests)
+
+	errorTests := []vmErrorTestCase{
+		{`regex.match("(", "x")`, "1:12: `regex.match` failed: error parsing regexp: missing closing ): `(`"},
+	}
+	runVmErrorTests(t, errorTests)
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []vmTestCase{
+		{
+			// 2^17 elements, summed one call per element, far more calls
+			// than there are frames
+			`let double = fn(xs, n) { if (n == 0) { xs } else { double(xs + xs, n - 1) } };
+let sum = fn(xs, acc) {
+  if (len(xs) == 0) { return acc; }
+  sum(rest(xs), acc + first(xs))
+};
+sum(double([1], 17), 0)`,
+			131072,
+		},
+		{
+			`let count = fn(n, acc) { if (n == 0) { acc } else { count(n - 1, acc + 1) } };
+let start = fn(n) { count(n, 0) };
+start(100000) + 1`,
+			100001,
+		},
+		{
+			// the rest of the array is shared rather than copied, and the
+			// call in the arm's result is a tail call
+			`let sum = fn(xs, acc) { match (xs) { [] => acc, [h, ...t] => sum(t, acc + h) } };
+sum(range(100000), 0)`,
+			4999950000,
+		},
+		{
+			// not a tail call, but the tail calls below it still reuse frames
+			`let inner = fn(n) { if (n == 0) { 1 } else { inner(n - 1) } };
+let f = fn(n) { if (n == 0) { 0 } else { inner(5000) + f(n - 1) } };
+f(100)`,
+			100,
+		},
+	}
+	runVmTests(t, tests)
+
+	// a tail call replaces its caller's frame in the backtrace
+	input := `let inner = fn(a) {
+  a + true
+};
+let outer = fn() {
+  inner(1)
+};
+outer();`
+	runtimeErr := runVmError(t, input)
+	expectedBacktrace := "inner(1)\n\t2:5\nmain()\n\t7:6\n"
+	if runtimeErr.Backtrace.String() != expectedBacktrace {
+		t.Errorf("wrong backtrace.\nwant=%q\ngot=%q", expectedBacktrace, runtimeErr.Backtrace.String())
+	}
+}
+
+func TestWideOperands(t *testing.T) {
+	// more constants than OpConstant can address
+	var constants strings.Builder
+	for i := 0; i <= 70000; i++ {
+		fmt.Fprintf(&constants, "%d; ", i)
+	}
+
+	// a branch longer than 64KiB of instructions
+	var branch strings.Builder
+	branch.WriteString("let f = fn(x) { if (x) { ")
+	for i := 0; i < 25000; i++ {
+		branch.WriteString("1; ")
+	}
+	branch.WriteString("2 } else { 3 } }; f(true) + f(false)")
+
+	// more locals than OpGetLocal can address
+	var locals strings.Builder
+	locals.WriteString("let f = fn(p) { ")
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&locals, "let x%s = %d; ", strings.Repeat("y", i), i)
+	}
+	fmt.Fprintf(&locals, "x%s + p }; f(1)", strings.Repeat("y", 299))
+
+	tests := []vmTestCase{
+		{constants.String(), 70000},
+		{branch.String(), 5},
+		{locals.String(), 300},
+	}
+	runVmTests(t, tests)
+}
+
+func TestSuperinstructions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let f = fn(a) { a + 1 }; f(41)", 42},
+		{"let square = fn(x) { x * x }; square(7)", 49},
+		{"let f = fn(a, b) { if (a) { if (b) { 1 } else { 2 } } else { 3 } }; f(true, false)", 2},
+		{"let f = fn(a) { if (a) { 1 }; 2 }; f(false)", 2},
+	}
+	runVmTests(t, tests)
+}
+
+// benchmarkInput spends its time in the patterns the peephole pass rewrites:
+// locals used twice in a row, additions of constants and nested ifs.
+const benchmarkInput = `
+let step = fn(n, odd) {
+	let sq = n * n;
+	let next = if (odd) { if (sq > 100) { sq + 3 } else { sq + 1 } } else { n + 2 };
+	next + next + 1
+};
+let run = fn(n) {
+	step(n, true) + step(n + 1, false) + step(n + 2, true) + step(n + 3, false)
+};
+run(1) + run(2) + run(3) + run(4) + run(5) + run(6) + run(7) + run(8)
+`
+
+func BenchmarkPeephole(b *testing.B) {
+	for _, optimize := range []bool{false, true} {
+		b.Run(fmt.Sprintf("optimize=%t", optimize), func(b *testing.B) {
+			compiler := compiler.New()
+			compiler.SetOptimize(optimize)
+			err := compiler.Compile(parse(benchmarkInput))
+			if err != nil {
+				b.Fatalf("compiler error: %s", err)
+			}
+			bytecode := compiler.Bytecode()
+			// share the globals so allocating them doesn't swamp the measurement
+			globals := make([]object.Object, GLOBALS_SIZE)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := NewWithGlobals(globals, bytecode).Run()
+				if err != nil {
+					b.Fatalf("vm error: %s", err)
+				}
+			}
+		})
+	}
+}
+
+func TestGrowingStacks(t *testing.T) {
+	var globals strings.Builder
+	for i := 0; i < 3*INITIAL_GLOBALS_SIZE; i++ {
+		fmt.Fprintf(&globals, "let g%s = %d; ", strings.Repeat("g", i), i)
+	}
+	globals.WriteString("g + g" + strings.Repeat("g", 3*INITIAL_GLOBALS_SIZE-1))
+
+	tests := []vmTestCase{
+		// far deeper than the initial frames and stack, and not a tail call
+		{"let f = fn(n) { if (n == 0) { 0 } else { 1 + f(n - 1) } }; f(10000)", 10000},
+		{globals.String(), 3*INITIAL_GLOBALS_SIZE - 1},
+	}
+	runVmTests(t, tests)
+}
+
+func TestStackOverflow(t *testing.T) {
+	tests := []struct {
+		input        string
+		maxStackSize int
+		maxFrames    int
+	}{
+		{"let f = fn(n) { 1 + f(n + 1) }; f(0)", DEFAULT_MAX_STACK_SIZE, DEFAULT_MAX_FRAMES},
+		{"let f = fn(n) { 1 + f(n + 1) }; f(0)", DEFAULT_MAX_STACK_SIZE, 200},
+		{"let f = fn(n) { 1 + f(n + 1) }; f(0)", 500, DEFAULT_MAX_FRAMES},
+		{"let f = fn(a, b, c) { 1 + f(a, b, c) }; f(1, 2, 3)", 1000, DEFAULT_MAX_FRAMES},
+	}
+
+	for i, tt := range tests {
+		comp := compiler.New()
+		err := comp.Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("tests[%d]: compiler error: %s", i, err)
+		}
+		vm := New(comp.Bytecode())
+		vm.SetMaxStackSize(tt.maxStackSize)
+		vm.SetMaxFrames(tt.maxFrames)
+		err = vm.Run()
+		runtimeErr, ok := err.(*RuntimeError)
+		if !ok {
+			t.Fatalf("tests[%d]: error is not *RuntimeError. got=%T (%+v)", i, err, err)
+		}
+		if runtimeErr.Message != "stack overflow" {
+			t.Errorf("tests[%d]: wrong error. want=%q, got=%q", i, "stack overflow", runtimeErr.Message)
+		}
+
+		// the innermost and outermost frames are kept, the ones in between
+		// are summarised
+		backtrace := runtimeErr.Backtrace
+		if len(backtrace) != 2*BACKTRACE_DEPTH+1 {
+			t.Fatalf("tests[%d]: wrong backtrace length. want=%d, got=%d", i, 2*BACKTRACE_DEPTH+1, len(backtrace))
+		}
+		if backtrace[0].Function != "f" || backtrace[len(backtrace)-1].Function != "main" {
+			t.Errorf("tests[%d]: wrong outer frames:\n%s", i, backtrace)
+		}
+		if backtrace[BACKTRACE_DEPTH].Elided == 0 {
+			t.Errorf("tests[%d]: expected elided frames in the middle:\n%s", i, backtrace)
+		}
+	}
+}
+
+// writeModules writes each module's source to its path under a new temporary
+// directory, which it returns.
+func writeModules(t *testing.T, modules map[string]string) string {
+	dir := t.TempDir()
+	for path, source := range modules {
+		file := filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestImports(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"lib/util.monkey":     `let helper = import "helper"; let _count = helper.base + 1; let inc = fn() { _count + 1 }; println("loading util"); let name = "util";`,
+		"lib/helper.monkey":   `let base = 40;`,
+		"shared/twice.monkey": `let twice = fn(x) { x * 2 };`,
+		"a.monkey":            `let b = import "b";`,
+		"b.monkey":            `let a = import "a";`,
+		"fails.monkey":        `let f = fn(x) { x + 1 }; f("one");`,
+		"value.monkey":        `let x = 42;`,
+	})
+	newCompiler := func() *compiler.Compiler {
+		comp := compiler.New()
+		comp.SetFile(filepath.Join(dir, "main.monkey"))
+		comp.SetLoader(module.NewLoader([]string{filepath.Join(dir, "shared")}))
+		return comp
+	}
+
+	input := `let _count = 7;
+let u = import "lib/util";
+let again = fn() { import "lib/util.monkey" }();
+let s = import "twice";
+"${u.inc()} ${u.name} ${again.name} ${s.twice(4)} ${u._count} ${_count}"`
+	comp := newCompiler()
+	err := comp.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	var stdout bytes.Buffer
+	vm := New(comp.Bytecode())
+	vm.SetIO(object.NewIO(&stdout, &stdout, strings.NewReader("")))
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	// the module's globals are separate from the program's
+	testExpectedObject(t, vm.LastPoppedStackElement(), "42 util util 8 null 7")
+	// a module only runs the first time it is imported
+	if stdout.String() != "loading util\n" {
+		t.Errorf("wrong stdout. want=%q, got=%q", "loading util\n", stdout.String())
+	}
+
+	// a module first imported inside a function leaves the program's globals alone
+	comp = newCompiler()
+	err = comp.Compile(parse(`let id = fn(x) { x }; let f = fn() { let m = import "value"; m.x }; [id(5), f(), id(5)]`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm = New(comp.Bytecode())
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, vm.LastPoppedStackElement(), []int{5, 42, 5})
+
+	compileErrors := []struct {
+		input    string
+		expected string
+	}{
+		{`import "a"`, `import "a": import cycle: a.monkey -> b.monkey -> a.monkey`},
+		{`import "missing"`, `import "missing": module "missing.monkey" not found`},
+	}
+	for _, tt := range compileErrors {
+		err := newCompiler().Compile(parse(tt.input))
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("wrong compiler error for %s. want=%q, got=%v", tt.input, tt.expected, err)
+		}
+	}
+
+	comp = newCompiler()
+	err = comp.Compile(parse(`import "fails"`))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	err = New(comp.Bytecode()).Run()
+	expected := "1:19: unsupported types for binary operation: STRING INTEGER"
+	runtimeErr, ok := err.(*RuntimeError)
+	if !ok || err.Error() != expected {
+		t.Fatalf("wrong vm error. want=%q, got=%v", expected, err)
+	}
+	var functions []string
+	for _, frame := range runtimeErr.Backtrace {
+		functions = append(functions, frame.Function)
+	}
+	if strings.Join(functions, " ") != "f <module fails> main" {
+		t.Errorf("wrong backtrace. got=%v", functions)
+	}
+}
+
+func TestExceptions(t *testing.T) {
+	tests := []vmTestCase{
+		{`try { 1 } catch (e) { 2 }`, 1},
+		{`try { throw "boom"; 1 } catch (e) { "caught " + e }`, "caught boom"},
+		{`1 + try { throw 1 } catch (e) { e + 1 }`, 3},
+		{`let e = try { [1][5] } catch (e) { e }; [e.kind, e.message]`, []string{"runtime", "array index out of bounds: size=1, index=5"}},
+		{`let e = try { [1][5] } catch (e) { e }; [e.line, e.column]`, []int{1, 18}},
+		{`try { json.parse("[") } catch (e) { e.kind }`, "builtin"},
+		{`try { throw {"kind": "mine"} } catch (e) { e.kind }`, "mine"},
+		{`try { map(fn(x) { throw x * 2 }, [7]) } catch (e) { e }`, 14},
+		{`try { try { throw "a" } catch (e) { throw e + "b" } } catch (e) { e }`, "ab"},
+		{`let f = fn() { try { return 1 } finally { return 2 } }; f()`, 2},
+		{`let f = fn() { try { 1 } finally { [][0] } }; try { f() } catch (e) { e.message }`, "array index out of bounds: size=0, index=0"},
+		{`try { 1 } finally { 2 }`, 1},
+		{`let f = fn(x) { x + try { throw 1 } catch (e) { e } }; f(1) + f(2)`, 5},
+		{`let f = fn(n) { if (n == 0) { throw "bottom" } 1 + f(n - 1) }; try { f(10) } catch (e) { e }`, "bottom"},
+		{`let r = try { 1 } catch (e) { 0 } finally { 5 }; let s = [1, 2, 3]; len(s) + r`, 4},
+		{`let n = 0; let r = try { n } catch (e) { 0 } finally { let n = 5; }; [r, n]`, []int{0, 5}},
+		{`let e = 1; try { throw 2 } catch (e) { 3 }; e`, 2},
+		{`let f = fn(x) { try { if (x) { return 1 } 2 } finally { let n = 3; }; n }; f(false)`, 3},
+	}
+	runVmTests(t, tests)
+
+	// finally blocks run on every way out, innermost first
+	input := `let f = fn(x) {
+  try {
+    try { if (x) { return "early" } throw "late" } finally { println("inner") }
+  } catch (e) { e } finally { println("outer") }
+};
+[f(true), f(false)]`
+	comp := compiler.New()
+	err := comp.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	var stdout bytes.Buffer
+	vm := New(comp.Bytecode())
+	vm.SetIO(object.NewIO(&stdout, &stdout, strings.NewReader("")))
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, vm.LastPoppedStackElement(), []string{"early", "late"})
+	if stdout.String() != "inner\nouter\ninner\nouter\n" {
+		t.Errorf("wrong stdout. got=%q", stdout.String())
+	}
+
+	// uncaught errors keep the position they were first raised at
+	errorTests := []vmErrorTestCase{
+		{`throw {"message": "bad thing"}`, "1:1: bad thing"},
+		{"let f = fn() { try { throw 1 } finally { 2 } };\nf()", "1:22: 1"},
+		{`try { throw "a" } catch (e) { [][0] }`, "1:33: array index out of bounds: size=0, index=0"},
+	}
+	runVmErrorTests(t, errorTests)
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`match (2) { 1 => "one", 2 => "two", _ => "many" }`, "two"},
+		{`match (7) { 1 => "one", _ => "many" }`, "many"},
+		{`match (-3) { -3 => "minus three", n => n }`, "minus three"},
+		{`match ("b") { "a" => 1, s => s + s }`, "bb"},
+		{`match (1 < 2) { true => "yes", false => "no" }`, "yes"},
+		{`match ([1, 2, 3]) { [] => 0, [h, ...t] => h + len(t) }`, 3},
+		{`match ([1, 2]) { [a] => a, [a, b, c] => c, [a, b] => a + b }`, 3},
+		{`[match ([1]) { [_, ...] => "some", [] => "none" }, match ([]) { [_, ...] => "some", [] => "none" }]`, []string{"some", "none"}},
+		{`match ({"type": "circle", "r": 2}) { {"type": "square"} => 0, {"type": "circle", "r": r} => r * r }`, 4},
+		{`match ({"a": [1, 2]}) { {"a": [x, y]} => x + y }`, 3},
+		{`match ([1, [2]]) { [1, [y]] => y }`, 2},
+		{`match ("x") { [a] => 1, {"a": b} => 2, _ => 3 }`, 3},
+		{`let f = fn(n) { match (n) { x if x > 10 => "big", x if x > 0 => "small", _ => "none" } }; [f(20), f(5), f(0)]`, []string{"big", "small", "none"}},
+		{`let f = fn(xs) { match (xs) { [h, ...t] => h + f(t), [] => 0 } }; f([1, 2, 3])`, 6},
+		{`let r = match ([4, 5]) { [a, b] => a }; [r, b]`, []int{4, 5}},
+		{`match (1) { 1 => 2 } + match (2) { 2 => 3 }`, 5},
+		{`try { match (3) { 4 => 0 } } catch (e) { e.message }`, "no match for value: 3"},
+	}
+	runVmTests(t, tests)
+
+	errorTests := []vmErrorTestCase{
+		{"let x = 1;\nlet y = match ([x]) { [2] => 0 };", "2:9: no match for value: [1]"},
+	}
+	runVmErrorTests(t, errorTests)
+}
+
+func TestDeferStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{`let f = fn() { defer len([]); 1 }; f() + f()`, 2},
+		{`let f = fn() { defer len([]); }; f()`, NULL},
+		{`let f = fn(x) { if (x > 0) { defer len([]) } x }; [f(1), f(0)]`, []int{1, 0}},
+		{`let g = fn() { throw "in defer" }; let f = fn() { defer g(); 1 }; try { f() } catch (e) { e }`, "in defer"},
+		{`let f = fn(n) { defer len([n]); if (n == 0) { return 0 } n + f(n - 1) }; f(10)`, 55},
+		{`let f = fn(x) { defer len([x]); x * 2 }; map(f, [1, 2])`, []int{2, 4}},
+	}
+	runVmTests(t, tests)
+
+	input := `let f = fn(x) {
+  defer println("first", x);
+  defer println("second", x);
+  if (x) { return "early" }
+  "normal"
+};
+let fails = fn() { defer println("cleanup"); [][0] };
+let cleanupFails = fn() { defer fn() { throw "in defer" }(); defer println("still runs"); 1 };
+let noValue = fn() { defer println("last"); };
+let outer = fn() { defer println("outer"); fails() };
+[f(true), f(false), try { fails() } catch (e) { e.message }, try { cleanupFails() } catch (e) { e }, noValue(), try { outer() } catch (e) { 0 }]`
+	comp := compiler.New()
+	err := comp.Compile(parse(input))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	var stdout bytes.Buffer
+	vm := New(comp.Bytecode())
+	vm.SetIO(object.NewIO(&stdout, &stdout, strings.NewReader("")))
+	err = vm.Run()
+	if err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	expected := "[early, normal, array index out of bounds: size=0, index=0, in defer, null, 0]"
+	if vm.LastPoppedStackElement().Inspect() != expected {
+		t.Errorf("wrong result. want=%s, got=%s", expected, vm.LastPoppedStackElement().Inspect())
+	}
+	// deferred calls run last-in-first-out, with the arguments they were
+	// deferred with, including in frames an error unwinds
+	expectedStdout := "second true\nfirst true\nsecond false\nfirst false\ncleanup\nstill runs\nlast\ncleanup\nouter\n"
+	if stdout.String() != expectedStdout {
+		t.Errorf("wrong stdout. want=%q, got=%q", expectedStdout, stdout.String())
+	}
+
+	// a failing deferred call is reported at its defer statement
+	runtimeErr := runVmError(t, "let f = fn() {\n  defer json.parse(\"[\");\n  1\n};\nf()")
+	if runtimeErr.Position.String() != "2:3" || runtimeErr.Kind != object.BUILTIN_ERROR {
+		t.Fatalf("wrong vm error. got=%+v", runtimeErr)
+	}
+	expectedBacktrace := "f()\n\t2:3\nmain()\n\t5:2\n"
+	if runtimeErr.Backtrace.String() != expectedBacktrace {
+		t.Errorf("wrong backtrace.\nwant=%q\ngot=%q", expectedBacktrace, runtimeErr.Backtrace.String())
+	}
+}